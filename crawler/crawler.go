@@ -0,0 +1,278 @@
+// Package crawler 下载 DHT 网络中 announce 的种子元数据，解码后生成入库记录写入存储。
+// 爬虫、批量导入和离线测试共用同一套解码和记录生成逻辑
+package crawler
+
+import (
+	"DHT-ES-Search/release"
+	"DHT-ES-Search/tokenizer"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/shiyanhui/dht"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxRetries = 3
+	retryDelay = time.Second * 2
+)
+
+// File 种子中的一个文件，Path 为 info 字典中的路径分段
+type File struct {
+	Path   []interface{} `json:"path"`
+	Length int           `json:"length"`
+}
+
+// Torrent 解码后的种子信息，也是 JSONL 转储中一行的格式
+type Torrent struct {
+	InfoHash string `json:"infohash"`
+	Name     string `json:"name"`
+	Files    []File `json:"files,omitempty"`
+	Length   int    `json:"length,omitempty"`
+	Metadata []byte `json:"-"` // info 字典原始字节，可能为空
+}
+
+// Record 入库前的种子记录，由 NewRecord 统一生成
+type Record struct {
+	InfoHash  string
+	Name      string
+	Length    int
+	Files     []RecordFile
+	TextIndex string
+	Release   release.Info
+	Category  string
+	Metadata  []byte
+}
+
+// FileCount 返回文件数，单文件种子为 1
+func (r *Record) FileCount() int {
+	if len(r.Files) == 0 {
+		return 1
+	}
+	return len(r.Files)
+}
+
+type RecordFile struct {
+	Path   string
+	Length int
+}
+
+// Store 种子存储
+type Store interface {
+	SaveTorrent(ctx context.Context, bt *Torrent) error
+	Exists(ctx context.Context, infoHash string) (bool, error)
+}
+
+// 清理非法 UTF-8 字节，避免 utf8mb4 列拒绝写入
+func sanitizeUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
+// 拼接文件路径
+func joinPath(parts []interface{}) string {
+	elems := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if s, ok := p.(string); ok {
+			elems = append(elems, sanitizeUTF8(s))
+		}
+	}
+	return strings.Join(elems, "/")
+}
+
+// Decode 解码 ut_metadata 下载到的 info 字典
+func Decode(infoHash, metadataInfo []byte) (*Torrent, error) {
+	metadata, err := dht.Decode(metadataInfo)
+	if err != nil {
+		return nil, fmt.Errorf("解码元数据失败: %v", err)
+	}
+
+	info, ok := metadata.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("元数据类型错误")
+	}
+
+	name, ok := info["name"].(string)
+	if !ok {
+		return nil, errors.New("元数据缺少 name")
+	}
+
+	bt := &Torrent{
+		InfoHash: hex.EncodeToString(infoHash),
+		Name:     name,
+		Metadata: metadataInfo,
+	}
+
+	// 处理文件信息
+	if v, ok := info["files"].([]interface{}); ok {
+		bt.Files = make([]File, 0, len(v))
+		for _, item := range v {
+			f, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			path, _ := f["path"].([]interface{})
+			length, _ := f["length"].(int)
+			bt.Files = append(bt.Files, File{Path: path, Length: length})
+		}
+	} else if v, ok := info["length"].(int); ok {
+		bt.Length = v
+	}
+
+	return bt, nil
+}
+
+// NewRecord 生成入库记录：清洗名称、拼接路径、分词并解析发布信息
+func NewRecord(bt *Torrent, tok *tokenizer.Tokenizer) *Record {
+	rec := &Record{
+		InfoHash: bt.InfoHash,
+		Name:     sanitizeUTF8(bt.Name),
+		Length:   bt.Length,
+		Files:    make([]RecordFile, len(bt.Files)),
+		Metadata: bt.Metadata,
+	}
+
+	textIndex := rec.Name
+	var paths []string
+	var lengths []int
+	for i, f := range bt.Files {
+		rec.Length += f.Length
+		rec.Files[i] = RecordFile{Path: joinPath(f.Path), Length: f.Length}
+		textIndex += " " + rec.Files[i].Path
+		paths = append(paths, rec.Files[i].Path)
+		lengths = append(lengths, f.Length)
+	}
+
+	rec.TextIndex = tok.Index(textIndex)
+	rec.Release = release.Parse(rec.Name)
+	rec.Category = release.Category(rec.Name, rec.Release, paths, lengths)
+	return rec
+}
+
+// Retry 执行 operation，失败时间隔 2 秒重试，最多 3 次。logger 可为 nil
+func Retry(logger *log.Logger, operation func() error) error {
+	var err error
+	for i := 0; i < maxRetries; i++ {
+		if err = operation(); err == nil {
+			return nil
+		}
+		time.Sleep(retryDelay)
+		if logger != nil {
+			logger.Printf("操作失败，正在重试 (%d/%d): %v", i+1, maxRetries, err)
+		}
+	}
+	return err
+}
+
+// MemoryStore 内存存储，用于离线测试
+type MemoryStore struct {
+	tok    *tokenizer.Tokenizer
+	logger *log.Logger
+
+	mu       sync.Mutex
+	torrents map[string]*Record
+	counts   map[string]int
+}
+
+// NewMemoryStore 返回空的内存存储，logger 可为 nil
+func NewMemoryStore(tok *tokenizer.Tokenizer, logger *log.Logger) *MemoryStore {
+	return &MemoryStore{
+		tok:      tok,
+		logger:   logger,
+		torrents: make(map[string]*Record),
+		counts:   make(map[string]int),
+	}
+}
+
+func (s *MemoryStore) SaveTorrent(ctx context.Context, bt *Torrent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.torrents[bt.InfoHash]; ok {
+		s.counts[bt.InfoHash]++
+		s.logf("更新种子: %s", bt.InfoHash)
+		return nil
+	}
+
+	s.torrents[bt.InfoHash] = NewRecord(bt, s.tok)
+	s.logf("新增种子: %s, 文件数: %d", bt.InfoHash, len(bt.Files))
+	return nil
+}
+
+func (s *MemoryStore) Exists(ctx context.Context, infoHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.torrents[infoHash]
+	return ok, nil
+}
+
+// Get 返回已入库的记录
+func (s *MemoryStore) Get(infoHash string) (*Record, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.torrents[infoHash]
+	return rec, ok
+}
+
+func (s *MemoryStore) logf(format string, v ...interface{}) {
+	if s.logger != nil {
+		s.logger.Printf(format, v...)
+	}
+}
+
+// Crawler 接收 announce 通知，下载元数据并写入存储
+type Crawler struct {
+	Wire   *dht.Wire
+	store  Store
+	logger *log.Logger
+}
+
+// New 返回写入 store 的爬虫，logger 可为 nil。需要另外运行 Wire.Run 和 HandleResponses
+func New(store Store, logger *log.Logger) *Crawler {
+	return &Crawler{
+		Wire:   dht.NewWire(65536, 1024, 256),
+		store:  store,
+		logger: logger,
+	}
+}
+
+// OnAnnouncePeer 收到新的 peer 通知，向其请求元数据
+func (c *Crawler) OnAnnouncePeer(infoHash, ip string, port int) {
+	c.Wire.Request([]byte(infoHash), ip, port)
+}
+
+// HandleResponses 处理下载到的元数据，直到 ctx 结束
+func (c *Crawler) HandleResponses(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case resp := <-c.Wire.Response():
+			bt, err := Decode(resp.InfoHash, resp.MetadataInfo)
+			if err != nil {
+				c.logf("%v", err)
+				continue
+			}
+
+			// 使用重试机制处理种子信息
+			if err := Retry(c.logger, func() error {
+				return c.store.SaveTorrent(ctx, bt)
+			}); err != nil {
+				c.logf("处理种子失败: %v", err)
+			}
+		}
+	}
+}
+
+func (c *Crawler) logf(format string, v ...interface{}) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+	}
+}
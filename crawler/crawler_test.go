@@ -0,0 +1,115 @@
+package crawler
+
+import (
+	"DHT-ES-Search/harness"
+	"DHT-ES-Search/tokenizer"
+	"context"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// 本地假节点提供元数据，假 announce 触发爬虫，经 BEP 9/10 下载后写入内存存储
+func TestCrawlToStore(t *testing.T) {
+	peer, err := harness.NewFakePeer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	single, err := harness.SampleInfo("Show.Name.S02E05.1080p.WEB-DL.x265-GROUP.mkv", 1<<30, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi, err := harness.SampleInfo("[字幕组] 进击的巨人 第二季", 0, []harness.SampleFile{
+		{Path: "第01集.mkv", Length: 500 << 20},
+		{Path: "extras/Making.Of.mp4", Length: 80 << 20},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		info      []byte
+		wantName  string
+		wantFiles []RecordFile
+		wantLen   int
+		wantIndex string
+	}{
+		{
+			name:      "single file",
+			info:      single,
+			wantName:  "Show.Name.S02E05.1080p.WEB-DL.x265-GROUP.mkv",
+			wantLen:   1 << 30,
+			wantIndex: "show name s02e05 s 02 e 05 1080p 1080 p web dl x265 x 265 group mkv",
+		},
+		{
+			name:     "multi file",
+			info:     multi,
+			wantName: "[字幕组] 进击的巨人 第二季",
+			wantFiles: []RecordFile{
+				{Path: "第01集.mkv", Length: 500 << 20},
+				{Path: "extras/Making.Of.mp4", Length: 80 << 20},
+			},
+			wantLen:   580 << 20,
+			wantIndex: "字幕 幕组 进击 击的 的巨 巨人 第二 二季 第 01 集 mkv extras making mp4 mp 4",
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	store := NewMemoryStore(tokenizer.New(nil), nil)
+	c := New(store, nil)
+	go c.Wire.Run()
+	go c.HandleResponses(ctx)
+
+	announcer := harness.FakeAnnouncer{OnAnnouncePeer: c.OnAnnouncePeer}
+	hashes := make([]string, len(tests))
+	for i, tt := range tests {
+		h := peer.AddInfo(tt.info)
+		hashes[i] = hex.EncodeToString(h)
+		announcer.Announce(h, peer)
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := waitRecord(ctx, t, store, hashes[i])
+			if rec.Name != tt.wantName {
+				t.Errorf("Name = %q, want %q", rec.Name, tt.wantName)
+			}
+			if len(rec.Files) != len(tt.wantFiles) || len(tt.wantFiles) > 0 && !reflect.DeepEqual(rec.Files, tt.wantFiles) {
+				t.Errorf("Files = %+v, want %+v", rec.Files, tt.wantFiles)
+			}
+			if rec.Length != tt.wantLen {
+				t.Errorf("Length = %d, want %d", rec.Length, tt.wantLen)
+			}
+			if rec.TextIndex != tt.wantIndex {
+				t.Errorf("TextIndex = %q, want %q", rec.TextIndex, tt.wantIndex)
+			}
+			if string(rec.Metadata) != string(tt.info) {
+				t.Error("Metadata differs from the served info dictionary")
+			}
+		})
+	}
+}
+
+// 等待种子入库，超时时测试失败
+func waitRecord(ctx context.Context, t *testing.T, store *MemoryStore, infoHash string) *Record {
+	t.Helper()
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if rec, ok := store.Get(infoHash); ok {
+			return rec
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatalf("%s not stored before timeout", infoHash)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package crawler
+
+import (
+	"DHT-ES-Search/metainfo"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// JSONL 转储中的一行：与 Torrent 字段一致，或在 info 中以 base64 携带原始 info 字典
+type dumpRecord struct {
+	Torrent
+	Info []byte `json:"info,omitempty"`
+}
+
+// ParseDump 解析 JSONL 转储中的一行，export 导出的 JSONL 也按此读取。
+// 有 files 时 length 为导出的总大小，由文件大小重新累加，忽略 length
+func ParseDump(data []byte) (*Torrent, error) {
+	var rec dumpRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("解析 JSON 失败: %v", err)
+	}
+
+	if len(rec.Info) > 0 {
+		infoHash := metainfo.InfoHash(rec.Info)
+		if rec.InfoHash != "" && !strings.EqualFold(rec.InfoHash, hex.EncodeToString(infoHash)) {
+			return nil, fmt.Errorf("infohash 与 info 不符: %s", rec.InfoHash)
+		}
+		return Decode(infoHash, rec.Info)
+	}
+
+	bt := rec.Torrent
+	bt.InfoHash = strings.ToLower(bt.InfoHash)
+	if h, err := hex.DecodeString(bt.InfoHash); err != nil || len(h) != 20 {
+		return nil, fmt.Errorf("无效的 infohash: %q", rec.InfoHash)
+	}
+	if bt.Name == "" {
+		return nil, errors.New("缺少 name")
+	}
+	if len(bt.Files) > 0 {
+		bt.Length = 0
+	}
+	return &bt, nil
+}
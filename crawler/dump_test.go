@@ -0,0 +1,114 @@
+package crawler
+
+import (
+	"DHT-ES-Search/exporter"
+	"DHT-ES-Search/harness"
+	"DHT-ES-Search/tokenizer"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+// export 导出的 JSONL 经 import 读回后，大小和文件与原记录一致
+func TestExportImportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   exporter.Torrent
+	}{
+		{
+			name: "single file",
+			in: exporter.Torrent{
+				InfoHash: "AABBCCDDEEFF00112233445566778899AABBCCDD",
+				Name:     "ubuntu-24.04-desktop-amd64.iso",
+				Length:   6114656256,
+			},
+		},
+		{
+			name: "multi file",
+			in: exporter.Torrent{
+				InfoHash: "00112233445566778899aabbccddeeff00112233",
+				Name:     "Album",
+				Length:   300,
+				Files: []exporter.File{
+					{Path: []string{"CD1", "01.flac"}, Length: 100},
+					{Path: []string{"CD2", "01.flac"}, Length: 200},
+				},
+			},
+		},
+	}
+
+	tok := tokenizer.New(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, err := json.Marshal(&tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bt, err := ParseDump(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := NewRecord(bt, tok)
+
+			if want := hex.EncodeToString(mustDecodeHex(t, tt.in.InfoHash)); rec.InfoHash != want {
+				t.Errorf("InfoHash = %q, want %q", rec.InfoHash, want)
+			}
+			if rec.Name != tt.in.Name {
+				t.Errorf("Name = %q, want %q", rec.Name, tt.in.Name)
+			}
+			if int64(rec.Length) != tt.in.Length {
+				t.Errorf("Length = %d, want %d", rec.Length, tt.in.Length)
+			}
+			if len(rec.Files) != len(tt.in.Files) {
+				t.Fatalf("got %d files, want %d", len(rec.Files), len(tt.in.Files))
+			}
+			for i, f := range tt.in.Files {
+				if got := rec.Files[i]; got.Length != int(f.Length) || got.Path != f.Path[0]+"/"+f.Path[1] {
+					t.Errorf("file %d = %+v, want %+v", i, got, f)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDumpInfo(t *testing.T) {
+	info, err := harness.SampleInfo("Show", 0, []harness.SampleFile{{Path: "a.mkv", Length: 10}, {Path: "b.mkv", Length: 20}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _ := json.Marshal(map[string]interface{}{"info": info})
+
+	bt, err := ParseDump(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := NewRecord(bt, tokenizer.New(nil)); rec.Name != "Show" || rec.Length != 30 || len(rec.Files) != 2 {
+		t.Errorf("record = %q %d %d files", rec.Name, rec.Length, len(rec.Files))
+	}
+
+	line, _ = json.Marshal(map[string]interface{}{"info": info, "infohash": "0000000000000000000000000000000000000000"})
+	if _, err := ParseDump(line); err == nil {
+		t.Error("mismatched infohash accepted")
+	}
+}
+
+func TestParseDumpInvalid(t *testing.T) {
+	for _, line := range []string{
+		`{"infohash": "xyz", "name": "a"}`,
+		`{"infohash": "00112233445566778899aabbccddeeff00112233"}`,
+		`not json`,
+	} {
+		if _, err := ParseDump([]byte(line)); err == nil {
+			t.Errorf("ParseDump(%s) succeeded", line)
+		}
+	}
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,152 @@
+package cursor
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newSigner(t *testing.T, key string) *Signer {
+	t.Helper()
+	s, err := NewSigner([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Cursor
+		want Cursor
+	}{
+		{
+			name: "page jump",
+			in:   Cursor{Query: "ubuntu", Order: "updated", Page: 3},
+			want: Cursor{Query: "ubuntu", Order: "updated", Page: 3},
+		},
+		{
+			// 排序值中的 long 不能经 float64 丢失精度
+			name: "next page",
+			in: Cursor{Query: `"the matrix" size:>1GB`, Filter: "resolution=1080p", Order: "cnt", Asc: true, Page: 2,
+				After: []interface{}{json.Number("1700000000123456789"), int64(42)}, PIT: "pit-id=="},
+			want: Cursor{Query: `"the matrix" size:>1GB`, Filter: "resolution=1080p", Order: "cnt", Asc: true, Page: 2,
+				After: []interface{}{json.Number("1700000000123456789"), json.Number("42")}, PIT: "pit-id=="},
+		},
+		{
+			name: "previous page",
+			in:   Cursor{Query: "进击的巨人", Order: "name", Page: 4, Before: []interface{}{"2024-01-02 03:04:05", 7}},
+			want: Cursor{Query: "进击的巨人", Order: "name", Page: 4, Before: []interface{}{"2024-01-02 03:04:05", json.Number("7")}},
+		},
+		{
+			name: "relevance score",
+			in:   Cursor{Query: "a", Order: "relevance", Page: 2, After: []interface{}{1.25, 9}},
+			want: Cursor{Query: "a", Order: "relevance", Page: 2, After: []interface{}{json.Number("1.25"), json.Number("9")}},
+		},
+	}
+
+	s := newSigner(t, "secret")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := s.Encode(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.ContainsAny(token, "+/=?&# ") {
+				t.Errorf("token %q is not URL safe", token)
+			}
+			got, err := s.Decode(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Decode = %#v, want %#v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	s := newSigner(t, "secret")
+	enc := base64.RawURLEncoding
+
+	valid, err := s.Encode(Cursor{Query: "ubuntu", Order: "updated", Page: 2, After: []interface{}{5, 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, sig, _ := strings.Cut(valid, ".")
+
+	// 改写内容后沿用原签名
+	payload, _ := enc.DecodeString(data)
+	tampered := enc.EncodeToString([]byte(strings.Replace(string(payload), `"pg":2`, `"pg":9`, 1))) + "." + sig
+	if tampered == valid {
+		t.Fatalf("payload %s was not changed", payload)
+	}
+
+	// 改动签名的一个字节
+	mac, _ := enc.DecodeString(sig)
+	mac[0] ^= 1
+	badSig := data + "." + enc.EncodeToString(mac)
+
+	other, err := newSigner(t, "other secret").Encode(Cursor{Query: "ubuntu", Order: "updated", Page: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 签名正确但内容不合法
+	zeroPage, _ := s.Encode(Cursor{Query: "ubuntu", Page: 0})
+	negativePage, _ := s.Encode(Cursor{Query: "ubuntu", Page: -1})
+	both, _ := s.Encode(Cursor{Query: "ubuntu", Page: 2, After: []interface{}{1, 2}, Before: []interface{}{3, 4}})
+	notJSON := enc.EncodeToString([]byte("not json"))
+	notJSON += "." + enc.EncodeToString(s.sign([]byte("not json")))
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"tampered payload", tampered},
+		{"tampered signature", badSig},
+		{"other secret", other},
+		{"page 0", zeroPage},
+		{"negative page", negativePage},
+		{"after and before", both},
+		{"signed garbage", notJSON},
+		{"missing signature", data},
+		{"empty signature", data + "."},
+		{"bad base64", "!!!." + sig},
+		{"empty", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c, err := s.Decode(tt.token); !errors.Is(err, ErrInvalid) {
+				t.Errorf("Decode(%q) = %+v, %v, want ErrInvalid", tt.token, c, err)
+			}
+		})
+	}
+
+	if _, err := s.Decode(valid); err != nil {
+		t.Errorf("untouched token rejected: %v", err)
+	}
+}
+
+// 未配置密钥时每个 Signer 使用各自的随机密钥
+func TestRandomKey(t *testing.T) {
+	a := newSigner(t, "")
+	b := newSigner(t, "")
+
+	token, err := a.Encode(Cursor{Query: "ubuntu", Page: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Decode(token); err != nil {
+		t.Errorf("same signer rejected its token: %v", err)
+	}
+	if _, err := b.Decode(token); !errors.Is(err, ErrInvalid) {
+		t.Errorf("token accepted by a signer with another random key: %v", err)
+	}
+}
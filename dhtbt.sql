@@ -16,16 +16,21 @@
 
 
 -- 导出 dhtbt 的数据库结构
-CREATE DATABASE IF NOT EXISTS `dhtbt` /*!40100 DEFAULT CHARACTER SET utf8 */;
+CREATE DATABASE IF NOT EXISTS `dhtbt` /*!40100 DEFAULT CHARACTER SET utf8mb4 */;
 USE `dhtbt`;
 
 -- 导出  表 dhtbt.files 结构
 CREATE TABLE IF NOT EXISTS `files` (
+  `id` bigint(20) NOT NULL AUTO_INCREMENT,
   `infohash_id` int(11) NOT NULL,
-  `path` varchar(250) NOT NULL,
+  `idx` int(11) NOT NULL DEFAULT '0',
+  `path` text NOT NULL,
+  `path_hash` char(40) NOT NULL,
   `length` bigint(40) NOT NULL,
-  KEY `infohash_id` (`infohash_id`)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `infohash_id_idx` (`infohash_id`,`idx`),
+  KEY `path_hash` (`path_hash`)
+) ENGINE=InnoDB ROW_FORMAT=DYNAMIC DEFAULT CHARSET=utf8mb4;
 
 -- 数据导出被取消选择。
 
@@ -33,20 +38,48 @@ CREATE TABLE IF NOT EXISTS `files` (
 CREATE TABLE IF NOT EXISTS `infohash` (
   `id` int(11) NOT NULL AUTO_INCREMENT,
   `infohash` varchar(40) NOT NULL,
-  `name` varchar(250) NOT NULL,
+  `name` text NOT NULL,
   `length` bigint(40) NOT NULL,
   `files` tinyint(1) NOT NULL,
+  `file_count` int(11) NOT NULL DEFAULT '1',
   `addeded` datetime NOT NULL,
   `updated` datetime NOT NULL,
   `cnt` int(11) NOT NULL DEFAULT '0',
   `textindex` mediumtext NOT NULL,
+  `title` text NOT NULL,
+  `year` smallint(6) NOT NULL DEFAULT '0',
+  `season` smallint(6) NOT NULL DEFAULT '0',
+  `episode` int(11) NOT NULL DEFAULT '0',
+  `resolution` varchar(16) NOT NULL DEFAULT '',
+  `video_codec` varchar(16) NOT NULL DEFAULT '',
+  `audio_codec` varchar(16) NOT NULL DEFAULT '',
+  `source` varchar(16) NOT NULL DEFAULT '',
+  `release_group` varchar(255) NOT NULL DEFAULT '',
+  `languages` varchar(64) NOT NULL DEFAULT '',
+  `category` varchar(16) NOT NULL DEFAULT '',
   PRIMARY KEY (`id`),
   KEY `cnt` (`cnt`),
   KEY `updated` (`updated`),
   KEY `addeded` (`addeded`),
   KEY `infohash` (`infohash`),
+  KEY `year` (`year`),
+  KEY `season_episode` (`season`,`episode`),
+  KEY `resolution` (`resolution`),
+  KEY `source` (`source`),
+  KEY `category` (`category`),
+  KEY `file_count` (`file_count`),
+  KEY `length` (`length`),
   FULLTEXT KEY `textindex` (`textindex`)
-) ENGINE=InnoDB AUTO_INCREMENT=267277 DEFAULT CHARSET=utf8;
+) ENGINE=InnoDB AUTO_INCREMENT=267277 ROW_FORMAT=DYNAMIC DEFAULT CHARSET=utf8mb4;
+
+-- 数据导出被取消选择。
+
+-- 导出  表 dhtbt.metadata 结构
+CREATE TABLE IF NOT EXISTS `metadata` (
+  `infohash_id` int(11) NOT NULL,
+  `info` mediumblob NOT NULL,
+  PRIMARY KEY (`infohash_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 -- 数据导出被取消选择。
 
@@ -0,0 +1,178 @@
+// Package embedded 是不依赖外部服务的嵌入式全文索引。
+// 爬虫通过 Writer 把种子追加到数据目录下的记录日志，webinterface 通过 Index
+// 读取日志并建立倒排索引，索引定期以快照形式保存到同一目录，重启时只需回放快照之后的日志。
+package embedded
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 数据目录下的文件
+const (
+	logFileName      = "torrents.log"
+	snapshotFileName = "index.snap"
+)
+
+// 日志记录类型
+const (
+	opAdd   = "add"   // 新种子
+	opTouch = "touch" // 再次收到已有种子，更新时间并累加热度
+)
+
+// TimeLayout 记录中的时间格式，与 MySQL datetime 一致
+const TimeLayout = "2006-01-02 15:04:05"
+
+// Document 一个种子
+type Document struct {
+	InfoHash     string   `json:"infohash"`
+	Name         string   `json:"name"`
+	Length       int64    `json:"length"`
+	Files        []File   `json:"files,omitempty"`
+	TextIndex    string   `json:"textindex"`
+	Title        string   `json:"title,omitempty"`
+	Year         int      `json:"year,omitempty"`
+	Season       int      `json:"season,omitempty"`
+	Episode      int      `json:"episode,omitempty"`
+	Resolution   string   `json:"resolution,omitempty"`
+	VideoCodec   string   `json:"video_codec,omitempty"`
+	AudioCodec   string   `json:"audio_codec,omitempty"`
+	Source       string   `json:"source,omitempty"`
+	ReleaseGroup string   `json:"release_group,omitempty"`
+	Languages    []string `json:"languages,omitempty"`
+	Category     string   `json:"category,omitempty"`
+}
+
+// File 种子内的文件
+type File struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+// 日志中的一行
+type record struct {
+	Op   string `json:"op"`
+	Time string `json:"time"`
+	Document
+}
+
+// Writer 向数据目录追加记录。同一目录同时只应有一个写入进程；
+// 多个进程重复写入的新种子在读取时按 infohash 去重
+type Writer struct {
+	mu    sync.Mutex
+	f     *os.File
+	known map[string]bool
+}
+
+// OpenWriter 打开数据目录，不存在时创建
+func OpenWriter(dir string) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, logFileName)
+	known := make(map[string]bool)
+
+	// 读取已有记录，用于区分新种子和已有种子
+	if f, err := os.Open(path); err == nil {
+		end, err := readLog(f, 0, func(rec *record) {
+			known[rec.InfoHash] = true
+		})
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %v", path, err)
+		}
+		// 上次写入中断留下的半行会与之后追加的记录连成一行，截掉
+		if err := truncateTail(path, end); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{f: f, known: known}, nil
+}
+
+// Exists 返回种子是否已写入
+func (w *Writer) Exists(infoHash string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.known[infoHash]
+}
+
+// Save 写入种子，已存在时只更新时间和热度。返回是否为新种子
+func (w *Writer) Save(doc *Document) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rec := record{Op: opAdd, Time: time.Now().Format(TimeLayout), Document: *doc}
+	if w.known[doc.InfoHash] {
+		rec = record{Op: opTouch, Time: rec.Time, Document: Document{InfoHash: doc.InfoHash}}
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	// 一次写入整行，读取方不会看到半行以外的内容
+	if _, err := w.f.Write(append(data, '\n')); err != nil {
+		return false, err
+	}
+
+	isNew := rec.Op == opAdd
+	w.known[doc.InfoHash] = true
+	return isNew, nil
+}
+
+// Close 关闭日志文件
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// 文件比 end 长时截断到 end
+func truncateTail(path string, end int64) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > end {
+		return os.Truncate(path, end)
+	}
+	return nil
+}
+
+// 从 offset 开始读取完整的行，返回读到的位置；末尾不完整的行留到下次读取
+func readLog(f *os.File, offset int64, fn func(*record)) (int64, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+
+	r := bufio.NewReaderSize(f, 1<<20)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+
+		var rec record
+		if err := json.Unmarshal(line, &rec); err != nil || rec.InfoHash == "" {
+			// 损坏的行直接跳过
+			continue
+		}
+		fn(&rec)
+	}
+}
@@ -0,0 +1,419 @@
+package embedded
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 快照格式版本，结构变化时递增，旧快照会被忽略并从日志重建
+const snapshotVersion = 1
+
+// 两次保存快照的最小间隔
+const saveInterval = time.Minute
+
+// Doc 索引中的种子，ID 为首次出现在日志中的顺序，从 1 开始
+type Doc struct {
+	ID      int64
+	Addeded string
+	Updated string
+	Cnt     int
+	Document
+}
+
+// Index 内存中的倒排索引，数据来自 Writer 写入的日志
+type Index struct {
+	dir string
+
+	mu       sync.RWMutex
+	docs     []*Doc // 下标为 ID-1，元素只整体替换，不修改
+	byHash   map[string]int32
+	postings map[string][]int32 // 词 -> 文档下标，升序
+	terms    []string           // postings 中的全部词，有序，用于前缀匹配
+	stale    bool               // 有新词，terms 需要重建
+	offset   int64              // 已读取的日志位置
+	dirty    bool
+	lastSave time.Time
+}
+
+type snapshot struct {
+	Version  int
+	Offset   int64
+	Docs     []*Doc
+	Postings map[string][]int32
+}
+
+// Open 打开数据目录：加载快照后回放之后的日志
+func Open(dir string) (*Index, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ix := &Index{
+		dir:      dir,
+		byHash:   make(map[string]int32),
+		postings: make(map[string][]int32),
+	}
+	if err := ix.load(); err != nil {
+		return nil, err
+	}
+	if _, err := ix.Refresh(); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+func (ix *Index) load() error {
+	f, err := os.Open(filepath.Join(ix.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil || snap.Version != snapshotVersion {
+		// 快照损坏或版本不符时从头回放日志
+		return nil
+	}
+
+	ix.docs = snap.Docs
+	ix.postings = snap.Postings
+	ix.offset = snap.Offset
+	ix.stale = true
+	for i, doc := range ix.docs {
+		ix.byHash[doc.InfoHash] = int32(i)
+	}
+	ix.lastSave = time.Now()
+	return nil
+}
+
+// Refresh 读取日志中新增的记录，返回处理的记录数
+func (ix *Index) Refresh() (int, error) {
+	f, err := os.Open(filepath.Join(ix.dir, logFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	// 日志比已读取的位置短，说明被截断或替换，丢弃现有数据从头回放
+	if fi, err := f.Stat(); err == nil && fi.Size() < ix.offset {
+		ix.reset()
+	}
+
+	n := 0
+	offset, err := readLog(f, ix.offset, func(rec *record) {
+		ix.apply(rec)
+		n++
+	})
+	ix.offset = offset
+	if n > 0 {
+		ix.dirty = true
+	}
+	if ix.stale {
+		ix.terms = make([]string, 0, len(ix.postings))
+		for term := range ix.postings {
+			ix.terms = append(ix.terms, term)
+		}
+		sort.Strings(ix.terms)
+		ix.stale = false
+	}
+	return n, err
+}
+
+func (ix *Index) reset() {
+	ix.docs = nil
+	ix.byHash = make(map[string]int32)
+	ix.postings = make(map[string][]int32)
+	ix.offset = 0
+	ix.stale = true
+	ix.dirty = true
+}
+
+func (ix *Index) apply(rec *record) {
+	if i, ok := ix.byHash[rec.InfoHash]; ok {
+		// 已有种子：复制后替换，正在使用旧指针的查询不受影响
+		doc := *ix.docs[i]
+		doc.Updated = rec.Time
+		doc.Cnt++
+		ix.docs[i] = &doc
+		return
+	}
+	if rec.Op != opAdd {
+		return
+	}
+
+	i := int32(len(ix.docs))
+	doc := &Doc{
+		ID:       int64(i) + 1,
+		Addeded:  rec.Time,
+		Updated:  rec.Time,
+		Document: rec.Document,
+	}
+	ix.docs = append(ix.docs, doc)
+	ix.byHash[doc.InfoHash] = i
+
+	// textindex 已由分词器处理，以空格分隔
+	seen := make(map[string]bool)
+	for _, term := range strings.Fields(doc.TextIndex) {
+		if !seen[term] {
+			seen[term] = true
+			if _, ok := ix.postings[term]; !ok {
+				ix.stale = true
+			}
+			ix.postings[term] = append(ix.postings[term], i)
+		}
+	}
+}
+
+// Len 返回种子总数
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// All 返回全部种子，按 ID 升序
+func (ix *Index) All() []*Doc {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return append([]*Doc(nil), ix.docs...)
+}
+
+// Get 按 ID 返回种子
+func (ix *Index) Get(id int64) (*Doc, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	if id < 1 || id > int64(len(ix.docs)) {
+		return nil, false
+	}
+	return ix.docs[id-1], true
+}
+
+// Match 返回包含全部词的种子，按 ID 升序
+func (ix *Index) Match(terms []string) []*Doc {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	lists := make([][]int32, 0, len(terms))
+	for _, term := range terms {
+		list := ix.postings[term]
+		if len(list) == 0 {
+			return nil
+		}
+		lists = append(lists, list)
+	}
+	if len(lists) == 0 {
+		return nil
+	}
+
+	// 从最短的列表开始求交集
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+	result := lists[0]
+	for _, list := range lists[1:] {
+		result = intersect(result, list)
+		if len(result) == 0 {
+			return nil
+		}
+	}
+
+	docs := make([]*Doc, len(result))
+	for i, idx := range result {
+		docs[i] = ix.docs[idx]
+	}
+	return docs
+}
+
+// Terms 返回以 prefix 开头的词，按文档数降序，最多 n 个
+func (ix *Index) Terms(prefix string, n int) []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var res []string
+	for i := sort.SearchStrings(ix.terms, prefix); i < len(ix.terms) && strings.HasPrefix(ix.terms[i], prefix); i++ {
+		res = append(res, ix.terms[i])
+	}
+	sort.SliceStable(res, func(i, j int) bool { return len(ix.postings[res[i]]) > len(ix.postings[res[j]]) })
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
+
+// DocFreq 返回包含 term 的种子数
+func (ix *Index) DocFreq(term string) int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.postings[term])
+}
+
+// Similar 返回与 term 编辑距离不超过 maxEdits 的词，距离最小者优先，其次文档数多者。
+// 与 ES 的 prefix_length 1 相同，只在首字符相同的词中查找
+func (ix *Index) Similar(term string, maxEdits int) (string, bool) {
+	r, size := utf8.DecodeRuneInString(term)
+	if size == 0 {
+		return "", false
+	}
+	first := string(r)
+	want := []rune(term)
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	best, bestDist, bestFreq := "", maxEdits+1, 0
+	for i := sort.SearchStrings(ix.terms, first); i < len(ix.terms) && strings.HasPrefix(ix.terms[i], first); i++ {
+		cand := ix.terms[i]
+		if cand == term {
+			continue
+		}
+		d := editDistance(want, []rune(cand), maxEdits)
+		freq := len(ix.postings[cand])
+		if d < bestDist || d == bestDist && freq > bestFreq {
+			best, bestDist, bestFreq = cand, d, freq
+		}
+	}
+	return best, best != ""
+}
+
+// Levenshtein 距离，超过 max 时提前返回 max+1
+func editDistance(a, b []rune, max int) int {
+	if d := len(a) - len(b); d > max || -d > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if cur[j] < rowMin {
+				rowMin = cur[j]
+			}
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func intersect(a, b []int32) []int32 {
+	res := make([]int32, 0, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			res = append(res, a[i])
+			i++
+			j++
+		}
+	}
+	return res
+}
+
+// Save 有新数据时把索引写入快照，先写临时文件再重命名。
+// 持锁时只复制文档列表和倒排表的引用，编码和写入在锁外进行，不阻塞查询和 Refresh
+func (ix *Index) Save() error {
+	ix.mu.RLock()
+	if !ix.dirty {
+		ix.mu.RUnlock()
+		return nil
+	}
+	// 文档只整体替换，倒排列表只追加，复制引用即得到当前的一致视图
+	snap := snapshot{
+		Version:  snapshotVersion,
+		Offset:   ix.offset,
+		Docs:     append([]*Doc(nil), ix.docs...),
+		Postings: make(map[string][]int32, len(ix.postings)),
+	}
+	for term, list := range ix.postings {
+		snap.Postings[term] = list
+	}
+	ix.mu.RUnlock()
+
+	path := filepath.Join(ix.dir, snapshotFileName)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err == nil {
+		err = gob.NewEncoder(f).Encode(snap)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write snapshot: %v", err)
+	}
+
+	ix.mu.Lock()
+	if ix.offset == snap.Offset {
+		ix.dirty = false
+	}
+	ix.lastSave = time.Now()
+	ix.mu.Unlock()
+	return nil
+}
+
+// Watch 定期读取新记录并保存快照，直到 ctx 结束
+func (ix *Index) Watch(ctx context.Context, interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := ix.Save(); err != nil && logger != nil {
+				logger.Printf("Embedded index save failed: %v", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := ix.Refresh(); err != nil && logger != nil {
+			logger.Printf("Embedded index refresh failed: %v", err)
+		}
+
+		ix.mu.RLock()
+		due := ix.dirty && time.Since(ix.lastSave) >= saveInterval
+		ix.mu.RUnlock()
+		if due {
+			if err := ix.Save(); err != nil && logger != nil {
+				logger.Printf("Embedded index save failed: %v", err)
+			}
+		}
+	}
+}
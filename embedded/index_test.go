@@ -0,0 +1,291 @@
+package embedded
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func testDoc(hash, name, textIndex string, files ...File) *Document {
+	return &Document{InfoHash: hash, Name: name, TextIndex: textIndex, Length: 100, Files: files}
+}
+
+// 写入一批种子，再次写入第一个种子以累加热度
+func writeDocs(t *testing.T, dir string, docs ...*Document) {
+	t.Helper()
+	w, err := OpenWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for _, doc := range docs {
+		if _, err := w.Save(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func appendLog(t *testing.T, dir, data string) {
+	t.Helper()
+	f, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func names(docs []*Doc) []string {
+	var res []string
+	for _, d := range docs {
+		res = append(res, d.Name)
+	}
+	return res
+}
+
+func TestWriterIndex(t *testing.T) {
+	dir := t.TempDir()
+	a := testDoc("aa", "Ubuntu Desktop", "ubuntu desktop")
+	b := testDoc("bb", "Ubuntu Server", "ubuntu server", File{Path: "server.iso", Length: 100})
+	writeDocs(t, dir, a, b, a, a)
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", ix.Len())
+	}
+	if d, _ := ix.Get(1); d.InfoHash != "aa" || d.Cnt != 2 || d.Name != "Ubuntu Desktop" {
+		t.Errorf("doc 1 = %+v", d)
+	}
+	if got := names(ix.Match([]string{"ubuntu"})); !reflect.DeepEqual(got, []string{"Ubuntu Desktop", "Ubuntu Server"}) {
+		t.Errorf("Match(ubuntu) = %q", got)
+	}
+	if got := names(ix.Match([]string{"ubuntu", "server"})); !reflect.DeepEqual(got, []string{"Ubuntu Server"}) {
+		t.Errorf("Match(ubuntu server) = %q", got)
+	}
+	if got := ix.Match([]string{"ubuntu", "missing"}); got != nil {
+		t.Errorf("Match(ubuntu missing) = %q", names(got))
+	}
+	if got := ix.Terms("s", 10); !reflect.DeepEqual(got, []string{"server"}) {
+		t.Errorf("Terms(s) = %q", got)
+	}
+	if got, ok := ix.Similar("desktap", 1); !ok || got != "desktop" {
+		t.Errorf("Similar(desktap) = %q", got)
+	}
+
+	// 重复打开 Writer 时已有种子只记录热度
+	writeDocs(t, dir, b, testDoc("cc", "Fedora", "fedora"))
+	if n, err := ix.Refresh(); err != nil || n != 2 {
+		t.Fatalf("Refresh = %d, %v, want 2 records", n, err)
+	}
+	if d, _ := ix.Get(2); d.Cnt != 1 || len(d.Files) != 1 {
+		t.Errorf("doc 2 = %+v", d)
+	}
+	if got := names(ix.Match([]string{"fedora"})); !reflect.DeepEqual(got, []string{"Fedora"}) {
+		t.Errorf("Match(fedora) = %q", got)
+	}
+}
+
+// 快照保存后重新打开，只回放快照之后的日志，结果与从头回放一致
+func TestSaveOpen(t *testing.T) {
+	dir := t.TempDir()
+	writeDocs(t, dir, testDoc("aa", "A", "alpha common"), testDoc("bb", "B", "beta common"))
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatalf("snapshot not written: %v", err)
+	}
+
+	writeDocs(t, dir, testDoc("cc", "C", "gamma common"), testDoc("aa", "A", ""))
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.offset <= ix.offset {
+		t.Errorf("reopened offset %d, want past snapshot offset %d", reopened.offset, ix.offset)
+	}
+
+	// 没有快照时从头回放
+	if err := os.Remove(filepath.Join(dir, snapshotFileName)); err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(reopened.All(), rebuilt.All()) {
+		t.Errorf("snapshot docs %+v\nrebuilt docs %+v", reopened.All(), rebuilt.All())
+	}
+	if !reflect.DeepEqual(reopened.postings, rebuilt.postings) {
+		t.Errorf("snapshot postings %v\nrebuilt postings %v", reopened.postings, rebuilt.postings)
+	}
+	if !reflect.DeepEqual(reopened.terms, rebuilt.terms) {
+		t.Errorf("snapshot terms %q\nrebuilt terms %q", reopened.terms, rebuilt.terms)
+	}
+	if got := names(reopened.Match([]string{"common"})); !reflect.DeepEqual(got, []string{"A", "B", "C"}) {
+		t.Errorf("Match(common) = %q", got)
+	}
+}
+
+// 快照版本不符或损坏时忽略快照，从日志重建
+func TestOpenBadSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeDocs(t, dir, testDoc("aa", "A", "alpha"))
+	if err := os.WriteFile(filepath.Join(dir, snapshotFileName), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ix.Len() != 1 {
+		t.Errorf("Len = %d, want 1", ix.Len())
+	}
+}
+
+// 写入中断留下的半行：读取方等待整行，Writer 重新打开时截掉
+func TestPartialLine(t *testing.T) {
+	dir := t.TempDir()
+	writeDocs(t, dir, testDoc("aa", "A", "alpha"))
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendLog(t, dir, `{"op":"add","time":"2024-01-01 00:00:00","infohash":"bb","na`)
+	if n, err := ix.Refresh(); err != nil || n != 0 {
+		t.Fatalf("Refresh with partial line = %d, %v, want 0 records", n, err)
+	}
+	appendLog(t, dir, `me":"B","textindex":"beta"}`+"\n")
+	if n, err := ix.Refresh(); err != nil || n != 1 {
+		t.Fatalf("Refresh after line completed = %d, %v, want 1 record", n, err)
+	}
+
+	// 爬虫在写入半行后退出，重启后的记录不能接在半行后面
+	appendLog(t, dir, `{"op":"add","time":"2024-01-01 00:00:00","infohash":"xx","na`)
+	writeDocs(t, dir, testDoc("cc", "C", "gamma"))
+	if n, err := ix.Refresh(); err != nil || n != 1 {
+		t.Fatalf("Refresh after writer restart = %d, %v, want 1 record", n, err)
+	}
+	if got := names(ix.All()); !reflect.DeepEqual(got, []string{"A", "B", "C"}) {
+		t.Errorf("docs = %q", got)
+	}
+
+	// 损坏的整行跳过
+	appendLog(t, dir, "not json\n")
+	writeDocs(t, dir, testDoc("dd", "D", "delta"))
+	if n, err := ix.Refresh(); err != nil || n != 1 {
+		t.Fatalf("Refresh after corrupt line = %d, %v, want 1 record", n, err)
+	}
+}
+
+// 日志被截断或替换为更短的文件时，快照之后的位置已不存在，从头重建
+func TestTruncatedLog(t *testing.T) {
+	dir := t.TempDir()
+	writeDocs(t, dir, testDoc("aa", "A", "alpha"), testDoc("bb", "B", "beta"), testDoc("cc", "C", "gamma"))
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 从备份恢复了较旧的日志
+	if err := os.Remove(filepath.Join(dir, logFileName)); err != nil {
+		t.Fatal(err)
+	}
+	writeDocs(t, dir, testDoc("bb", "B", "beta"))
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(reopened.All()); !reflect.DeepEqual(got, []string{"B"}) {
+		t.Errorf("docs after truncation = %q, want [B]", got)
+	}
+	if got := reopened.Match([]string{"alpha"}); got != nil {
+		t.Errorf("Match(alpha) = %q, want none", names(got))
+	}
+
+	// 运行中被截断时下次 Refresh 重建
+	writeDocs(t, dir, testDoc("dd", "D", "delta"), testDoc("ee", "E", "epsilon"))
+	if _, err := reopened.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(dir, logFileName), 0); err != nil {
+		t.Fatal(err)
+	}
+	writeDocs(t, dir, testDoc("ff", "F", "zeta"))
+	if _, err := reopened.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(reopened.All()); !reflect.DeepEqual(got, []string{"F"}) {
+		t.Errorf("docs after truncation = %q, want [F]", got)
+	}
+}
+
+// 保存快照与读取新记录、查询同时进行，用 -race 检查
+func TestSaveConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			hash := fmt.Sprintf("%02x", i%150)
+			w.Save(testDoc(hash, "doc "+hash, "common t"+hash))
+			if _, err := ix.Refresh(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			if err := ix.Save(); err != nil {
+				t.Error(err)
+			}
+			ix.Match([]string{"common"})
+		}
+	}()
+	wg.Wait()
+
+	if err := ix.Save(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.All(), ix.All()) {
+		t.Error("snapshot differs from the live index")
+	}
+}
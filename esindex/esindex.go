@@ -0,0 +1,265 @@
+// Package esindex 管理 Elasticsearch 中的种子索引：维护索引模板，创建带版本号的索引，
+// 从 MySQL 批量导入，校验文档数量后原子地切换读别名，实现不停机重建索引。
+package esindex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultAlias 默认读别名，webinterface 只通过别名访问索引
+const DefaultAlias = "infohash"
+
+// IndexName 返回别名对应的第 version 版索引名，如 infohash_v3
+func IndexName(alias string, version int) string {
+	return fmt.Sprintf("%s_v%d", alias, version)
+}
+
+// 解析索引名中的版本号，不是该别名的版本索引时返回 0
+func versionOf(alias, index string) int {
+	prefix := alias + "_v"
+	if !strings.HasPrefix(index, prefix) {
+		return 0
+	}
+	n, err := strconv.Atoi(index[len(prefix):])
+	if err != nil || n < 1 {
+		return 0
+	}
+	return n
+}
+
+// 检查响应状态并解析响应体，out 为 nil 时丢弃响应体
+func decode(res *esapi.Response, err error, out interface{}) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		body, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("%s: %s", res.Status(), strings.TrimSpace(string(body)))
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func jsonBody(v interface{}) (io.Reader, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// Versions 返回别名下已存在的所有版本索引，按版本号升序
+func Versions(ctx context.Context, es *elasticsearch.Client, alias string) ([]string, error) {
+	var indices map[string]interface{}
+	res, err := es.Indices.Get([]string{alias + "_v*"},
+		es.Indices.Get.WithContext(ctx),
+		es.Indices.Get.WithAllowNoIndices(true),
+	)
+	if err := decode(res, err, &indices); err != nil {
+		return nil, fmt.Errorf("list indices: %v", err)
+	}
+
+	var names []string
+	for name := range indices {
+		if versionOf(alias, name) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return versionOf(alias, names[i]) < versionOf(alias, names[j])
+	})
+	return names, nil
+}
+
+// Current 返回别名当前指向的索引，别名不存在时返回空
+func Current(ctx context.Context, es *elasticsearch.Client, alias string) ([]string, error) {
+	res, err := es.Indices.GetAlias(
+		es.Indices.GetAlias.WithContext(ctx),
+		es.Indices.GetAlias.WithName(alias),
+	)
+	if err == nil && res.StatusCode == 404 {
+		res.Body.Close()
+		return nil, nil
+	}
+
+	var indices map[string]interface{}
+	if err := decode(res, err, &indices); err != nil {
+		return nil, fmt.Errorf("get alias %s: %v", alias, err)
+	}
+
+	var names []string
+	for name := range indices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// 别名不能与已有的普通索引同名
+func checkAlias(ctx context.Context, es *elasticsearch.Client, alias string) error {
+	res, err := es.Indices.Get([]string{alias},
+		es.Indices.Get.WithContext(ctx),
+		es.Indices.Get.WithIgnoreUnavailable(true),
+		es.Indices.Get.WithAllowNoIndices(true),
+	)
+	var indices map[string]interface{}
+	if err := decode(res, err, &indices); err != nil {
+		return fmt.Errorf("check alias %s: %v", alias, err)
+	}
+	if _, ok := indices[alias]; ok {
+		return fmt.Errorf("%s is an index, not an alias; delete or rename it first", alias)
+	}
+	return nil
+}
+
+// 下一个版本索引的名称
+func nextIndex(ctx context.Context, es *elasticsearch.Client, alias string) (string, error) {
+	versions, err := Versions(ctx, es, alias)
+	if err != nil {
+		return "", err
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versionOf(alias, versions[len(versions)-1]) + 1
+	}
+	return IndexName(alias, next), nil
+}
+
+// Create 创建索引，mapping 和分词配置来自 PutTemplate 写入的模板；导入期间关闭刷新和副本以加快写入
+func Create(ctx context.Context, es *elasticsearch.Client, index string) error {
+	r, err := jsonBody(map[string]interface{}{
+		"settings": map[string]interface{}{
+			"index": map[string]interface{}{
+				"refresh_interval":   "-1",
+				"number_of_replicas": 0,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	res, err := es.Indices.Create(index,
+		es.Indices.Create.WithContext(ctx),
+		es.Indices.Create.WithBody(r),
+	)
+	if err := decode(res, err, nil); err != nil {
+		return fmt.Errorf("create index %s: %v", index, err)
+	}
+	return nil
+}
+
+// Finish 导入完成后恢复刷新间隔和副本数，并立即刷新
+func Finish(ctx context.Context, es *elasticsearch.Client, index string, replicas int) error {
+	r, err := jsonBody(map[string]interface{}{
+		"index": map[string]interface{}{
+			"refresh_interval":   "1s",
+			"number_of_replicas": replicas,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	res, err := es.Indices.PutSettings(r,
+		es.Indices.PutSettings.WithContext(ctx),
+		es.Indices.PutSettings.WithIndex(index),
+	)
+	if err := decode(res, err, nil); err != nil {
+		return fmt.Errorf("update settings %s: %v", index, err)
+	}
+
+	res, err = es.Indices.Refresh(
+		es.Indices.Refresh.WithContext(ctx),
+		es.Indices.Refresh.WithIndex(index),
+	)
+	if err := decode(res, err, nil); err != nil {
+		return fmt.Errorf("refresh %s: %v", index, err)
+	}
+	return nil
+}
+
+// Count 统计索引中 id <= maxID 的文档数
+func Count(ctx context.Context, es *elasticsearch.Client, index string, maxID int64) (int64, error) {
+	r, err := jsonBody(map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"id": map[string]interface{}{"lte": maxID},
+			},
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var result struct {
+		Count int64 `json:"count"`
+	}
+	res, err := es.Count(
+		es.Count.WithContext(ctx),
+		es.Count.WithIndex(index),
+		es.Count.WithBody(r),
+	)
+	if err := decode(res, err, &result); err != nil {
+		return 0, fmt.Errorf("count %s: %v", index, err)
+	}
+	return result.Count, nil
+}
+
+// SwapAlias 在一个请求中把别名从旧索引移到 index，读写均不会中断
+func SwapAlias(ctx context.Context, es *elasticsearch.Client, alias, index string) ([]string, error) {
+	old, err := Current(ctx, es, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []map[string]interface{}
+	var removed []string
+	for _, name := range old {
+		if name == index {
+			continue
+		}
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]interface{}{"index": name, "alias": alias},
+		})
+		removed = append(removed, name)
+	}
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]interface{}{"index": index, "alias": alias, "is_write_index": true},
+	})
+
+	r, err := jsonBody(map[string]interface{}{"actions": actions})
+	if err != nil {
+		return nil, err
+	}
+	res, err := es.Indices.UpdateAliases(r,
+		es.Indices.UpdateAliases.WithContext(ctx),
+	)
+	if err := decode(res, err, nil); err != nil {
+		return nil, fmt.Errorf("update alias %s: %v", alias, err)
+	}
+	return removed, nil
+}
+
+// Delete 删除索引
+func Delete(ctx context.Context, es *elasticsearch.Client, index string) error {
+	res, err := es.Indices.Delete([]string{index},
+		es.Indices.Delete.WithContext(ctx),
+	)
+	if err := decode(res, err, nil); err != nil {
+		return fmt.Errorf("delete index %s: %v", index, err)
+	}
+	return nil
+}
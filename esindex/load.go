@@ -0,0 +1,287 @@
+package esindex
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Document 索引中的一条种子记录，与 infohash 表的列一一对应
+type Document struct {
+	ID           int64    `json:"id"`
+	InfoHash     string   `json:"infohash"`
+	Name         string   `json:"name"`
+	Length       int64    `json:"length"`
+	Files        bool     `json:"files"`
+	FileCount    int      `json:"file_count"`
+	Extensions   []string `json:"extensions"`
+	Addeded      string   `json:"addeded"`
+	Updated      string   `json:"updated"`
+	Cnt          int      `json:"cnt"`
+	TextIndex    string   `json:"textindex"`
+	Title        string   `json:"title"`
+	Year         int      `json:"year"`
+	Season       int      `json:"season"`
+	Episode      int      `json:"episode"`
+	Resolution   string   `json:"resolution"`
+	VideoCodec   string   `json:"video_codec"`
+	AudioCodec   string   `json:"audio_codec"`
+	Source       string   `json:"source"`
+	ReleaseGroup string   `json:"release_group"`
+	Languages    []string `json:"languages"`
+	Category     string   `json:"category"`
+	FileList     []File   `json:"file_list,omitempty"`
+}
+
+// File 种子内的一个文件，以 nested 对象索引
+type File struct {
+	Path      string `json:"path"`
+	Extension string `json:"extension"`
+	Length    int64  `json:"length"`
+}
+
+// Extension 返回小写的文件扩展名，不含点
+func Extension(p string) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(p), "."))
+}
+
+// Extensions 返回种子包含的扩展名，去重并保持顺序；单文件种子取名称的扩展名
+func Extensions(name string, paths []string) []string {
+	if len(paths) == 0 {
+		paths = []string{name}
+	}
+
+	seen := make(map[string]bool)
+	exts := []string{}
+	for _, p := range paths {
+		if ext := Extension(p); ext != "" && !seen[ext] {
+			seen[ext] = true
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// LoadOptions 导入参数
+type LoadOptions struct {
+	Since     string // 只导入 updated >= Since 的记录，为空时全部导入
+	BatchSize int
+	Workers   int
+	Logger    *log.Logger
+}
+
+// LoadStats 导入统计
+type LoadStats struct {
+	Indexed int64
+	Failed  int64
+}
+
+// MySQL datetime 转为 ES 可识别的日期格式
+func esDate(s string) string {
+	return strings.Replace(s, " ", "T", 1)
+}
+
+// Load 按 id 顺序分批读取 infohash 表并通过 bulk 接口写入 index，文档 id 与 MySQL 的 id 相同
+func Load(ctx context.Context, es *elasticsearch.Client, db *sql.DB, index string, opts LoadOptions) (LoadStats, error) {
+	var stats LoadStats
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 2
+	}
+
+	where := "1=1"
+	var args []interface{}
+	if opts.Since != "" {
+		where = "updated >= ?"
+		args = append(args, opts.Since)
+	}
+
+	var total int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM infohash WHERE "+where, args...).Scan(&total); err != nil {
+		return stats, fmt.Errorf("count infohash: %v", err)
+	}
+
+	var failed int64
+	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Client:     es,
+		Index:      index,
+		NumWorkers: opts.Workers,
+		FlushBytes: 5 << 20,
+		OnError: func(ctx context.Context, err error) {
+			if opts.Logger != nil {
+				opts.Logger.Printf("bulk error: %v", err)
+			}
+		},
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	onFailure := func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+		// 只记录前几条失败原因，避免刷屏
+		if atomic.AddInt64(&failed, 1) <= 10 && opts.Logger != nil {
+			if err == nil {
+				err = fmt.Errorf("%s: %s", res.Error.Type, res.Error.Reason)
+			}
+			opts.Logger.Printf("文档 %s 写入失败: %v", item.DocumentID, err)
+		}
+	}
+
+	query := "SELECT id, infohash, name, length, files, addeded, updated, cnt, textindex, title, year, season, episode, " +
+		"resolution, video_codec, audio_codec, source, release_group, languages, category FROM infohash " +
+		"WHERE " + where + " AND id > ? ORDER BY id LIMIT ?"
+
+	start := time.Now()
+	lastLog := start
+	var lastID, read int64
+	for {
+		if err := ctx.Err(); err != nil {
+			bi.Close(context.Background())
+			return stats, err
+		}
+
+		docs, err := queryDocuments(ctx, db, query, append(args, lastID, opts.BatchSize)...)
+		if err == nil {
+			err = loadFiles(ctx, db, docs)
+		}
+		if err != nil {
+			bi.Close(context.Background())
+			return stats, err
+		}
+		for _, doc := range docs {
+			setFileStats(doc)
+		}
+
+		for _, doc := range docs {
+			body, err := json.Marshal(doc)
+			if err != nil {
+				bi.Close(context.Background())
+				return stats, err
+			}
+			if err := bi.Add(ctx, esutil.BulkIndexerItem{
+				Action:     "index",
+				DocumentID: strconv.FormatInt(doc.ID, 10),
+				Body:       bytes.NewReader(body),
+				OnFailure:  onFailure,
+			}); err != nil {
+				bi.Close(context.Background())
+				return stats, err
+			}
+			lastID = doc.ID
+		}
+		read += int64(len(docs))
+
+		if opts.Logger != nil && (time.Since(lastLog) >= 10*time.Second || len(docs) < opts.BatchSize) {
+			lastLog = time.Now()
+			percent := 100.0
+			if total > 0 {
+				percent = float64(read) * 100 / float64(total)
+			}
+			elapsed := time.Since(start).Seconds()
+			opts.Logger.Printf("索引进度: %d/%d (%.1f%%), %.0f 条/秒, 失败 %d",
+				read, total, percent, float64(read)/elapsed, atomic.LoadInt64(&failed))
+		}
+
+		if len(docs) < opts.BatchSize {
+			break
+		}
+	}
+
+	if err := bi.Close(ctx); err != nil {
+		return stats, err
+	}
+
+	bs := bi.Stats()
+	stats.Indexed = int64(bs.NumIndexed)
+	stats.Failed = int64(bs.NumFailed)
+	return stats, nil
+}
+
+func queryDocuments(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]*Document, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query infohash: %v", err)
+	}
+	defer rows.Close()
+
+	var docs []*Document
+	for rows.Next() {
+		doc := &Document{}
+		var languages string
+		if err := rows.Scan(&doc.ID, &doc.InfoHash, &doc.Name, &doc.Length, &doc.Files, &doc.Addeded, &doc.Updated,
+			&doc.Cnt, &doc.TextIndex, &doc.Title, &doc.Year, &doc.Season, &doc.Episode, &doc.Resolution,
+			&doc.VideoCodec, &doc.AudioCodec, &doc.Source, &doc.ReleaseGroup, &languages, &doc.Category); err != nil {
+			return nil, fmt.Errorf("scan infohash: %v", err)
+		}
+		doc.Addeded = esDate(doc.Addeded)
+		doc.Updated = esDate(doc.Updated)
+		doc.Languages = []string{}
+		if languages != "" {
+			doc.Languages = strings.Split(languages, ",")
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// 按 idx 顺序读取一批种子的文件列表
+func loadFiles(ctx context.Context, db *sql.DB, docs []*Document) error {
+	byID := make(map[int64]*Document)
+	var args []interface{}
+	for _, doc := range docs {
+		if doc.Files {
+			byID[doc.ID] = doc
+			args = append(args, doc.ID)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	rows, err := db.QueryContext(ctx,
+		"SELECT infohash_id, path, length FROM files WHERE infohash_id IN ("+in+") ORDER BY infohash_id, idx", args...)
+	if err != nil {
+		return fmt.Errorf("query files: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, length int64
+		var p string
+		if err := rows.Scan(&id, &p, &length); err != nil {
+			return fmt.Errorf("scan files: %v", err)
+		}
+		if doc := byID[id]; doc != nil {
+			doc.FileList = append(doc.FileList, File{Path: p, Extension: Extension(p), Length: length})
+		}
+	}
+	return rows.Err()
+}
+
+// 由文件列表计算文件数和扩展名，单文件种子的文件数为 1
+func setFileStats(doc *Document) {
+	paths := make([]string, len(doc.FileList))
+	for i, f := range doc.FileList {
+		paths[i] = f.Path
+	}
+
+	doc.FileCount = len(paths)
+	if doc.FileCount == 0 {
+		doc.FileCount = 1
+	}
+	doc.Extensions = Extensions(doc.Name, paths)
+}
@@ -0,0 +1,134 @@
+package esindex
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"log"
+)
+
+// ReindexOptions 重建索引参数
+type ReindexOptions struct {
+	Alias     string
+	Replicas  int
+	BatchSize int
+	Workers   int
+	Force     bool // 文档数量不一致时仍然切换别名
+	Logger    *log.Logger
+}
+
+// ReindexResult 重建结果
+type ReindexResult struct {
+	Index    string   // 新索引
+	Previous []string // 切换前别名指向的索引，未删除
+	Indexed  int64
+}
+
+// Reindex 创建新版本索引，从 MySQL 全量导入后补导入期间更新的记录，
+// 校验文档数量一致后原子地把别名切换到新索引，切换后再补导入一次，
+// 补上补导入开始后经别名写入旧索引的记录。校验失败时保留新索引供排查，别名不变。
+func Reindex(ctx context.Context, es *elasticsearch.Client, db *sql.DB, opts ReindexOptions) (ReindexResult, error) {
+	var result ReindexResult
+
+	if opts.Alias == "" {
+		opts.Alias = DefaultAlias
+	}
+	logf := func(format string, v ...interface{}) {
+		if opts.Logger != nil {
+			opts.Logger.Printf(format, v...)
+		}
+	}
+
+	if err := checkAlias(ctx, es, opts.Alias); err != nil {
+		return result, err
+	}
+
+	// 先更新模板，新索引按代码中的定义创建
+	ik, err := DetectIK(ctx, es)
+	if err != nil {
+		return result, err
+	}
+	if err := PutTemplate(ctx, es, opts.Alias, ik); err != nil {
+		return result, err
+	}
+	if !ik {
+		logf("未安装 IK 分词插件，使用内置分词配置")
+	}
+
+	if result.Index, err = nextIndex(ctx, es, opts.Alias); err != nil {
+		return result, err
+	}
+	if err := Create(ctx, es, result.Index); err != nil {
+		return result, err
+	}
+	logf("已创建索引 %s", result.Index)
+
+	// 以数据库时间为准，记录导入开始时间和当时的最大 id
+	var started string
+	var maxID int64
+	if err := db.QueryRowContext(ctx, "SELECT NOW(), COALESCE(MAX(id), 0) FROM infohash").Scan(&started, &maxID); err != nil {
+		return result, fmt.Errorf("query infohash: %v", err)
+	}
+
+	load := LoadOptions{BatchSize: opts.BatchSize, Workers: opts.Workers, Logger: opts.Logger}
+	stats, err := Load(ctx, es, db, result.Index, load)
+	if err != nil {
+		return result, err
+	}
+	result.Indexed = stats.Indexed
+
+	// 补导入期间新增或更新的记录
+	var caughtUp string
+	if err := db.QueryRowContext(ctx, "SELECT NOW()").Scan(&caughtUp); err != nil {
+		return result, fmt.Errorf("query time: %v", err)
+	}
+	load.Since = started
+	catchUp, err := Load(ctx, es, db, result.Index, load)
+	if err != nil {
+		return result, err
+	}
+	logf("补充导入 %s 之后更新的记录 %d 条", started, catchUp.Indexed)
+
+	if failed := stats.Failed + catchUp.Failed; failed > 0 && !opts.Force {
+		return result, fmt.Errorf("%d documents failed, alias not switched; inspect %s", failed, result.Index)
+	}
+
+	if err := Finish(ctx, es, result.Index, opts.Replicas); err != nil {
+		return result, err
+	}
+
+	// 记录只增不删，开始时已存在的记录数量必须一致
+	var want int64
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM infohash WHERE id <= ?", maxID).Scan(&want); err != nil {
+		return result, fmt.Errorf("count infohash: %v", err)
+	}
+	got, err := Count(ctx, es, result.Index, maxID)
+	if err != nil {
+		return result, err
+	}
+	logf("校验文档数量: MySQL %d, %s %d", want, result.Index, got)
+	if got != want && !opts.Force {
+		return result, fmt.Errorf("document count mismatch (mysql %d, %s %d), alias not switched", want, result.Index, got)
+	}
+
+	result.Previous, err = SwapAlias(ctx, es, opts.Alias, result.Index)
+	if err != nil {
+		return result, err
+	}
+	logf("别名 %s 已切换到 %s", opts.Alias, result.Index)
+
+	// 补导入到切换之间（包括 Finish 和校验）Logstash 仍经别名写入旧索引，
+	// 这些记录不会再被 Logstash 读取，切换后按补导入的开始时间再导入一次
+	load.Since = caughtUp
+	final, err := Load(ctx, es, db, result.Index, load)
+	if err != nil {
+		return result, fmt.Errorf("alias switched, but importing records updated since %s failed: %v", caughtUp, err)
+	}
+	logf("补充导入 %s 之后更新的记录 %d 条", caughtUp, final.Indexed)
+	if final.Failed > 0 {
+		return result, fmt.Errorf("alias switched, but %d documents updated since %s failed", final.Failed, caughtUp)
+	}
+
+	return result, nil
+}
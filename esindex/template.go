@@ -0,0 +1,417 @@
+package esindex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// SchemaVersion 索引定义的版本，修改 mapping 或分词配置时递增，写入 _meta 用于检查偏差
+const SchemaVersion = 5
+
+// 文本字段使用的分词器，具体配置取决于是否安装了 IK 插件
+const (
+	AnalyzerIndex  = "torrent_index"
+	AnalyzerSearch = "torrent_search"
+)
+
+// keyword 字段保存的最大字符数，更长的值不建立该字段的索引，
+// 避免超过 Lucene 单个词 32766 字节的限制导致整个文档被拒绝
+const keywordIgnoreAbove = 1024
+
+func field(typ string) map[string]interface{} {
+	return map[string]interface{}{"type": typ}
+}
+
+func textField() map[string]interface{} {
+	return map[string]interface{}{
+		"type":            "text",
+		"analyzer":        AnalyzerIndex,
+		"search_analyzer": AnalyzerSearch,
+	}
+}
+
+// 名称按 keyword 保存，用于排序和补全去重，过长的名称只保存在 name.text 中；
+// name.text 分词后保留词序，用于短语匹配；name.suggest 为 search_as_you_type，用于输入时补全
+func nameField() map[string]interface{} {
+	suggest := textField()
+	suggest["type"] = "search_as_you_type"
+	return map[string]interface{}{
+		"type":         "keyword",
+		"ignore_above": keywordIgnoreAbove,
+		"fields": map[string]interface{}{
+			"text":    textField(),
+			"suggest": suggest,
+		},
+	}
+}
+
+// Mappings 返回索引的 mapping
+func Mappings() map[string]interface{} {
+	return map[string]interface{}{
+		"_meta": map[string]interface{}{"schema_version": SchemaVersion},
+		"properties": map[string]interface{}{
+			"id":            field("long"),
+			"infohash":      field("keyword"),
+			"name":          nameField(),
+			"length":        field("long"),
+			"files":         field("boolean"),
+			"file_count":    field("integer"),
+			"extensions":    field("keyword"),
+			"addeded":       field("date"),
+			"updated":       field("date"),
+			"cnt":           field("integer"),
+			"textindex":     textField(),
+			"title":         textField(),
+			"year":          field("integer"),
+			"season":        field("integer"),
+			"episode":       field("integer"),
+			"resolution":    field("keyword"),
+			"video_codec":   field("keyword"),
+			"audio_codec":   field("keyword"),
+			"source":        field("keyword"),
+			"release_group": field("keyword"),
+			"languages":     field("keyword"),
+			"category":      field("keyword"),
+			"file_list": map[string]interface{}{
+				"type": "nested",
+				"properties": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":            "text",
+						"analyzer":        AnalyzerIndex,
+						"search_analyzer": AnalyzerSearch,
+						"fields": map[string]interface{}{
+							"raw": map[string]interface{}{"type": "keyword", "ignore_above": keywordIgnoreAbove},
+						},
+					},
+					"extension": field("keyword"),
+					"length":    field("long"),
+				},
+			},
+		},
+	}
+}
+
+// 单个种子的文件数上限，超过时导入会失败
+const maxNestedFiles = 100000
+
+// 种子名称和路径中常用 . 和 _ 代替空格，standard 分词不会在这两处切分
+const charFilterSeparators = "torrent_separators"
+
+// Analysis 返回分词配置。安装了 IK 时使用 ik_max_word/ik_smart，
+// 否则使用内置的 standard 分词加 cjk_bigram，中日韩文字按二元切分
+func Analysis(ik bool) map[string]interface{} {
+	charFilter := []string{charFilterSeparators}
+
+	index := map[string]interface{}{
+		"type":        "custom",
+		"char_filter": charFilter,
+		"tokenizer":   "standard",
+		"filter":      []string{"cjk_width", "lowercase", "cjk_bigram"},
+	}
+	search := index
+
+	if ik {
+		index = map[string]interface{}{
+			"type":        "custom",
+			"char_filter": charFilter,
+			"tokenizer":   "ik_max_word",
+			"filter":      []string{"lowercase"},
+		}
+		search = map[string]interface{}{
+			"type":        "custom",
+			"char_filter": charFilter,
+			"tokenizer":   "ik_smart",
+			"filter":      []string{"lowercase"},
+		}
+	}
+
+	return map[string]interface{}{
+		"char_filter": map[string]interface{}{
+			charFilterSeparators: map[string]interface{}{
+				"type":        "pattern_replace",
+				"pattern":     "[._]",
+				"replacement": " ",
+			},
+		},
+		"analyzer": map[string]interface{}{
+			AnalyzerIndex:  index,
+			AnalyzerSearch: search,
+		},
+	}
+}
+
+// Template 返回匹配 alias_v* 的索引模板，新版本索引创建时自动应用
+func Template(alias string, ik bool) map[string]interface{} {
+	return map[string]interface{}{
+		"index_patterns": []string{alias + "_v*"},
+		"priority":       100,
+		"template": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"analysis": Analysis(ik),
+				"index": map[string]interface{}{
+					"mapping": map[string]interface{}{
+						"nested_objects": map[string]interface{}{"limit": maxNestedFiles},
+					},
+				},
+			},
+			"mappings": Mappings(),
+		},
+		"_meta": map[string]interface{}{"schema_version": SchemaVersion, "ik": ik},
+	}
+}
+
+// DetectIK 检查集群是否安装了 IK 分词插件
+func DetectIK(ctx context.Context, es *elasticsearch.Client) (bool, error) {
+	r, err := jsonBody(map[string]interface{}{"analyzer": "ik_smart", "text": "分词"})
+	if err != nil {
+		return false, err
+	}
+
+	res, err := es.Indices.Analyze(
+		es.Indices.Analyze.WithContext(ctx),
+		es.Indices.Analyze.WithBody(r),
+	)
+	if err == nil && res.StatusCode == 400 {
+		// 未知分词器
+		res.Body.Close()
+		return false, nil
+	}
+	if err := decode(res, err, nil); err != nil {
+		return false, fmt.Errorf("detect ik: %v", err)
+	}
+	return true, nil
+}
+
+// PutTemplate 写入索引模板，已存在时覆盖
+func PutTemplate(ctx context.Context, es *elasticsearch.Client, alias string, ik bool) error {
+	r, err := jsonBody(Template(alias, ik))
+	if err != nil {
+		return err
+	}
+
+	res, err := es.Indices.PutIndexTemplate(alias, r,
+		es.Indices.PutIndexTemplate.WithContext(ctx),
+	)
+	if err := decode(res, err, nil); err != nil {
+		return fmt.Errorf("put index template %s: %v", alias, err)
+	}
+	return nil
+}
+
+// Drift 比较别名指向的索引与代码中的 mapping 和分词配置，返回不一致之处。ik 为集群是否安装了 IK
+func Drift(ctx context.Context, es *elasticsearch.Client, alias string, ik bool) ([]string, error) {
+	var indices map[string]struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	res, err := es.Indices.GetMapping(
+		es.Indices.GetMapping.WithContext(ctx),
+		es.Indices.GetMapping.WithIndex(alias),
+	)
+	if err := decode(res, err, &indices); err != nil {
+		return nil, fmt.Errorf("get mapping %s: %v", alias, err)
+	}
+
+	want := Mappings()
+	var drift []string
+	for _, index := range sortedKeys(indices) {
+		got := indices[index].Mappings
+
+		wantVersion := fmt.Sprint(SchemaVersion)
+		gotVersion := "none"
+		if meta, ok := got["_meta"].(map[string]interface{}); ok && meta["schema_version"] != nil {
+			gotVersion = fmt.Sprint(meta["schema_version"])
+		}
+		if gotVersion != wantVersion {
+			drift = append(drift, fmt.Sprintf("%s: schema_version %s, expected %s", index, gotVersion, wantVersion))
+		}
+
+		wantProps, _ := want["properties"].(map[string]interface{})
+		gotProps, _ := got["properties"].(map[string]interface{})
+		drift = append(drift, diffProperties(index, "", wantProps, gotProps)...)
+	}
+
+	analysis, err := diffAnalysis(ctx, es, alias, ik)
+	if err != nil {
+		return nil, err
+	}
+	return append(drift, analysis...), nil
+}
+
+// 比较索引 settings.analysis 中的分词器、字符过滤器等与 Analysis(ik) 的定义
+func diffAnalysis(ctx context.Context, es *elasticsearch.Client, alias string, ik bool) ([]string, error) {
+	var indices map[string]struct {
+		Settings struct {
+			Index struct {
+				Analysis map[string]interface{} `json:"analysis"`
+			} `json:"index"`
+		} `json:"settings"`
+	}
+	res, err := es.Indices.GetSettings(
+		es.Indices.GetSettings.WithContext(ctx),
+		es.Indices.GetSettings.WithIndex(alias),
+	)
+	if err := decode(res, err, &indices); err != nil {
+		return nil, fmt.Errorf("get settings %s: %v", alias, err)
+	}
+
+	// ES 返回的设置值都是字符串，经 JSON 转换后再比较
+	var want map[string]interface{}
+	if err := roundTrip(Analysis(ik), &want); err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	for _, index := range sortedKeys(indices) {
+		got := indices[index].Settings.Index.Analysis
+		kinds := sortedKeys(want)
+		for _, kind := range sortedKeys(got) {
+			if _, ok := want[kind]; !ok {
+				kinds = append(kinds, kind)
+			}
+		}
+
+		for _, kind := range kinds {
+			w, _ := want[kind].(map[string]interface{})
+			g, _ := got[kind].(map[string]interface{})
+			for _, name := range sortedKeys(w) {
+				gv, ok := g[name]
+				switch {
+				case !ok:
+					drift = append(drift, fmt.Sprintf("%s: analysis %s %s missing", index, kind, name))
+				case !reflect.DeepEqual(w[name], gv):
+					drift = append(drift, fmt.Sprintf("%s: analysis %s %s is %v, expected %v", index, kind, name, gv, w[name]))
+				}
+			}
+			for _, name := range sortedKeys(g) {
+				if _, ok := w[name]; !ok {
+					drift = append(drift, fmt.Sprintf("%s: unexpected analysis %s %s", index, kind, name))
+				}
+			}
+		}
+	}
+	return drift, nil
+}
+
+// 经 JSON 编码再解码，得到与 ES 响应相同的类型
+func roundTrip(v interface{}, out interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// 逐个字段比较 type、analyzer、search_analyzer、ignore_above，嵌套字段和多字段递归比较
+func diffProperties(index, prefix string, want, got map[string]interface{}) []string {
+	var drift []string
+
+	for _, name := range sortedKeys(want) {
+		path := prefix + name
+		w, _ := want[name].(map[string]interface{})
+		g, ok := got[name].(map[string]interface{})
+		if !ok {
+			drift = append(drift, fmt.Sprintf("%s: field %s missing", index, path))
+			continue
+		}
+
+		for _, attr := range []string{"type", "analyzer", "search_analyzer", "ignore_above"} {
+			wv, gv := w[attr], g[attr]
+			// 未设置 type 的字段为 object
+			if attr == "type" {
+				if wv == nil {
+					wv = "object"
+				}
+				if gv == nil {
+					gv = "object"
+				}
+			}
+			if fmt.Sprint(wv) != fmt.Sprint(gv) {
+				drift = append(drift, fmt.Sprintf("%s: field %s %s is %v, expected %v", index, path, attr, gv, wv))
+			}
+		}
+
+		if wp, ok := w["properties"].(map[string]interface{}); ok {
+			gp, _ := g["properties"].(map[string]interface{})
+			drift = append(drift, diffProperties(index, path+".", wp, gp)...)
+		}
+		// 多字段，如 name.text
+		if wf, ok := w["fields"].(map[string]interface{}); ok {
+			gf, _ := g["fields"].(map[string]interface{})
+			drift = append(drift, diffProperties(index, path+".", wf, gf)...)
+		}
+	}
+
+	var extra []string
+	for _, name := range sortedKeys(got) {
+		if _, ok := want[name]; !ok {
+			extra = append(extra, prefix+name)
+		}
+	}
+	if len(extra) > 0 {
+		drift = append(drift, fmt.Sprintf("%s: unexpected fields %s", index, strings.Join(extra, ", ")))
+	}
+
+	return drift
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// InitResult 初始化结果
+type InitResult struct {
+	IK      bool
+	Created string   // 别名不存在时新建的索引
+	Drift   []string // 现有索引与代码定义的偏差
+}
+
+// Init 写入索引模板；别名不存在时创建第一个版本的空索引并指向它；最后检查现有索引的偏差
+func Init(ctx context.Context, es *elasticsearch.Client, alias string, replicas int) (InitResult, error) {
+	var result InitResult
+
+	ik, err := DetectIK(ctx, es)
+	if err != nil {
+		return result, err
+	}
+	result.IK = ik
+
+	if err := PutTemplate(ctx, es, alias, ik); err != nil {
+		return result, err
+	}
+
+	if err := checkAlias(ctx, es, alias); err != nil {
+		return result, err
+	}
+
+	current, err := Current(ctx, es, alias)
+	if err != nil {
+		return result, err
+	}
+	if len(current) == 0 {
+		if result.Created, err = nextIndex(ctx, es, alias); err != nil {
+			return result, err
+		}
+		if err := Create(ctx, es, result.Created); err != nil {
+			return result, err
+		}
+		if err := Finish(ctx, es, result.Created, replicas); err != nil {
+			return result, err
+		}
+		if _, err := SwapAlias(ctx, es, alias, result.Created); err != nil {
+			return result, err
+		}
+	}
+
+	result.Drift, err = Drift(ctx, es, alias, ik)
+	return result, err
+}
@@ -0,0 +1,421 @@
+// Package exporter 将 infohash 与 files 表流式导出为 JSONL、CSV 或 .torrent 归档，
+// 支持按日期、分类和热度过滤，并通过水位文件增量导出。
+package exporter
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 导出格式
+const (
+	FormatJSONL   = "jsonl"
+	FormatCSV     = "csv"
+	FormatTorrent = "torrent"
+)
+
+const (
+	dateTimeLayout = "2006-01-02 15:04:05"
+	dateLayout     = "2006-01-02"
+	zeroWatermark  = "1970-01-01 00:00:00"
+)
+
+// Options 导出参数
+type Options struct {
+	Format    string
+	From      string // 收录日期下限 (addeded >= From)，格式 2006-01-02
+	To        string // 收录日期上限，包含当天
+	Category  string
+	MinCnt    int
+	Watermark string // 水位文件，为空时全量导出，Run 只读取不写入
+	BatchSize int
+	Logger    *log.Logger
+}
+
+// Stats 导出统计
+type Stats struct {
+	Exported int64
+	Skipped  int64 // torrent 格式下缺少原始元数据的记录
+
+	// 本次导出的最后位置，输出全部落盘后由调用方通过 WriteWatermark 保存
+	Watermark Watermark
+}
+
+// Watermark 上次导出的最后一条记录，按 (updated, id) 递增
+type Watermark struct {
+	Updated string `json:"updated"`
+	ID      int64  `json:"id"`
+}
+
+// Torrent 导出的一条记录，JSONL 格式可直接被 import 子命令读取。
+// Length 为总大小，有 files 时 import 忽略它，按文件大小重新累加
+type Torrent struct {
+	ID           int64    `json:"id"`
+	InfoHash     string   `json:"infohash"`
+	Name         string   `json:"name"`
+	Length       int64    `json:"length"`
+	Files        []File   `json:"files,omitempty"`
+	Addeded      string   `json:"addeded"`
+	Updated      string   `json:"updated"`
+	Cnt          int      `json:"cnt"`
+	Category     string   `json:"category,omitempty"`
+	Title        string   `json:"title,omitempty"`
+	Year         int      `json:"year,omitempty"`
+	Season       int      `json:"season,omitempty"`
+	Episode      int      `json:"episode,omitempty"`
+	Resolution   string   `json:"resolution,omitempty"`
+	VideoCodec   string   `json:"video_codec,omitempty"`
+	AudioCodec   string   `json:"audio_codec,omitempty"`
+	Source       string   `json:"source,omitempty"`
+	ReleaseGroup string   `json:"release_group,omitempty"`
+	Languages    []string `json:"languages,omitempty"`
+
+	hasFiles bool
+}
+
+// File 种子内的文件
+type File struct {
+	Path   []string `json:"path"`
+	Length int64    `json:"length"`
+}
+
+// 各格式的输出
+type writer interface {
+	write(t *Torrent, metadata []byte) (bool, error)
+	close() error
+}
+
+// Run 导出到 w
+func Run(ctx context.Context, db *sql.DB, w io.Writer, opts Options) (Stats, error) {
+	var stats Stats
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+
+	out, err := newWriter(opts.Format, w)
+	if err != nil {
+		return stats, err
+	}
+
+	mark, err := readWatermark(opts.Watermark)
+	if err != nil {
+		return stats, err
+	}
+
+	// 过滤条件
+	var where []string
+	var filterArgs []interface{}
+	if opts.From != "" {
+		from, err := time.Parse(dateLayout, opts.From)
+		if err != nil {
+			return stats, fmt.Errorf("invalid from date: %v", err)
+		}
+		where = append(where, "addeded >= ?")
+		filterArgs = append(filterArgs, from.Format(dateTimeLayout))
+	}
+	if opts.To != "" {
+		to, err := time.Parse(dateLayout, opts.To)
+		if err != nil {
+			return stats, fmt.Errorf("invalid to date: %v", err)
+		}
+		where = append(where, "addeded < ?")
+		filterArgs = append(filterArgs, to.AddDate(0, 0, 1).Format(dateTimeLayout))
+	}
+	if opts.Category != "" {
+		where = append(where, "category = ?")
+		filterArgs = append(filterArgs, opts.Category)
+	}
+	if opts.MinCnt > 0 {
+		where = append(where, "cnt >= ?")
+		filterArgs = append(filterArgs, opts.MinCnt)
+	}
+
+	query := "SELECT id, infohash, name, length, files, addeded, updated, cnt, category, title, year, season, episode, " +
+		"resolution, video_codec, audio_codec, source, release_group, languages FROM infohash " +
+		"WHERE (updated > ? OR (updated = ? AND id > ?))"
+	for _, cond := range where {
+		query += " AND " + cond
+	}
+	query += " ORDER BY updated, id LIMIT ?"
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		args := append([]interface{}{mark.Updated, mark.Updated, mark.ID}, filterArgs...)
+		args = append(args, opts.BatchSize)
+
+		batch, err := queryBatch(ctx, db, query, args)
+		if err != nil {
+			return stats, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		// 按格式补充文件列表或原始元数据
+		var metadata map[int64][]byte
+		switch opts.Format {
+		case FormatJSONL:
+			if err := loadFiles(ctx, db, batch); err != nil {
+				return stats, err
+			}
+		case FormatTorrent:
+			if metadata, err = loadMetadata(ctx, db, batch); err != nil {
+				return stats, err
+			}
+		}
+
+		for _, t := range batch {
+			ok, err := out.write(t, metadata[t.ID])
+			if err != nil {
+				return stats, err
+			}
+			if ok {
+				stats.Exported++
+			} else {
+				stats.Skipped++
+			}
+		}
+
+		last := batch[len(batch)-1]
+		mark = Watermark{Updated: last.Updated, ID: last.ID}
+
+		if opts.Logger != nil {
+			opts.Logger.Printf("导出进度: %d 条, 水位 %s #%d", stats.Exported, mark.Updated, mark.ID)
+		}
+		if len(batch) < opts.BatchSize {
+			break
+		}
+	}
+
+	stats.Watermark = mark
+	return stats, out.close()
+}
+
+func queryBatch(ctx context.Context, db *sql.DB, query string, args []interface{}) ([]*Torrent, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query infohash: %v", err)
+	}
+	defer rows.Close()
+
+	var batch []*Torrent
+	for rows.Next() {
+		t := &Torrent{}
+		var languages string
+		if err := rows.Scan(&t.ID, &t.InfoHash, &t.Name, &t.Length, &t.hasFiles, &t.Addeded, &t.Updated, &t.Cnt,
+			&t.Category, &t.Title, &t.Year, &t.Season, &t.Episode, &t.Resolution, &t.VideoCodec,
+			&t.AudioCodec, &t.Source, &t.ReleaseGroup, &languages); err != nil {
+			return nil, fmt.Errorf("scan infohash: %v", err)
+		}
+		if languages != "" {
+			t.Languages = strings.Split(languages, ",")
+		}
+		batch = append(batch, t)
+	}
+	return batch, rows.Err()
+}
+
+// 生成 IN (?, ?, ...) 及参数
+func idList(batch []*Torrent, need func(*Torrent) bool) (string, []interface{}) {
+	var args []interface{}
+	for _, t := range batch {
+		if need(t) {
+			args = append(args, t.ID)
+		}
+	}
+	if len(args) == 0 {
+		return "", nil
+	}
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ") + ")", args
+}
+
+func loadFiles(ctx context.Context, db *sql.DB, batch []*Torrent) error {
+	in, args := idList(batch, func(t *Torrent) bool { return t.hasFiles })
+	if in == "" {
+		return nil
+	}
+
+	byID := make(map[int64]*Torrent, len(batch))
+	for _, t := range batch {
+		byID[t.ID] = t
+	}
+
+	rows, err := db.QueryContext(ctx,
+		"SELECT infohash_id, path, length FROM files WHERE infohash_id IN "+in+" ORDER BY infohash_id, idx", args...)
+	if err != nil {
+		return fmt.Errorf("query files: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, length int64
+		var path string
+		if err := rows.Scan(&id, &path, &length); err != nil {
+			return fmt.Errorf("scan files: %v", err)
+		}
+		if t := byID[id]; t != nil {
+			t.Files = append(t.Files, File{Path: strings.Split(path, "/"), Length: length})
+		}
+	}
+	return rows.Err()
+}
+
+func loadMetadata(ctx context.Context, db *sql.DB, batch []*Torrent) (map[int64][]byte, error) {
+	res := make(map[int64][]byte)
+
+	in, args := idList(batch, func(*Torrent) bool { return true })
+	rows, err := db.QueryContext(ctx, "SELECT infohash_id, info FROM metadata WHERE infohash_id IN "+in, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query metadata: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var info []byte
+		if err := rows.Scan(&id, &info); err != nil {
+			return nil, fmt.Errorf("scan metadata: %v", err)
+		}
+		res[id] = info
+	}
+	return res, rows.Err()
+}
+
+func newWriter(format string, w io.Writer) (writer, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case FormatTorrent:
+		return &torrentWriter{w: tar.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) write(t *Torrent, _ []byte) (bool, error) {
+	return true, j.enc.Encode(t)
+}
+
+func (j *jsonlWriter) close() error { return nil }
+
+type csvWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+var csvHeader = []string{
+	"id", "infohash", "name", "length", "files", "addeded", "updated", "cnt", "category",
+	"title", "year", "season", "episode", "resolution", "video_codec", "audio_codec",
+	"source", "release_group", "languages",
+}
+
+func (c *csvWriter) write(t *Torrent, _ []byte) (bool, error) {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(csvHeader); err != nil {
+			return false, err
+		}
+	}
+
+	err := c.w.Write([]string{
+		strconv.FormatInt(t.ID, 10), t.InfoHash, t.Name, strconv.FormatInt(t.Length, 10),
+		strconv.FormatBool(t.hasFiles), t.Addeded, t.Updated, strconv.Itoa(t.Cnt), t.Category,
+		t.Title, strconv.Itoa(t.Year), strconv.Itoa(t.Season), strconv.Itoa(t.Episode), t.Resolution,
+		t.VideoCodec, t.AudioCodec, t.Source, t.ReleaseGroup, strings.Join(t.Languages, ","),
+	})
+	return err == nil, err
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// 每个种子一个 <infohash>.torrent 条目，仅包含 info 字典
+type torrentWriter struct {
+	w *tar.Writer
+}
+
+func (tw *torrentWriter) write(t *Torrent, metadata []byte) (bool, error) {
+	if len(metadata) == 0 {
+		return false, nil
+	}
+
+	data := make([]byte, 0, len(metadata)+8)
+	data = append(data, "d4:info"...)
+	data = append(data, metadata...)
+	data = append(data, 'e')
+
+	modTime, _ := time.Parse(dateTimeLayout, t.Updated)
+	if err := tw.w.WriteHeader(&tar.Header{
+		Name:     t.InfoHash + ".torrent",
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+	}); err != nil {
+		return false, err
+	}
+	_, err := tw.w.Write(data)
+	return err == nil, err
+}
+
+func (tw *torrentWriter) close() error {
+	return tw.w.Close()
+}
+
+func readWatermark(path string) (Watermark, error) {
+	mark := Watermark{Updated: zeroWatermark}
+	if path == "" {
+		return mark, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return mark, nil
+	}
+	if err != nil {
+		return mark, err
+	}
+	if err := json.Unmarshal(data, &mark); err != nil {
+		return mark, fmt.Errorf("invalid watermark file %s: %v", path, err)
+	}
+	return mark, nil
+}
+
+// WriteWatermark 保存水位，先写临时文件再重命名，避免中断时水位文件损坏
+func WriteWatermark(path string, mark Watermark) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(mark)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
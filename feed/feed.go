@@ -0,0 +1,119 @@
+// Package feed 定义 RSS 2.0 和 Atom 订阅的 XML 格式，供订阅最新种子、热门种子和保存的搜索
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// 命名空间
+const (
+	NamespaceAtom = "http://www.w3.org/2005/Atom"
+)
+
+// 种子的 MIME 类型，用于 magnet 链接的 enclosure
+const TypeBitTorrent = "application/x-bittorrent"
+
+// RSS RSS 2.0 订阅
+type RSS struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	AtomNS  string   `xml:"xmlns:atom,attr"`
+	Channel Channel  `xml:"channel"`
+}
+
+type Channel struct {
+	AtomLink      AtomLink `xml:"atom:link"`
+	Title         string   `xml:"title"`
+	Link          string   `xml:"link"`
+	Description   string   `xml:"description"`
+	LastBuildDate string   `xml:"lastBuildDate,omitempty"`
+	Items         []Item   `xml:"item"`
+}
+
+// AtomLink RSS 中指向订阅自身的 atom:link
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Item RSS 中的一个种子
+type Item struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description,omitempty"`
+	GUID        GUID      `xml:"guid"`
+	PubDate     string    `xml:"pubDate,omitempty"`
+	Enclosure   Enclosure `xml:"enclosure"`
+}
+
+type GUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Enclosure 附件，Length 为种子的总大小
+type Enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// NewRSS 返回带命名空间的空订阅，self 为订阅自身的地址
+func NewRSS(title, link, description, self string) *RSS {
+	return &RSS{
+		Version: "2.0",
+		AtomNS:  NamespaceAtom,
+		Channel: Channel{
+			AtomLink:    AtomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+			Title:       title,
+			Link:        link,
+			Description: description,
+		},
+	}
+}
+
+// Atom Atom 订阅
+type Atom struct {
+	XMLName xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Links   []Link   `xml:"link"`
+	Entries []Entry  `xml:"entry"`
+}
+
+// Entry Atom 中的一个种子
+type Entry struct {
+	ID        string `xml:"id"`
+	Title     string `xml:"title"`
+	Published string `xml:"published,omitempty"` // 收录时间
+	Updated   string `xml:"updated"`             // 最近一次被爬虫发现的时间
+	Links     []Link `xml:"link"`
+	Summary   string `xml:"summary,omitempty"`
+}
+
+// Link Atom 链接，rel 为 enclosure 时 Length 为种子的总大小
+type Link struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr,omitempty"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+// RSSTime RSS 使用的 RFC 822 时间，零值返回空串
+func RSSTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC1123Z)
+}
+
+// AtomTime Atom 使用的 RFC 3339 时间，零值返回空串
+func AtomTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,307 @@
+// Package harness 提供离线测试爬虫所需的假节点：
+// FakePeer 通过 BEP 9/10 扩展协议提供种子元数据，
+// FakeAnnouncer 代替 DHT 网络触发爬虫的 announce 回调。
+package harness
+
+import (
+	"DHT-ES-Search/metainfo"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/shiyanhui/dht"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// BEP 10 扩展消息
+	msgExtended     = 20
+	extHandshakeID  = 0
+	localUTMetadata = 3
+
+	// BEP 9 元数据分片
+	pieceSize    = 16384
+	msgRequest   = 0
+	msgData      = 1
+	msgReject    = 2
+	protocolName = "BitTorrent protocol"
+	ioTimeout    = 15 * time.Second
+)
+
+// FakePeer 本地 TCP 节点，只支持元数据下载
+type FakePeer struct {
+	listener net.Listener
+
+	mu       sync.RWMutex
+	metadata map[string][]byte
+	served   map[string]int
+
+	wg sync.WaitGroup
+}
+
+// NewFakePeer 在 127.0.0.1 的随机端口上启动节点
+func NewFakePeer() (*FakePeer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &FakePeer{
+		listener: ln,
+		metadata: make(map[string][]byte),
+		served:   make(map[string]int),
+	}
+
+	p.wg.Add(1)
+	go p.serve()
+	return p, nil
+}
+
+// AddInfo 添加 info 字典原始字节，返回其 infohash
+func (p *FakePeer) AddInfo(info []byte) []byte {
+	infoHash := metainfo.InfoHash(info)
+
+	p.mu.Lock()
+	p.metadata[string(infoHash)] = info
+	p.mu.Unlock()
+
+	return infoHash
+}
+
+// AddTorrent 添加 .torrent 文件内容，返回其 infohash
+func (p *FakePeer) AddTorrent(torrent []byte) ([]byte, error) {
+	info, err := metainfo.InfoBytes(torrent)
+	if err != nil {
+		return nil, err
+	}
+	return p.AddInfo(info), nil
+}
+
+// Served 返回某个 infohash 的元数据被完整发送的次数
+func (p *FakePeer) Served(infoHash []byte) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.served[string(infoHash)]
+}
+
+// Addr 返回节点监听的 IP 与端口
+func (p *FakePeer) Addr() (string, int) {
+	addr := p.listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// Close 关闭节点并等待连接处理结束
+func (p *FakePeer) Close() error {
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *FakePeer) serve() {
+	defer p.wg.Done()
+
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer conn.Close()
+			p.handle(conn)
+		}()
+	}
+}
+
+// 处理一次元数据下载会话
+func (p *FakePeer) handle(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(ioTimeout))
+
+	// BEP 3 握手
+	hs := make([]byte, 68)
+	if _, err := io.ReadFull(conn, hs); err != nil {
+		return err
+	}
+	if hs[0] != byte(len(protocolName)) || string(hs[1:20]) != protocolName {
+		return errors.New("invalid handshake")
+	}
+	infoHash := hs[28:48]
+
+	p.mu.RLock()
+	info, ok := p.metadata[string(infoHash)]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown infohash %s", hex.EncodeToString(infoHash))
+	}
+
+	reply := make([]byte, 68)
+	copy(reply, hs[:20])
+	reply[25] |= 0x10 // 支持扩展协议
+	copy(reply[28:48], infoHash)
+	copy(reply[48:], "-FAKE0-harnesspeer00")
+	if _, err := conn.Write(reply); err != nil {
+		return err
+	}
+
+	remoteUTMetadata := -1
+	sent := make(map[int]bool)
+	pieces := (len(info) + pieceSize - 1) / pieceSize
+
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+		if len(msg) < 2 || msg[0] != msgExtended {
+			continue
+		}
+
+		payload, err := dht.Decode(msg[2:])
+		if err != nil {
+			return err
+		}
+		dict, ok := payload.(map[string]interface{})
+		if !ok {
+			return errors.New("invalid extended payload")
+		}
+
+		// BEP 10 扩展握手
+		if msg[1] == extHandshakeID {
+			m, _ := dict["m"].(map[string]interface{})
+			if id, ok := m["ut_metadata"].(int); ok {
+				remoteUTMetadata = id
+			}
+			hs, err := metainfo.Encode(map[string]interface{}{
+				"m":             map[string]interface{}{"ut_metadata": localUTMetadata},
+				"metadata_size": len(info),
+			})
+			if err != nil {
+				return err
+			}
+			if err := writeMessage(conn, append([]byte{msgExtended, extHandshakeID}, hs...)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if msg[1] != localUTMetadata || remoteUTMetadata < 0 {
+			continue
+		}
+
+		// BEP 9 分片请求
+		msgType, _ := dict["msg_type"].(int)
+		piece, _ := dict["piece"].(int)
+		if msgType != msgRequest {
+			continue
+		}
+
+		if piece < 0 || piece >= pieces {
+			reject, _ := metainfo.Encode(map[string]interface{}{"msg_type": msgReject, "piece": piece})
+			writeMessage(conn, append([]byte{msgExtended, byte(remoteUTMetadata)}, reject...))
+			continue
+		}
+
+		start := piece * pieceSize
+		end := start + pieceSize
+		if end > len(info) {
+			end = len(info)
+		}
+
+		header, err := metainfo.Encode(map[string]interface{}{
+			"msg_type":   msgData,
+			"piece":      piece,
+			"total_size": len(info),
+		})
+		if err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		buf.Write([]byte{msgExtended, byte(remoteUTMetadata)})
+		buf.Write(header)
+		buf.Write(info[start:end])
+		if err := writeMessage(conn, buf.Bytes()); err != nil {
+			return err
+		}
+
+		sent[piece] = true
+		if len(sent) == pieces {
+			p.mu.Lock()
+			p.served[string(infoHash)]++
+			p.mu.Unlock()
+		}
+	}
+}
+
+func readMessage(conn net.Conn) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > 4*pieceSize {
+		return nil, errors.New("message too long")
+	}
+
+	msg := make([]byte, length)
+	_, err := io.ReadFull(conn, msg)
+	return msg, err
+}
+
+func writeMessage(conn net.Conn, msg []byte) error {
+	buf := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+	copy(buf[4:], msg)
+	_, err := conn.Write(buf)
+	return err
+}
+
+// FakeAnnouncer 代替 DHT 网络，直接调用爬虫的 OnAnnouncePeer 回调
+type FakeAnnouncer struct {
+	OnAnnouncePeer func(infoHash, ip string, port int)
+}
+
+// Announce 通知爬虫某个 infohash 可以从 peer 获取
+func (a *FakeAnnouncer) Announce(infoHash []byte, peer *FakePeer) {
+	ip, port := peer.Addr()
+	a.OnAnnouncePeer(string(infoHash), ip, port)
+}
+
+// SampleFile 多文件种子中的一个文件
+type SampleFile struct {
+	Path   string
+	Length int
+}
+
+// SampleInfo 生成一个 info 字典，files 为空时生成单文件种子
+func SampleInfo(name string, length int, files []SampleFile) ([]byte, error) {
+	info := map[string]interface{}{
+		"name":         name,
+		"piece length": 262144,
+		"pieces":       string(make([]byte, 20)),
+	}
+
+	if len(files) == 0 {
+		info["length"] = length
+	} else {
+		list := make([]interface{}, 0, len(files))
+		for _, f := range files {
+			var parts []interface{}
+			for _, part := range strings.Split(f.Path, "/") {
+				if part != "" {
+					parts = append(parts, part)
+				}
+			}
+			list = append(list, map[string]interface{}{"path": parts, "length": f.Length})
+		}
+		info["files"] = list
+	}
+
+	return metainfo.Encode(info)
+}
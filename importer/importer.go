@@ -0,0 +1,566 @@
+// Package importer 批量导入 .torrent 文件、tar 包以及 JSONL/bencode 转储，
+// 每条记录交给调用方的 Handler 处理，支持并发、断点续传和跳过已存在记录。
+package importer
+
+import (
+	"DHT-ES-Search/metainfo"
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind 记录类型
+type Kind int
+
+const (
+	// KindInfo 为 info 字典原始 bencode 字节
+	KindInfo Kind = iota
+	// KindJSON 为 JSONL 转储中的一行
+	KindJSON
+)
+
+// Item 待导入的一条记录
+type Item struct {
+	Source   string // 来源，tar 包内为 "包路径:条目名"
+	Kind     Kind
+	InfoHash []byte // KindInfo 时由原始字节计算
+	Data     []byte
+}
+
+// Handler 处理一条记录，返回 ErrSkipped 表示记录已存在，返回 ErrInvalid 表示记录本身有误
+type Handler func(ctx context.Context, item Item) error
+
+// ErrSkipped 由 Handler 返回，表示跳过该记录
+var ErrSkipped = errors.New("skipped")
+
+// ErrInvalid 由 Handler 返回（可包装），表示记录无法解析，重试也不会成功，断点越过该记录。
+// 其他错误视为暂时性的，断点停在该记录之前，下次从这里重试
+var ErrInvalid = errors.New("invalid record")
+
+// Options 导入参数
+type Options struct {
+	Workers    int         // 并发数
+	Checkpoint string      // 断点文件，记录每个来源文件已导入的记录数，为空时不记录
+	Logger     *log.Logger // 进度日志，可为 nil
+}
+
+// Stats 导入统计
+type Stats struct {
+	Sources  int64
+	Imported int64
+	Skipped  int64
+	Failed   int64
+}
+
+// 每处理完这么多条记录写入一次来源文件内的进度
+const checkpointEvery = 1000
+
+// 来源文件的处理进度。记录按读取顺序编号，断点保存从头连续处理完的记录数，
+// 续传时跳过这些记录；全部记录处理完后标记整个来源文件已完成
+type source struct {
+	name string
+	wg   sync.WaitGroup
+
+	mu       sync.Mutex
+	done     int64          // 从头连续处理完的记录数
+	saved    int64          // 已写入断点的 done
+	finished map[int64]bool // done 之后已处理完的记录
+	failed   bool           // 有记录暂时失败，下次需要重试
+	limit    int64          // 最早失败的记录，done 不会超过它
+}
+
+func newSource(name string, skip int64) *source {
+	return &source{name: name, done: skip, saved: skip, finished: make(map[int64]bool), limit: math.MaxInt64}
+}
+
+// 第 seq 条记录处理完成
+func (s *source) finish(seq int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seq > s.limit {
+		return
+	}
+	if seq != s.done {
+		s.finished[seq] = true
+		return
+	}
+	for s.done++; s.finished[s.done]; s.done++ {
+		delete(s.finished, s.done)
+	}
+}
+
+// 第 seq 条记录暂时失败，断点停在它之前
+func (s *source) fail(seq int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed = true
+	s.limit = min(s.limit, seq)
+}
+
+// 距上次写入断点已处理完 checkpointEvery 条时返回需要写入的进度；all 为 true 时只要有变化就返回
+func (s *source) progress(all bool) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == s.saved || !all && s.done-s.saved < checkpointEvery {
+		return 0, false
+	}
+	s.saved = s.done
+	return s.done, true
+}
+
+type job struct {
+	src  *source
+	seq  int64
+	item Item
+}
+
+// Run 导入 paths 下的所有文件（目录会递归遍历）
+func Run(ctx context.Context, paths []string, opts Options, handle Handler) (Stats, error) {
+	var stats Stats
+
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+
+	cp, err := openCheckpoint(opts.Checkpoint)
+	if err != nil {
+		return stats, err
+	}
+	defer cp.Close()
+
+	jobs := make(chan job, opts.Workers*4)
+
+	// 工作协程
+	var workers sync.WaitGroup
+	for i := 0; i < opts.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				// 中断后队列中剩余的记录不处理也不计数，断点停在它们之前
+				if ctx.Err() != nil {
+					j.src.wg.Done()
+					continue
+				}
+
+				err := handle(ctx, j.item)
+				switch {
+				case err == nil:
+					atomic.AddInt64(&stats.Imported, 1)
+					j.src.finish(j.seq)
+				case errors.Is(err, ErrSkipped):
+					atomic.AddInt64(&stats.Skipped, 1)
+					j.src.finish(j.seq)
+				case ctx.Err() != nil:
+					// 处理中被中断，下次重新导入该记录
+					j.src.fail(j.seq)
+				default:
+					atomic.AddInt64(&stats.Failed, 1)
+					if errors.Is(err, ErrInvalid) {
+						j.src.finish(j.seq)
+					} else {
+						j.src.fail(j.seq)
+					}
+					if opts.Logger != nil {
+						opts.Logger.Printf("导入失败 %s: %v", j.item.Source, err)
+					}
+				}
+				if n, ok := j.src.progress(false); ok {
+					saveProgress(cp, j.src.name, n, opts.Logger)
+				}
+				j.src.wg.Done()
+			}
+		}()
+	}
+
+	// 进度日志
+	stopProgress := make(chan struct{})
+	if opts.Logger != nil {
+		go func() {
+			ticker := time.NewTicker(10 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-stopProgress:
+					return
+				case <-ticker.C:
+					opts.Logger.Printf("导入进度: 来源 %d, 新增 %d, 跳过 %d, 失败 %d",
+						atomic.LoadInt64(&stats.Sources), atomic.LoadInt64(&stats.Imported),
+						atomic.LoadInt64(&stats.Skipped), atomic.LoadInt64(&stats.Failed))
+				}
+			}
+		}()
+	}
+
+	// 来源文件的记录全部处理完后写入断点，中断或有记录失败时写入已连续处理完的记录数
+	var waiters sync.WaitGroup
+	dispatch := func(name string, read func(emit func(Item) error) error) error {
+		if cp.Done(name) {
+			return nil
+		}
+
+		skip := cp.Progress(name)
+		if skip > 0 && opts.Logger != nil {
+			opts.Logger.Printf("从第 %d 条记录继续导入 %s", skip+1, name)
+		}
+		src := newSource(name, skip)
+		var seq int64
+		readErr := read(func(item Item) error {
+			// 上次已处理完的记录仍需读取，但不再处理
+			if seq < skip {
+				seq++
+				return nil
+			}
+			src.wg.Add(1)
+			select {
+			case jobs <- job{src: src, seq: seq, item: item}:
+				seq++
+				return nil
+			case <-ctx.Done():
+				src.wg.Done()
+				return ctx.Err()
+			}
+		})
+
+		atomic.AddInt64(&stats.Sources, 1)
+		waiters.Add(1)
+		go func() {
+			defer waiters.Done()
+			src.wg.Wait()
+			if readErr == nil && !src.failed {
+				if err := cp.Mark(name); err != nil && opts.Logger != nil {
+					opts.Logger.Printf("写入断点失败: %v", err)
+				}
+			} else if n, ok := src.progress(true); ok {
+				saveProgress(cp, name, n, opts.Logger)
+			}
+		}()
+		return readErr
+	}
+
+	var walkErr error
+	for _, root := range paths {
+		walkErr = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if d.IsDir() || formatOf(path) == formatUnknown {
+				return nil
+			}
+
+			err = dispatch(path, func(emit func(Item) error) error {
+				return readFile(path, emit)
+			})
+			if err != nil && ctx.Err() == nil {
+				// 单个文件损坏不影响其他文件
+				if opts.Logger != nil {
+					opts.Logger.Printf("读取失败 %s: %v", path, err)
+				}
+				return nil
+			}
+			return err
+		})
+		if walkErr != nil {
+			break
+		}
+	}
+
+	close(jobs)
+	workers.Wait()
+	waiters.Wait()
+	close(stopProgress)
+
+	return stats, walkErr
+}
+
+// 文件格式
+const (
+	formatUnknown = iota
+	formatTorrent
+	formatTar
+	formatJSONL
+	formatBencode
+)
+
+func formatOf(name string) int {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".torrent"):
+		return formatTorrent
+	case strings.HasSuffix(lower, ".tar"), strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return formatTar
+	case strings.HasSuffix(lower, ".jsonl"), strings.HasSuffix(lower, ".jsonl.gz"):
+		return formatJSONL
+	case strings.HasSuffix(lower, ".bencode"), strings.HasSuffix(lower, ".bencode.gz"):
+		return formatBencode
+	}
+	return formatUnknown
+}
+
+func readFile(path string, emit func(Item) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(strings.ToLower(path), "gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	return readStream(path, formatOf(path), r, emit)
+}
+
+func readStream(name string, format int, r io.Reader, emit func(Item) error) error {
+	switch format {
+	case formatTorrent:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		info, err := metainfo.InfoBytes(data)
+		if err != nil {
+			return err
+		}
+		return emit(infoItem(name, info))
+
+	case formatTar:
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			f := formatOf(hdr.Name)
+			if hdr.Typeflag != tar.TypeReg || f == formatUnknown || f == formatTar {
+				continue
+			}
+			var entry io.Reader = tr
+			if strings.HasSuffix(strings.ToLower(hdr.Name), "gz") {
+				gz, err := gzip.NewReader(tr)
+				if err != nil {
+					return err
+				}
+				entry = gz
+			}
+			if err := readStream(name+":"+hdr.Name, f, entry, emit); err != nil {
+				return err
+			}
+		}
+
+	case formatJSONL:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+		line := 0
+		for sc.Scan() {
+			line++
+			text := strings.TrimSpace(sc.Text())
+			if text == "" {
+				continue
+			}
+			if err := emit(Item{Source: fmt.Sprintf("%s#%d", name, line), Kind: KindJSON, Data: []byte(text)}); err != nil {
+				return err
+			}
+		}
+		return sc.Err()
+
+	case formatBencode:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		for start := 0; start < len(data); {
+			// 允许值之间有换行
+			if data[start] == '\n' || data[start] == '\r' {
+				start++
+				continue
+			}
+			end, err := metainfo.Next(data, start)
+			if err != nil {
+				return fmt.Errorf("offset %d: %v", start, err)
+			}
+			value := data[start:end]
+			info, err := metainfo.InfoBytes(value)
+			if errors.Is(err, metainfo.ErrNoInfo) {
+				// 本身就是 info 字典
+				info, err = value, nil
+			}
+			if err != nil {
+				return fmt.Errorf("offset %d: %v", start, err)
+			}
+			if err := emit(infoItem(fmt.Sprintf("%s@%d", name, start), info)); err != nil {
+				return err
+			}
+			start = end
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported format: %s", name)
+}
+
+// JSONL 单行上限
+const maxLineSize = 16 << 20
+
+func infoItem(source string, info []byte) Item {
+	return Item{
+		Source:   source,
+		Kind:     KindInfo,
+		InfoHash: metainfo.InfoHash(info),
+		Data:     info,
+	}
+}
+
+func saveProgress(cp *checkpoint, name string, n int64, logger *log.Logger) {
+	if err := cp.Save(name, n); err != nil && logger != nil {
+		logger.Printf("写入断点失败: %v", err)
+	}
+}
+
+// 断点文件，每行一条：已完整导入的来源文件为文件名；
+// 部分导入的为 "文件名\t已处理完的记录数"，同一文件取最大值
+type checkpoint struct {
+	mu       sync.Mutex
+	done     map[string]bool
+	progress map[string]int64
+	f        *os.File
+}
+
+func openCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{done: make(map[string]bool), progress: make(map[string]int64)}
+	if path == "" {
+		return cp, nil
+	}
+
+	if f, err := os.Open(path); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			cp.parse(sc.Text())
+		}
+		err := sc.Err()
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("read checkpoint: %v", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("open checkpoint: %v", err)
+	}
+
+	// 每条记录一行，进度行会不断追加，打开时压缩为每个文件一行
+	if err := cp.rewrite(path); err != nil {
+		return nil, fmt.Errorf("write checkpoint: %v", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, fmt.Errorf("open checkpoint: %v", err)
+	}
+	cp.f = f
+	return cp, nil
+}
+
+func (cp *checkpoint) parse(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if i := strings.LastIndexByte(line, '\t'); i >= 0 {
+		if n, err := strconv.ParseInt(line[i+1:], 10, 64); err == nil {
+			cp.progress[line[:i]] = max(cp.progress[line[:i]], n)
+			return
+		}
+	}
+	cp.done[strings.TrimSpace(line)] = true
+}
+
+// 先写临时文件再重命名
+func (cp *checkpoint) rewrite(path string) error {
+	var b strings.Builder
+	for name := range cp.done {
+		b.WriteString(name + "\n")
+	}
+	for name, n := range cp.progress {
+		if !cp.done[name] {
+			fmt.Fprintf(&b, "%s\t%d\n", name, n)
+		}
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Done 返回来源文件是否已导入
+func (cp *checkpoint) Done(name string) bool {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.done[name]
+}
+
+// Progress 返回来源文件中已处理完的记录数
+func (cp *checkpoint) Progress(name string) int64 {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.progress[name]
+}
+
+// Mark 记录来源文件已导入
+func (cp *checkpoint) Mark(name string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.done[name] = true
+	return cp.write(name + "\n")
+}
+
+// Save 记录来源文件中已处理完的记录数
+func (cp *checkpoint) Save(name string, n int64) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.progress[name] = max(cp.progress[name], n)
+	return cp.write(fmt.Sprintf("%s\t%d\n", name, n))
+}
+
+func (cp *checkpoint) write(line string) error {
+	if cp.f == nil {
+		return nil
+	}
+	_, err := cp.f.WriteString(line)
+	return err
+}
+
+func (cp *checkpoint) Close() error {
+	if cp.f == nil {
+		return nil
+	}
+	return cp.f.Close()
+}
@@ -0,0 +1,396 @@
+package importer
+
+import (
+	"DHT-ES-Search/harness"
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func sampleInfo(t *testing.T, name string) []byte {
+	t.Helper()
+	info, err := harness.SampleInfo(name, 100, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// n 行的 JSONL 转储，第 i 行的 name 为 "ti"
+func jsonLines(n int) []byte {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `{"infohash":"%040x","name":"t%d","length":1}`+"\n", i, i)
+	}
+	return []byte(b.String())
+}
+
+func itemName(item Item) string {
+	s := string(item.Data)
+	s = s[strings.Index(s, `"name":"`)+8:]
+	return s[:strings.IndexByte(s, '"')]
+}
+
+// 记录 Handler 收到的记录
+type recorder struct {
+	mu    sync.Mutex
+	items []Item
+}
+
+func (r *recorder) add(item Item) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, item)
+}
+
+func (r *recorder) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res []string
+	for _, item := range r.items {
+		res = append(res, itemName(item))
+	}
+	return res
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"a.torrent", formatTorrent},
+		{"A.TORRENT", formatTorrent},
+		{"a.tar", formatTar},
+		{"a.tar.gz", formatTar},
+		{"a.tgz", formatTar},
+		{"a.jsonl", formatJSONL},
+		{"a.jsonl.gz", formatJSONL},
+		{"a.bencode", formatBencode},
+		{"a.bencode.gz", formatBencode},
+		{"a.json", formatUnknown},
+		{"a.torrent.txt", formatUnknown},
+		{"torrent", formatUnknown},
+	}
+
+	for _, tt := range tests {
+		if got := formatOf(tt.name); got != tt.want {
+			t.Errorf("formatOf(%q) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 递归遍历目录，按扩展名读取各种格式，忽略未知文件
+func TestRunFormats(t *testing.T) {
+	dir := t.TempDir()
+	a := sampleInfo(t, "a")
+	b := sampleInfo(t, "b")
+	c := sampleInfo(t, "c")
+
+	writeFile(t, filepath.Join(dir, "a.torrent"), append(append([]byte("d4:info"), a...), 'e'))
+	writeFile(t, filepath.Join(dir, "sub", "dump.jsonl"), append([]byte("\n"), jsonLines(2)...))
+	writeFile(t, filepath.Join(dir, "sub", "deep", "dump.jsonl.gz"), gzipped(t, jsonLines(1)))
+	writeFile(t, filepath.Join(dir, "infos.bencode"), append(append(b, '\n'), c...))
+	writeFile(t, filepath.Join(dir, "notes.txt"), []byte("not a dump"))
+
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{
+		{"x/a.torrent", append(append([]byte("d4:info"), a...), 'e')},
+		{"x/y.jsonl", jsonLines(1)},
+		{"x/readme.md", []byte("ignored")},
+	} {
+		tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg})
+		tw.Write(entry.data)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "pack.tar.gz"), gzipped(t, tarball.Bytes()))
+
+	var rec recorder
+	stats, err := Run(context.Background(), []string{dir}, Options{Workers: 2}, func(ctx context.Context, item Item) error {
+		rec.add(item)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, item := range rec.items {
+		got = append(got, strings.TrimPrefix(item.Source, dir+string(filepath.Separator)))
+		if item.Kind == KindInfo && !bytes.Equal(item.InfoHash, infoHashOf(item.Data)) {
+			t.Errorf("%s: infohash not computed from the info bytes", item.Source)
+		}
+	}
+	sort.Strings(got)
+	infos := fmt.Sprintf("infos.bencode@%d", len(b)+1)
+	want := []string{
+		"a.torrent",
+		"infos.bencode@0",
+		infos,
+		"pack.tar.gz:x/a.torrent",
+		"pack.tar.gz:x/y.jsonl#1",
+		filepath.Join("sub", "deep", "dump.jsonl.gz") + "#1",
+		filepath.Join("sub", "dump.jsonl") + "#2",
+		filepath.Join("sub", "dump.jsonl") + "#3",
+	}
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sources = %q\nwant %q", got, want)
+	}
+	if stats.Sources != 5 || stats.Imported != int64(len(want)) {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func infoHashOf(info []byte) []byte {
+	return infoItem("", info).InfoHash
+}
+
+// 暂时失败使断点停在该记录之前，下次从这里继续；已完整导入的文件不再读取
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump.jsonl")
+	writeFile(t, dump, jsonLines(10))
+	cp := filepath.Join(dir, "import.checkpoint")
+	opts := Options{Workers: 1, Checkpoint: cp}
+
+	var first recorder
+	stats, err := Run(context.Background(), []string{dump}, opts, func(ctx context.Context, item Item) error {
+		first.add(item)
+		if itemName(item) == "t6" {
+			return errors.New("database is down")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Imported != 9 || stats.Failed != 1 {
+		t.Errorf("first run stats = %+v", stats)
+	}
+
+	var second recorder
+	stats, err = Run(context.Background(), []string{dump}, opts, func(ctx context.Context, item Item) error {
+		second.add(item)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"t6", "t7", "t8", "t9", "t10"}; !reflect.DeepEqual(second.names(), want) {
+		t.Errorf("second run handled %q, want %q", second.names(), want)
+	}
+
+	var third recorder
+	stats, err = Run(context.Background(), []string{dump}, opts, func(ctx context.Context, item Item) error {
+		third.add(item)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(third.items) != 0 || stats.Sources != 0 {
+		t.Errorf("third run handled %q, stats %+v, want nothing", third.names(), stats)
+	}
+
+	// 打开时压缩为每个文件一行
+	data, err := os.ReadFile(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != dump+"\n" {
+		t.Errorf("checkpoint = %q, want %q", data, dump+"\n")
+	}
+}
+
+func TestCheckpointFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "import.checkpoint")
+	// 旧版本只记录文件名
+	writeFile(t, path, []byte("old.tar\n\npart.jsonl\t10\npart.jsonl\t30\npart.jsonl\t20\nweird\tname.jsonl\t5\ndone.jsonl\t7\ndone.jsonl\n"))
+
+	cp, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		done     bool
+		progress int64
+	}{
+		{"old.tar", true, 0},
+		{"part.jsonl", false, 30},
+		{"weird\tname.jsonl", false, 5},
+		{"done.jsonl", true, 7},
+		{"new.jsonl", false, 0},
+	}
+	for _, tt := range tests {
+		if cp.Done(tt.name) != tt.done || cp.Progress(tt.name) != tt.progress {
+			t.Errorf("%q: Done = %v, Progress = %d, want %v, %d", tt.name, cp.Done(tt.name), cp.Progress(tt.name), tt.done, tt.progress)
+		}
+	}
+
+	if err := cp.Save("new.jsonl", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := cp.Mark("part.jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	cp.Close()
+
+	reopened, err := openCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if !reopened.Done("part.jsonl") || reopened.Progress("new.jsonl") != 1000 || !reopened.Done("old.tar") {
+		t.Errorf("reopened checkpoint lost entries: done %v, progress %v", reopened.done, reopened.progress)
+	}
+}
+
+// 无法解析的记录记为失败但断点越过它；已存在的记录计入跳过
+func TestInvalidAndSkipped(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump.jsonl")
+	writeFile(t, dump, jsonLines(6))
+	opts := Options{Workers: 3, Checkpoint: filepath.Join(dir, "import.checkpoint")}
+
+	stats, err := Run(context.Background(), []string{dump}, opts, func(ctx context.Context, item Item) error {
+		switch itemName(item) {
+		case "t2":
+			return fmt.Errorf("%w: bad name", ErrInvalid)
+		case "t3", "t5":
+			return ErrSkipped
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Stats{Sources: 1, Imported: 3, Skipped: 2, Failed: 1}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+
+	var rec recorder
+	if _, err := Run(context.Background(), []string{dump}, opts, func(ctx context.Context, item Item) error {
+		rec.add(item)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.items) != 0 {
+		t.Errorf("rerun handled %q, want nothing", rec.names())
+	}
+}
+
+// Ctrl-C 后排队的记录不处理、不计入失败，下次从中断处继续
+func TestCancel(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump.jsonl")
+	writeFile(t, dump, jsonLines(20))
+	opts := Options{Workers: 1, Checkpoint: filepath.Join(dir, "import.checkpoint")}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var first recorder
+	stats, err := Run(ctx, []string{dump}, opts, func(ctx context.Context, item Item) error {
+		first.add(item)
+		if itemName(item) == "t3" {
+			// 处理中被中断
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run error = %v, want context.Canceled", err)
+	}
+	if want := []string{"t1", "t2", "t3"}; !reflect.DeepEqual(first.names(), want) {
+		t.Errorf("handled %q after cancel, want %q", first.names(), want)
+	}
+	if stats.Imported != 2 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want 2 imported and no failures", stats)
+	}
+
+	var second recorder
+	if _, err := Run(context.Background(), []string{dump}, opts, func(ctx context.Context, item Item) error {
+		second.add(item)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	names := second.names()
+	if len(names) != 18 || names[0] != "t3" || names[17] != "t20" {
+		t.Errorf("resumed run handled %q, want t3..t20", names)
+	}
+}
+
+// 并发处理时乱序完成的记录只在前面的记录都完成后才计入断点
+func TestSourceProgress(t *testing.T) {
+	s := newSource("dump.jsonl", 2)
+	steps := []struct {
+		finish, fail int64 // -1 表示无
+		want         int64
+	}{
+		{finish: 3, fail: -1, want: 2},
+		{finish: 2, fail: -1, want: 4},
+		{finish: 5, fail: -1, want: 4},
+		{finish: -1, fail: 4, want: 4},
+		{finish: 6, fail: -1, want: 4},
+	}
+	for i, st := range steps {
+		if st.finish >= 0 {
+			s.finish(st.finish)
+		}
+		if st.fail >= 0 {
+			s.fail(st.fail)
+		}
+		if s.done != st.want {
+			t.Errorf("step %d: done = %d, want %d", i, s.done, st.want)
+		}
+	}
+	if len(s.finished) != 1 {
+		t.Errorf("kept %d finished records past the failure, want only the one before it", len(s.finished))
+	}
+	if n, ok := s.progress(true); !ok || n != 4 {
+		t.Errorf("progress = %d, %v, want 4", n, ok)
+	}
+	if _, ok := s.progress(true); ok {
+		t.Error("progress reported twice without change")
+	}
+}
@@ -0,0 +1,134 @@
+// Package metainfo 处理 .torrent 文件与 info 字典的原始 bencode 数据
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"github.com/shiyanhui/dht"
+	"sort"
+	"strconv"
+)
+
+// ErrNoInfo 表示字典中没有 info 键，即该字典本身可能就是 info 字典
+var ErrNoInfo = errors.New("info dict not found")
+
+// InfoBytes 返回 .torrent 文件中 info 字典的原始字节，
+// infohash 必须基于原始字节计算，不能解码后重新编码
+func InfoBytes(torrent []byte) ([]byte, error) {
+	if len(torrent) == 0 || torrent[0] != 'd' {
+		return nil, errors.New("not a bencoded dict")
+	}
+
+	for i := 1; i < len(torrent) && torrent[i] != 'e'; {
+		key, next, err := dht.DecodeString(torrent, i)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dict key: %v", err)
+		}
+
+		end, err := skip(torrent, next)
+		if err != nil {
+			return nil, err
+		}
+
+		if key.(string) == "info" {
+			if torrent[next] != 'd' {
+				return nil, errors.New("info is not a dict")
+			}
+			return torrent[next:end], nil
+		}
+		i = end
+	}
+
+	return nil, ErrNoInfo
+}
+
+// InfoHash 计算 info 字典的 SHA1
+func InfoHash(info []byte) []byte {
+	sum := sha1.Sum(info)
+	return sum[:]
+}
+
+// Next 返回 start 处一个完整 bencode 值的结束位置，用于切分连续存放的多个值
+func Next(data []byte, start int) (int, error) {
+	return skip(data, start)
+}
+
+// 跳过 start 处的一个值，返回其结束位置
+func skip(data []byte, start int) (end int, err error) {
+	if start >= len(data) {
+		return 0, errors.New("unexpected end of data")
+	}
+
+	switch data[start] {
+	case 'd':
+		_, end, err = dht.DecodeDict(data, start)
+	case 'l':
+		_, end, err = dht.DecodeList(data, start)
+	case 'i':
+		_, end, err = dht.DecodeInt(data, start)
+	default:
+		_, end, err = dht.DecodeString(data, start)
+	}
+	return
+}
+
+// Encode 按规范（字典键排序）编码 bencode，
+// 支持 string、[]byte、int、int64、[]interface{}、[]string 和 map[string]interface{}
+func Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch x := v.(type) {
+	case string:
+		buf.WriteString(strconv.Itoa(len(x)))
+		buf.WriteByte(':')
+		buf.WriteString(x)
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(x)))
+		buf.WriteByte(':')
+		buf.Write(x)
+	case int:
+		buf.WriteString("i" + strconv.Itoa(x) + "e")
+	case int64:
+		buf.WriteString("i" + strconv.FormatInt(x, 10) + "e")
+	case []string:
+		buf.WriteByte('l')
+		for _, s := range x {
+			encode(buf, s)
+		}
+		buf.WriteByte('e')
+	case []interface{}:
+		buf.WriteByte('l')
+		for _, item := range x {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, k := range keys {
+			encode(buf, k)
+			if err := encode(buf, x[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("cannot bencode %T", v)
+	}
+	return nil
+}
@@ -0,0 +1,530 @@
+// Package querylang 解析搜索框中的高级查询语法：
+//
+//	"exact phrase" -cam size:>2GB ext:mkv files:<5 added:2024-01..2024-06 hash:abcd*
+//
+// 普通词须全部出现，引号内为短语，- 前缀排除词或短语，field:value 为字段过滤。
+// 解析结果与存储无关，由各搜索后端编译为自己的查询。
+package querylang
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expr 解析后的查询
+type Expr struct {
+	Terms          []string  // 须全部出现的词
+	Phrases        []string  // 须在名称或文件路径中连续出现的短语
+	ExcludeTerms   []string  // 不得出现的词
+	ExcludePhrases []string  // 不得出现的短语
+	Ext            []string  // 含有任一扩展名的文件，小写、不含点
+	ExcludeExt     []string  // 不得含有的扩展名
+	Size           Range     // 总大小，字节
+	Files          Range     // 文件数，单文件种子为 1
+	Added          DateRange // 收录时间
+	Hash           string    // infohash 前缀，小写十六进制
+}
+
+// HasText 返回是否有须匹配的词或短语
+func (e *Expr) HasText() bool {
+	return len(e.Terms) > 0 || len(e.Phrases) > 0
+}
+
+// Text 返回须匹配的词和短语，以空格连接，用于分词和高亮
+func (e *Expr) Text() string {
+	return strings.Join(append(append([]string(nil), e.Terms...), e.Phrases...), " ")
+}
+
+// Range 整数闭区间，Min/Max 为 nil 时不限
+type Range struct {
+	Min, Max *int64
+}
+
+// IsZero 返回是否不限
+func (r Range) IsZero() bool {
+	return r.Min == nil && r.Max == nil
+}
+
+// Contains 返回 v 是否在区间内
+func (r Range) Contains(v int64) bool {
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+// DateRange 时间区间 [From, To)，零值表示不限
+type DateRange struct {
+	From, To time.Time
+}
+
+// IsZero 返回是否不限
+func (r DateRange) IsZero() bool {
+	return r.From.IsZero() && r.To.IsZero()
+}
+
+// SyntaxError 语法错误
+type SyntaxError struct {
+	Pos int // 出错位置，从 0 开始的字符下标
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// 支持的过滤字段
+const (
+	fieldSize  = "size"
+	fieldExt   = "ext"
+	fieldFiles = "files"
+	fieldAdded = "added"
+	fieldHash  = "hash"
+)
+
+func isField(name string) bool {
+	switch name {
+	case fieldSize, fieldExt, fieldFiles, fieldAdded, fieldHash:
+		return true
+	}
+	return false
+}
+
+// Parse 解析查询串。未知字段（如 Re:Zero）按普通词处理
+func Parse(s string) (*Expr, error) {
+	p := &parser{runes: []rune(s), expr: &Expr{}, seen: make(map[string]bool)}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.expr, nil
+}
+
+type parser struct {
+	runes []rune
+	pos   int
+	expr  *Expr
+	seen  map[string]bool // 已出现的单值字段
+	spans [][2]int        // Terms 中每个词在 runes 中的位置
+}
+
+func (p *parser) errorf(pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parse() error {
+	for {
+		for p.pos < len(p.runes) && unicode.IsSpace(p.runes[p.pos]) {
+			p.pos++
+		}
+		if p.pos >= len(p.runes) {
+			return nil
+		}
+
+		start := p.pos
+		negate := false
+		if p.runes[p.pos] == '-' {
+			negate = true
+			p.pos++
+			// 单独的 - 忽略
+			if p.pos >= len(p.runes) || unicode.IsSpace(p.runes[p.pos]) {
+				continue
+			}
+		}
+
+		if p.runes[p.pos] == '"' {
+			phrase, err := p.phrase()
+			if err != nil {
+				return err
+			}
+			if negate {
+				p.expr.ExcludePhrases = append(p.expr.ExcludePhrases, phrase)
+			} else {
+				p.expr.Phrases = append(p.expr.Phrases, phrase)
+			}
+			continue
+		}
+
+		word := p.word()
+		if i := strings.IndexByte(word, ':'); i > 0 && isField(strings.ToLower(word[:i])) {
+			if err := p.field(start, negate, strings.ToLower(word[:i]), word[i+1:]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if negate {
+			p.expr.ExcludeTerms = append(p.expr.ExcludeTerms, word)
+		} else {
+			p.expr.Terms = append(p.expr.Terms, word)
+			p.spans = append(p.spans, [2]int{start, p.pos})
+		}
+	}
+}
+
+// ReplaceTerms 把查询串中的普通词依次替换为 terms 中的词，短语、排除词和字段保持原样，
+// 用于生成拼写纠正后的查询。查询无法解析或词数不一致时返回原串
+func ReplaceTerms(s string, terms []string) string {
+	p := &parser{runes: []rune(s), expr: &Expr{}, seen: make(map[string]bool)}
+	if p.parse() != nil || len(p.spans) != len(terms) {
+		return s
+	}
+
+	var b strings.Builder
+	last := 0
+	for i, span := range p.spans {
+		b.WriteString(string(p.runes[last:span[0]]))
+		b.WriteString(terms[i])
+		last = span[1]
+	}
+	b.WriteString(string(p.runes[last:]))
+	return b.String()
+}
+
+// 引号内的短语，p.pos 指向左引号
+func (p *parser) phrase() (string, error) {
+	start := p.pos
+	p.pos++
+	for p.pos < len(p.runes) && p.runes[p.pos] != '"' {
+		p.pos++
+	}
+	if p.pos >= len(p.runes) {
+		return "", p.errorf(start, "missing closing quote")
+	}
+
+	phrase := strings.TrimSpace(string(p.runes[start+1 : p.pos]))
+	p.pos++
+	if phrase == "" {
+		return "", p.errorf(start, "empty phrase")
+	}
+	return phrase, nil
+}
+
+// 到空白或引号为止的词
+func (p *parser) word() string {
+	start := p.pos
+	for p.pos < len(p.runes) && !unicode.IsSpace(p.runes[p.pos]) && p.runes[p.pos] != '"' {
+		p.pos++
+	}
+	return string(p.runes[start:p.pos])
+}
+
+func (p *parser) field(pos int, negate bool, name, value string) error {
+	if value == "" {
+		return p.errorf(pos, "missing value for %s:", name)
+	}
+
+	if name == fieldExt {
+		exts, err := parseExt(value)
+		if err != nil {
+			return p.errorf(pos, "%s", err)
+		}
+		if negate {
+			p.expr.ExcludeExt = append(p.expr.ExcludeExt, exts...)
+		} else {
+			p.expr.Ext = append(p.expr.Ext, exts...)
+		}
+		return nil
+	}
+
+	if negate {
+		return p.errorf(pos, "-%s: is not supported, use a range instead", name)
+	}
+	if p.seen[name] {
+		return p.errorf(pos, "duplicate %s: filter", name)
+	}
+	p.seen[name] = true
+
+	var err error
+	switch name {
+	case fieldSize:
+		p.expr.Size, err = parseRange(value, parseSize)
+	case fieldFiles:
+		p.expr.Files, err = parseRange(value, parseCount)
+	case fieldAdded:
+		p.expr.Added, err = parseDateRange(value)
+	case fieldHash:
+		p.expr.Hash, err = parseHash(value)
+	}
+	if err != nil {
+		return p.errorf(pos, "%s: %s", name, err)
+	}
+	return nil
+}
+
+// ext:mkv,mp4
+func parseExt(value string) ([]string, error) {
+	var exts []string
+	for _, ext := range strings.Split(value, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext == "" {
+			continue
+		}
+		for _, r := range ext {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				return nil, fmt.Errorf("invalid extension %q", ext)
+			}
+		}
+		exts = append(exts, ext)
+	}
+	if len(exts) == 0 {
+		return nil, fmt.Errorf("missing value for ext:")
+	}
+	return exts, nil
+}
+
+// 数值的解析结果：value 为下限，value+step-1 为按精度理解的上限
+type number struct {
+	value, step int64
+}
+
+// 大小单位，按 1024 进位，与页面显示一致
+var sizeUnits = map[string]int64{
+	"":  1,
+	"b": 1,
+	"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+	"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+	"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	"t": 1 << 40, "tb": 1 << 40, "tib": 1 << 40,
+}
+
+// 2GB、1.5g、700mb；不带比较符时按精度匹配，如 size:1.5GB 表示 [1.5GB, 1.6GB)
+func parseSize(s string) (number, error) {
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	num, unitName := s[:i], strings.ToLower(s[i:])
+
+	unit, ok := sizeUnits[unitName]
+	if !ok {
+		return number{}, fmt.Errorf("unknown size unit %q", s[i:])
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return number{}, fmt.Errorf("invalid size %q", s)
+	}
+
+	decimals := 0
+	if dot := strings.IndexByte(num, '.'); dot >= 0 {
+		decimals = len(num) - dot - 1
+	}
+	step := float64(unit) / math.Pow10(decimals)
+	if step < 1 {
+		step = 1
+	}
+
+	value := f * float64(unit)
+	if value > math.MaxInt64/2 {
+		return number{}, fmt.Errorf("size %q is too large", s)
+	}
+	return number{value: int64(math.Round(value)), step: int64(step)}, nil
+}
+
+// ParseSize 解析带单位的大小，如 700MB、1.5G，返回字节数
+func ParseSize(s string) (int64, error) {
+	n, err := parseSize(strings.TrimSpace(s))
+	return n.value, err
+}
+
+// FormatSize 以能整除的最大单位格式化字节数，结果可由 ParseSize 还原
+func FormatSize(n int64) string {
+	for _, u := range []struct {
+		name string
+		size int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n >= u.size && n%u.size == 0 {
+			return strconv.FormatInt(n/u.size, 10) + u.name
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func parseCount(s string) (number, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return number{}, fmt.Errorf("invalid number %q", s)
+	}
+	return number{value: n, step: 1}, nil
+}
+
+func int64p(v int64) *int64 {
+	return &v
+}
+
+// >a、>=a、<a、<=a、a..b、a..、..b 或单个值
+func parseRange(value string, parse func(string) (number, error)) (Range, error) {
+	var r Range
+
+	if lo, hi, ok := strings.Cut(value, ".."); ok {
+		if lo == "" && hi == "" {
+			return r, fmt.Errorf("empty range")
+		}
+		if lo != "" {
+			n, err := parse(lo)
+			if err != nil {
+				return r, err
+			}
+			r.Min = int64p(n.value)
+		}
+		if hi != "" {
+			n, err := parse(hi)
+			if err != nil {
+				return r, err
+			}
+			r.Max = int64p(n.value)
+		}
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			return r, fmt.Errorf("empty range %q", value)
+		}
+		return r, nil
+	}
+
+	op, operand := splitOperator(value)
+	n, err := parse(operand)
+	if err != nil {
+		return r, err
+	}
+
+	switch op {
+	case ">":
+		r.Min = int64p(n.value + 1)
+	case ">=":
+		r.Min = int64p(n.value)
+	case "<":
+		if n.value == 0 {
+			return r, fmt.Errorf("empty range %q", value)
+		}
+		r.Max = int64p(n.value - 1)
+	case "<=":
+		r.Max = int64p(n.value)
+	default:
+		r.Min = int64p(n.value)
+		r.Max = int64p(n.value + n.step - 1)
+	}
+	return r, nil
+}
+
+func splitOperator(s string) (string, string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(s, op) {
+			return op, s[len(op):]
+		}
+	}
+	return "", s
+}
+
+// 2024、2024-03、2024-03-05，返回所表示的时间段 [start, end)
+func parseDate(s string) (time.Time, time.Time, error) {
+	for _, f := range []struct {
+		layout string
+		next   func(time.Time) time.Time
+	}{
+		{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+		{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+		{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+	} {
+		if t, err := time.Parse(f.layout, s); err == nil {
+			return t, f.next(t), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("invalid date %q, use YYYY, YYYY-MM or YYYY-MM-DD", s)
+}
+
+func parseDateRange(value string) (DateRange, error) {
+	var r DateRange
+
+	if lo, hi, ok := strings.Cut(value, ".."); ok {
+		if lo == "" && hi == "" {
+			return r, fmt.Errorf("empty range")
+		}
+		if lo != "" {
+			start, _, err := parseDate(lo)
+			if err != nil {
+				return r, err
+			}
+			r.From = start
+		}
+		if hi != "" {
+			_, end, err := parseDate(hi)
+			if err != nil {
+				return r, err
+			}
+			r.To = end
+		}
+		if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+			return r, fmt.Errorf("empty range %q", value)
+		}
+		return r, nil
+	}
+
+	op, operand := splitOperator(value)
+	start, end, err := parseDate(operand)
+	if err != nil {
+		return r, err
+	}
+
+	switch op {
+	case ">":
+		r.From = end
+	case ">=":
+		r.From = start
+	case "<":
+		r.To = start
+	case "<=":
+		r.To = end
+	default:
+		r.From, r.To = start, end
+	}
+	return r, nil
+}
+
+// hash:<40 位十六进制> 或 hash:<前缀>*
+func parseHash(value string) (string, error) {
+	prefix := strings.ToLower(value)
+	wildcard := strings.HasSuffix(prefix, "*")
+	prefix = strings.TrimSuffix(prefix, "*")
+
+	if prefix == "" || len(prefix) > 40 {
+		return "", fmt.Errorf("invalid infohash %q", value)
+	}
+	for _, r := range prefix {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'f') {
+			return "", fmt.Errorf("invalid infohash %q", value)
+		}
+	}
+	if !wildcard && len(prefix) != 40 {
+		return "", fmt.Errorf("infohash must have 40 hex digits, or end with * to match a prefix")
+	}
+	return prefix, nil
+}
+
+// Words 将文本按非字母数字字符切分为小写单词，用于短语匹配
+func Words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ContainsPhrase 返回 text 的单词序列中是否连续出现 phrase 的全部单词
+func ContainsPhrase(text, phrase string) bool {
+	want := Words(phrase)
+	if len(want) == 0 {
+		return false
+	}
+
+	words := Words(text)
+	for i := 0; i+len(want) <= len(words); i++ {
+		match := true
+		for j, w := range want {
+			if words[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
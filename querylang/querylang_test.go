@@ -0,0 +1,160 @@
+package querylang
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  Expr
+	}{
+		{"terms", "ubuntu desktop", Expr{Terms: []string{"ubuntu", "desktop"}}},
+		{"phrase", `"exact phrase"`, Expr{Phrases: []string{"exact phrase"}}},
+		{"exclude term", "-cam", Expr{ExcludeTerms: []string{"cam"}}},
+		{"exclude phrase", `-"bad rip"`, Expr{ExcludePhrases: []string{"bad rip"}}},
+		{"size greater", "size:>2GB", Expr{Size: Range{Min: int64p(2<<30 + 1)}}},
+		{"size precision", "size:1.5GB", Expr{Size: Range{Min: int64p(3 << 29), Max: int64p(3<<29 + 1<<30/10 - 1)}}},
+		{"size range", "size:700mb..2g", Expr{Size: Range{Min: int64p(700 << 20), Max: int64p(2 << 30)}}},
+		{"ext", "ext:mkv", Expr{Ext: []string{"mkv"}}},
+		{"ext list", "ext:.MKV,mp4", Expr{Ext: []string{"mkv", "mp4"}}},
+		{"exclude ext", "-ext:iso", Expr{ExcludeExt: []string{"iso"}}},
+		{"files less", "files:<5", Expr{Files: Range{Max: int64p(4)}}},
+		{"files exact", "files:1", Expr{Files: Range{Min: int64p(1), Max: int64p(1)}}},
+		{"added months", "added:2024-01..2024-06", Expr{Added: DateRange{From: date(2024, 1, 1), To: date(2024, 7, 1)}}},
+		{"added year", "added:2023", Expr{Added: DateRange{From: date(2023, 1, 1), To: date(2024, 1, 1)}}},
+		{"added after day", "added:>2024-03-05", Expr{Added: DateRange{From: date(2024, 3, 6)}}},
+		{"hash prefix", "hash:ABCD*", Expr{Hash: "abcd"}},
+		{"hash full", "hash:00112233445566778899aabbccddeeff00112233", Expr{Hash: "00112233445566778899aabbccddeeff00112233"}},
+		// 未知字段按普通词处理
+		{"unknown field", "Re:Zero", Expr{Terms: []string{"Re:Zero"}}},
+		{"lone dash", "a - b", Expr{Terms: []string{"a", "b"}}},
+		{"all examples", `"exact phrase" -cam size:>2GB ext:mkv files:<5 added:2024-01..2024-06 hash:abcd*`, Expr{
+			Phrases:      []string{"exact phrase"},
+			ExcludeTerms: []string{"cam"},
+			Ext:          []string{"mkv"},
+			Size:         Range{Min: int64p(2<<30 + 1)},
+			Files:        Range{Max: int64p(4)},
+			Added:        DateRange{From: date(2024, 1, 1), To: date(2024, 7, 1)},
+			Hash:         "abcd",
+		}},
+		{"empty", "  ", Expr{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.query)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.query, *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		pos   int
+		msg   string
+	}{
+		{"missing closing quote", `foo "bar`, 4, "missing closing quote"},
+		{"empty phrase", `foo ""`, 4, "empty phrase"},
+		{"blank phrase", `foo -"  "`, 5, "empty phrase"},
+		{"negated size", "a -size:>1GB", 2, "-size: is not supported, use a range instead"},
+		{"duplicate size", "size:1GB size:2GB", 9, "duplicate size: filter"},
+		{"missing value", "a size:", 2, "missing value for size:"},
+		{"missing ext", "ext:,", 0, "missing value for ext:"},
+		{"bad unit", "size:2XB", 0, `size: unknown size unit "XB"`},
+		{"reversed range", "files:5..1", 0, `files: empty range "5..1"`},
+		{"bad date", "added:2024-13", 0, `added: invalid date "2024-13", use YYYY, YYYY-MM or YYYY-MM-DD`},
+		{"short hash", "hash:abcd", 0, "hash: infohash must have 40 hex digits, or end with * to match a prefix"},
+		// 位置按字符而不是字节计算
+		{"cjk position", `流浪 "地球`, 3, "missing closing quote"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.query)
+			serr, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("Parse(%q) error = %v, want *SyntaxError", tt.query, err)
+			}
+			if serr.Pos != tt.pos || serr.Msg != tt.msg {
+				t.Errorf("Parse(%q) = {%d %q}, want {%d %q}", tt.query, serr.Pos, serr.Msg, tt.pos, tt.msg)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorMessage(t *testing.T) {
+	_, err := Parse(`foo "bar`)
+	if want := "syntax error at position 5: missing closing quote"; err == nil || err.Error() != want {
+		t.Errorf("Error() = %v, want %q", err, want)
+	}
+}
+
+func TestReplaceTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		terms []string
+		want  string
+	}{
+		{"terms only", "helo wrld", []string{"hello", "world"}, "hello world"},
+		// 短语、排除词和字段按原样保留，包括空白
+		{"keeps the rest", `helo  "exact  phrase" -cam	wrld size:>2GB ext:mkv,MP4 -"bad rip" hash:abcd*`, []string{"hello", "world"},
+			`hello  "exact  phrase" -cam	world size:>2GB ext:mkv,MP4 -"bad rip" hash:abcd*`},
+		{"unknown field is a term", "Re:Zro", []string{"Re:Zero"}, "Re:Zero"},
+		{"cjk", `流浪 "地球" 第二部`, []string{"流浪者", "第二季"}, `流浪者 "地球" 第二季`},
+		{"count mismatch", "helo wrld", []string{"hello"}, "helo wrld"},
+		{"syntax error", `helo "wrld`, []string{"hello"}, `helo "wrld`},
+		{"no terms", `"a b" size:1GB`, nil, `"a b" size:1GB`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReplaceTerms(tt.query, tt.terms); got != tt.want {
+				t.Errorf("ReplaceTerms(%q, %q) = %q, want %q", tt.query, tt.terms, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContainsPhrase(t *testing.T) {
+	tests := []struct {
+		text, phrase string
+		want         bool
+	}{
+		{"Foo - Bar.mkv", "foo bar", true},
+		{"foo  bar", "foo bar", true},
+		{"foo baz bar", "foo bar", false},
+		{"foobar", "foo bar", false},
+		{"anything", " - ", false},
+	}
+
+	for _, tt := range tests {
+		if got := ContainsPhrase(tt.text, tt.phrase); got != tt.want {
+			t.Errorf("ContainsPhrase(%q, %q) = %v, want %v", tt.text, tt.phrase, got, tt.want)
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	for _, n := range []int64{0, 1, 1023, 1 << 10, 700 << 20, 3 << 30, 1 << 40} {
+		s := FormatSize(n)
+		if got, err := ParseSize(s); err != nil || got != n {
+			t.Errorf("ParseSize(FormatSize(%d)) = %d, %v (via %q)", n, got, err, s)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package release
+
+import (
+	"path"
+	"strings"
+)
+
+// 种子分类
+const (
+	CategoryMovie    = "movie"
+	CategoryTV       = "tv"
+	CategoryAudio    = "audio"
+	CategorySoftware = "software"
+	CategoryEbook    = "ebook"
+	CategoryImage    = "image"
+	CategoryArchive  = "archive"
+	CategoryOther    = "other"
+)
+
+// Categories 所有分类
+var Categories = []string{
+	CategoryMovie, CategoryTV, CategoryAudio, CategorySoftware,
+	CategoryEbook, CategoryImage, CategoryArchive, CategoryOther,
+}
+
+var extCategories = map[string]string{
+	".mkv": CategoryMovie, ".mp4": CategoryMovie, ".avi": CategoryMovie, ".wmv": CategoryMovie,
+	".ts": CategoryMovie, ".m2ts": CategoryMovie, ".rmvb": CategoryMovie, ".flv": CategoryMovie,
+	".mov": CategoryMovie, ".webm": CategoryMovie, ".mpg": CategoryMovie, ".vob": CategoryMovie,
+
+	".mp3": CategoryAudio, ".flac": CategoryAudio, ".ape": CategoryAudio, ".wav": CategoryAudio,
+	".m4a": CategoryAudio, ".ogg": CategoryAudio, ".aac": CategoryAudio, ".dsf": CategoryAudio,
+
+	".exe": CategorySoftware, ".msi": CategorySoftware, ".dmg": CategorySoftware, ".apk": CategorySoftware,
+	".deb": CategorySoftware, ".rpm": CategorySoftware, ".pkg": CategorySoftware, ".iso": CategorySoftware,
+
+	".pdf": CategoryEbook, ".epub": CategoryEbook, ".mobi": CategoryEbook, ".azw3": CategoryEbook,
+	".djvu": CategoryEbook, ".txt": CategoryEbook,
+
+	".jpg": CategoryImage, ".jpeg": CategoryImage, ".png": CategoryImage, ".gif": CategoryImage,
+	".webp": CategoryImage, ".bmp": CategoryImage,
+
+	".zip": CategoryArchive, ".rar": CategoryArchive, ".7z": CategoryArchive,
+	".gz": CategoryArchive, ".tar": CategoryArchive,
+}
+
+// Category 按总大小最大的文件类型判断分类，视频再按 info 中的季/集区分电影和剧集。
+// 单文件种子 paths 为 nil，以种子名称的扩展名判断。
+func Category(name string, info Info, paths []string, lengths []int) string {
+	if len(paths) == 0 {
+		paths, lengths = []string{name}, []int{1}
+	}
+
+	sizes := make(map[string]int)
+	for i, p := range paths {
+		if c, ok := extCategories[strings.ToLower(path.Ext(p))]; ok {
+			sizes[c] += lengths[i] + 1
+		}
+	}
+
+	best, bestSize := CategoryOther, 0
+	for _, c := range Categories {
+		if sizes[c] > bestSize {
+			best, bestSize = c, sizes[c]
+		}
+	}
+
+	// 无扩展名时参考名称中的视频信息
+	if best == CategoryOther && (info.Resolution != "" || info.VideoCodec != "" || info.Source != "") {
+		best = CategoryMovie
+	}
+
+	if best == CategoryMovie {
+		if info.Season > 0 || info.Episode > 0 {
+			return CategoryTV
+		}
+	}
+	return best
+}
@@ -0,0 +1,275 @@
+// Package release 从种子名称中提取发布信息，例如
+// Show.Name.S02E05.1080p.WEB-DL.x265.HEVC-GROUP
+package release
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// 发布组的最大字符数，与 release_group varchar(255) 一致。超出时截断，避免严格模式下整条记录写入失败
+const maxGroupLength = 255
+
+// Info 发布信息，未识别的字段为零值
+type Info struct {
+	Title      string   `json:"title,omitempty"`
+	Year       int      `json:"year,omitempty"`
+	Season     int      `json:"season,omitempty"`
+	Episode    int      `json:"episode,omitempty"`
+	Resolution string   `json:"resolution,omitempty"`
+	VideoCodec string   `json:"video_codec,omitempty"`
+	AudioCodec string   `json:"audio_codec,omitempty"`
+	Source     string   `json:"source,omitempty"`
+	Group      string   `json:"release_group,omitempty"`
+	Languages  []string `json:"languages,omitempty"`
+}
+
+var (
+	// 文件扩展名
+	reExt = regexp.MustCompile(`(?i)\.(mkv|mp4|avi|wmv|ts|m2ts|rmvb|flv|mov|iso|torrent)$`)
+	// 带点号的编码名称，分词前先合并
+	reDotted = regexp.MustCompile(`(?i)\b(h)\.(26[45])\b|\b(dd\+?|ddp|aac|ac3|eac3|dts|truehd|flac)(\d)\.(\d)\b`)
+	// 分隔符
+	reSep = regexp.MustCompile(`[\s._\[\]()【】「」{},]+`)
+	// 行首 [组名]
+	reLeadGroup = regexp.MustCompile(`^\s*[\[【]([^\]】]+)[\]】]`)
+	// 结尾 -组名
+	reTailGroup = regexp.MustCompile(`-([A-Za-z0-9][A-Za-z0-9@&]*)$`)
+
+	reYear       = regexp.MustCompile(`^(19[2-9]\d|20\d\d)$`)
+	reSeasonEp   = regexp.MustCompile(`(?i)^s(\d{1,2})[\s.]?e(\d{1,3})(?:-?e?\d{1,3})?$`)
+	reSeason     = regexp.MustCompile(`(?i)^s(\d{1,2})$|^season(\d{1,2})$`)
+	reEpisode    = regexp.MustCompile(`(?i)^e[p]?(\d{1,3})$`)
+	reCross      = regexp.MustCompile(`^(\d{1,2})x(\d{2,3})$`)
+	reCJKEpisode = regexp.MustCompile(`第(\d{1,4})[集话話]`)
+	reCJKSeason  = regexp.MustCompile(`第([\d一二三四五六七八九十]{1,3})季`)
+	reAnimeEp    = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?(?:\s|$)`)
+	reResolution = regexp.MustCompile(`(?i)^(\d{3,4})([pi])$|^\d{3,4}x(\d{3,4})$`)
+)
+
+var resolutions = map[string]string{
+	"4K":  "2160p",
+	"UHD": "2160p",
+	"8K":  "4320p",
+}
+
+var videoCodecs = map[string]string{
+	"X264": "H.264", "H264": "H.264", "AVC": "H.264",
+	"X265": "H.265", "H265": "H.265", "HEVC": "H.265",
+	"AV1": "AV1", "VP9": "VP9", "XVID": "XviD", "DIVX": "DivX",
+	"MPEG2": "MPEG-2",
+}
+
+var audioCodecs = map[string]string{
+	"AAC": "AAC", "AC3": "AC3", "DD": "AC3",
+	"DDP": "EAC3", "DD+": "EAC3", "EAC3": "EAC3",
+	"DTS": "DTS", "DTS-HD": "DTS-HD", "DTSHD": "DTS-HD", "DTS-X": "DTS:X",
+	"TRUEHD": "TrueHD", "ATMOS": "Atmos", "FLAC": "FLAC",
+	"MP3": "MP3", "OPUS": "Opus", "LPCM": "LPCM",
+}
+
+var sources = map[string]string{
+	"BLURAY": "BluRay", "BLU-RAY": "BluRay", "BDRIP": "BluRay", "BRRIP": "BluRay",
+	"BD": "BluRay", "REMUX": "BluRay",
+	"WEB": "WEB", "WEB-DL": "WEB", "WEBDL": "WEB", "WEBRIP": "WEB",
+	"HDTV": "HDTV", "PDTV": "HDTV",
+	"DVD": "DVD", "DVDRIP": "DVD", "DVD5": "DVD", "DVD9": "DVD",
+	"CAM": "CAM", "HDCAM": "CAM", "TS": "CAM", "TELESYNC": "CAM",
+}
+
+var languages = map[string]string{
+	"ENG": "en", "ENGLISH": "en",
+	"RUS": "ru", "RUSSIAN": "ru",
+	"CHS": "zh", "CHT": "zh", "CHINESE": "zh", "中文": "zh", "国语": "zh", "國語": "zh",
+	"粤语": "zh", "中字": "zh", "简体": "zh", "繁体": "zh",
+	"JPN": "ja", "JAP": "ja", "JAPANESE": "ja", "日语": "ja",
+	"KOR": "ko", "KOREAN": "ko", "韩语": "ko",
+	"FRENCH": "fr", "VOSTFR": "fr", "TRUEFRENCH": "fr",
+	"GERMAN": "de", "ITA": "it", "ITALIAN": "it",
+	"SPA": "es", "SPANISH": "es", "LATINO": "es",
+	"MULTI": "multi", "DUAL": "multi",
+}
+
+// Parse 解析种子名称
+func Parse(name string) Info {
+	var info Info
+
+	name = strings.TrimSpace(reExt.ReplaceAllString(strings.TrimSpace(name), ""))
+
+	// 动漫风格 [组名] 开头
+	if m := reLeadGroup.FindStringSubmatch(name); m != nil {
+		info.Group = truncate(strings.TrimSpace(m[1]), maxGroupLength)
+		name = name[len(m[0]):]
+	}
+
+	// 中文集数/季数
+	if m := reCJKEpisode.FindStringSubmatch(name); m != nil {
+		info.Episode, _ = strconv.Atoi(m[1])
+		name = strings.Replace(name, m[0], " ", 1)
+	}
+	if m := reCJKSeason.FindStringSubmatch(name); m != nil {
+		info.Season = cjkNumber(m[1])
+		name = strings.Replace(name, m[0], " ", 1)
+	}
+
+	// 动漫风格 " - 05 "
+	if info.Episode == 0 {
+		if m := reAnimeEp.FindStringSubmatchIndex(name); m != nil {
+			info.Episode, _ = strconv.Atoi(name[m[2]:m[3]])
+			name = name[:m[0]] + " " + name[m[1]:]
+		}
+	}
+
+	name = reDotted.ReplaceAllString(name, "$1$2$3$4$5")
+	tokens := reSep.Split(name, -1)
+
+	// 末尾的 -组名，例如 HEVC-GROUP
+	if info.Group == "" && len(tokens) > 0 {
+		last := tokens[len(tokens)-1]
+		if m := reTailGroup.FindStringSubmatch(last); m != nil && len(last) > len(m[0]) && !isTag(last) {
+			info.Group = truncate(m[1], maxGroupLength)
+			tokens[len(tokens)-1] = last[:len(last)-len(m[0])]
+		}
+	}
+
+	var title []string
+	titleDone := false
+	for i, tok := range tokens {
+		if tok == "" || tok == "-" {
+			continue
+		}
+		if info.parseToken(tok, i > 0 && len(title) > 0) {
+			titleDone = true
+			continue
+		}
+		if !titleDone {
+			title = append(title, tok)
+			continue
+		}
+		// 语言词可能是标题的一部分，只在标题之后识别
+		if v, ok := languages[strings.ToUpper(tok)]; ok {
+			info.addLanguage(v)
+		}
+	}
+
+	info.Title = strings.Join(title, " ")
+	return info
+}
+
+// 识别单个词元，返回是否为属性词
+func (info *Info) parseToken(tok string, allowYear bool) bool {
+	upper := strings.ToUpper(tok)
+
+	if allowYear && info.Year == 0 && reYear.MatchString(tok) {
+		info.Year, _ = strconv.Atoi(tok)
+		return true
+	}
+	if m := reSeasonEp.FindStringSubmatch(tok); m != nil {
+		info.Season, _ = strconv.Atoi(m[1])
+		info.Episode, _ = strconv.Atoi(m[2])
+		return true
+	}
+	if m := reSeason.FindStringSubmatch(tok); m != nil {
+		info.Season, _ = strconv.Atoi(m[1] + m[2])
+		return true
+	}
+	if m := reEpisode.FindStringSubmatch(tok); m != nil && info.Season > 0 {
+		info.Episode, _ = strconv.Atoi(m[1])
+		return true
+	}
+	if m := reCross.FindStringSubmatch(tok); m != nil {
+		info.Season, _ = strconv.Atoi(m[1])
+		info.Episode, _ = strconv.Atoi(m[2])
+		return true
+	}
+	// 隔行扫描保留 i，例如 1080i；WxH 按逐行
+	if m := reResolution.FindStringSubmatch(tok); m != nil {
+		if info.Resolution == "" {
+			if m[1] != "" {
+				info.Resolution = m[1] + strings.ToLower(m[2])
+			} else {
+				info.Resolution = m[3] + "p"
+			}
+		}
+		return true
+	}
+	if v, ok := resolutions[upper]; ok {
+		if info.Resolution == "" {
+			info.Resolution = v
+		}
+		return true
+	}
+	if v, ok := videoCodecs[upper]; ok {
+		if info.VideoCodec == "" {
+			info.VideoCodec = v
+		}
+		return true
+	}
+	if v, ok := audioCodecs[stripChannels(upper)]; ok {
+		if info.AudioCodec == "" {
+			info.AudioCodec = v
+		}
+		return true
+	}
+	if v, ok := sources[upper]; ok {
+		if info.Source == "" {
+			info.Source = v
+		}
+		return true
+	}
+	return false
+}
+
+// 带连字符的已知标签，例如 WEB-DL、DTS-HD
+func isTag(tok string) bool {
+	upper := strings.ToUpper(tok)
+	_, source := sources[upper]
+	_, audio := audioCodecs[upper]
+	return source || audio
+}
+
+// 解析阿拉伯数字或十以内的中文数字，例如 2、二、十二
+func cjkNumber(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+
+	digits := map[rune]int{'一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+	n, cur := 0, 0
+	for _, r := range s {
+		if r == '十' {
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+			continue
+		}
+		cur = digits[r]
+	}
+	return n + cur
+}
+
+// 截断为最多 n 个字符
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// 去掉声道数后缀，例如 DDP51 -> DDP
+func stripChannels(s string) string {
+	return strings.TrimRight(s, "0123456789")
+}
+
+func (info *Info) addLanguage(lang string) {
+	for _, l := range info.Languages {
+		if l == lang {
+			return
+		}
+	}
+	info.Languages = append(info.Languages, lang)
+}
@@ -0,0 +1,119 @@
+package release
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Info
+	}{
+		{"Show.Name.S02E05.1080p.WEB-DL.x265.HEVC-GROUP.mkv", Info{
+			Title: "Show Name", Season: 2, Episode: 5, Resolution: "1080p", VideoCodec: "H.265", Source: "WEB", Group: "GROUP",
+		}},
+		{"The.Matrix.1999.2160p.UHD.BluRay.REMUX.HDR.HEVC.TrueHD.7.1.Atmos-FGT", Info{
+			Title: "The Matrix", Year: 1999, Resolution: "2160p", VideoCodec: "H.265", AudioCodec: "TrueHD", Source: "BluRay", Group: "FGT",
+		}},
+		// 隔行扫描不能当作逐行
+		{"Movie.Name.2019.1080i.HDTV.H.264.DD5.1-GRP", Info{
+			Title: "Movie Name", Year: 2019, Resolution: "1080i", VideoCodec: "H.264", AudioCodec: "AC3", Source: "HDTV", Group: "GRP",
+		}},
+		{"Show.S01.Complete.BluRay.1920x1080.x264-GROUP", Info{
+			Title: "Show", Season: 1, Resolution: "1080p", VideoCodec: "H.264", Source: "BluRay", Group: "GROUP",
+		}},
+		{"Movie.4K.WEBRIP.AAC", Info{Title: "Movie", Resolution: "2160p", AudioCodec: "AAC", Source: "WEB"}},
+		// 标题本身是年份时取后一个年份
+		{"2012.2009.720p.BluRay.x264", Info{Title: "2012", Year: 2009, Resolution: "720p", VideoCodec: "H.264", Source: "BluRay"}},
+		{"Show 3x07 720p", Info{Title: "Show", Season: 3, Episode: 7, Resolution: "720p"}},
+		// 多集只取第一集；WEB-DL、DTS-HD 等带连字符的标签不是组名
+		{"Some.Show.S01E02E03.FRENCH.720p.WEB.DTS-HD.MA.5.1-TEAM", Info{
+			Title: "Some Show", Season: 1, Episode: 2, Resolution: "720p", AudioCodec: "DTS-HD", Source: "WEB", Group: "TEAM", Languages: []string{"fr"},
+		}},
+		{"Movie.2020.720p.WEB-DL", Info{Title: "Movie", Year: 2020, Resolution: "720p", Source: "WEB"}},
+		// 动漫风格
+		{"[Sakura] Anime Title - 05 [720p][CHS].mp4", Info{
+			Title: "Anime Title", Episode: 5, Resolution: "720p", Group: "Sakura", Languages: []string{"zh"},
+		}},
+		{"【字幕组】进击的巨人 第二季 第05集 1080p 中文", Info{
+			Title: "进击的巨人", Season: 2, Episode: 5, Resolution: "1080p", Group: "字幕组", Languages: []string{"zh"},
+		}},
+		{"动画 第十二季 第3话", Info{Title: "动画", Season: 12, Episode: 3}},
+		{"plain file name", Info{Title: "plain file name"}},
+		{"", Info{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+// 发布组截断到 release_group 列宽，不能让整条记录写入失败
+func TestParseLongGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"leading", "[" + strings.Repeat("组", 300) + "] Title 1080p"},
+		{"trailing", "Title.1080p.x264-" + strings.Repeat("G", 300)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := Parse(tt.text)
+			if n := utf8.RuneCountInString(info.Group); n != maxGroupLength {
+				t.Errorf("group has %d characters, want %d", n, maxGroupLength)
+			}
+			if !utf8.ValidString(info.Group) {
+				t.Errorf("group is not valid UTF-8")
+			}
+			if info.Resolution != "1080p" {
+				t.Errorf("Resolution = %q, want 1080p", info.Resolution)
+			}
+		})
+	}
+}
+
+func TestCategory(t *testing.T) {
+	tests := []struct {
+		name    string
+		paths   []string
+		lengths []int
+		want    string
+	}{
+		{"Movie.2019.1080p.BluRay.x264.mkv", nil, nil, CategoryMovie},
+		{"Show.S01E01.720p.mkv", nil, nil, CategoryTV},
+		{"Show.S01.1080p", []string{"e01.mkv", "e02.mkv", "sample.jpg"}, []int{1000, 1000, 10}, CategoryTV},
+		{"Album", []string{"01.flac", "cover.jpg"}, []int{500, 5}, CategoryAudio},
+		{"Book.epub", nil, nil, CategoryEbook},
+		{"ubuntu-24.04-desktop-amd64.iso", nil, nil, CategorySoftware},
+		{"Backup", []string{"a.zip", "b.txt"}, []int{1000, 1}, CategoryArchive},
+		// 没有扩展名时参考名称中的视频信息
+		{"Movie.2019.1080p.WEB-DL", nil, nil, CategoryMovie},
+		{"readme", nil, nil, CategoryOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Category(tt.name, Parse(tt.name), tt.paths, tt.lengths); got != tt.want {
+				t.Errorf("Category(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCJKNumber(t *testing.T) {
+	tests := map[string]int{"2": 2, "二": 2, "十": 10, "十二": 12, "二十": 20, "二十三": 23}
+	for s, want := range tests {
+		if got := cjkNumber(s); got != want {
+			t.Errorf("cjkNumber(%q) = %d, want %d", s, got, want)
+		}
+	}
+}
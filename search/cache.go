@@ -0,0 +1,170 @@
+package search
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Cache 在后端之前缓存热门查询的结果：条目超过 ttl 后失效，超过 size 条时淘汰最久未使用的。
+// 缓存第一页搜索、首页数据、补全建议和拼写纠正；翻页和种子详情直接查询后端。
+// 返回的结果由多个请求共享，调用方不能修改
+type Cache struct {
+	Backend
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // 最近使用的在前
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+// CacheStats 缓存命中统计
+type CacheStats struct {
+	Entries int     `json:"entries"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hit_rate"` // 命中次数占查询次数的比例
+}
+
+// NewCache 返回缓存 b 的查询结果的后端，最多保存 size 条，每条保存 ttl
+func NewCache(b Backend, size int, ttl time.Duration) *Cache {
+	return &Cache{
+		Backend: b,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *Cache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.value, true
+}
+
+func (c *Cache) put(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &cacheEntry{key: key, value: value, expires: time.Now().Add(c.ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).key)
+	}
+}
+
+// 从缓存取 key 对应的结果，没有时调用 fetch 并缓存，出错的结果不缓存
+func cached[T any](c *Cache, key string, fetch func() (T, error)) (T, error) {
+	if v, ok := c.get(key); ok {
+		c.hits.Add(1)
+		return v.(T), nil
+	}
+	c.misses.Add(1)
+
+	v, err := fetch()
+	if err == nil {
+		c.put(key, v)
+	}
+	return v, err
+}
+
+// Stats 返回命中统计
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	s := CacheStats{Entries: c.lru.Len()}
+	c.mu.Unlock()
+
+	s.Hits, s.Misses = c.hits.Load(), c.misses.Load()
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+func (c *Cache) Search(ctx context.Context, q Query) (Result, error) {
+	// 翻页结果依赖游标和快照，不缓存
+	if q.After != nil || q.Before != nil || q.PIT != "" {
+		return c.Backend.Search(ctx, q)
+	}
+	key, err := json.Marshal(q)
+	if err != nil {
+		return c.Backend.Search(ctx, q)
+	}
+	return cached(c, "search:"+string(key), func() (Result, error) {
+		return c.Backend.Search(ctx, q)
+	})
+}
+
+func (c *Cache) Count(ctx context.Context) (int, error) {
+	return cached(c, "count", func() (int, error) {
+		return c.Backend.Count(ctx)
+	})
+}
+
+func (c *Cache) Latest(ctx context.Context, n int) ([]Torrent, error) {
+	return cached(c, fmt.Sprintf("latest:%d", n), func() ([]Torrent, error) {
+		return c.Backend.Latest(ctx, n)
+	})
+}
+
+func (c *Cache) Popular(ctx context.Context, n int) ([]Torrent, error) {
+	return cached(c, fmt.Sprintf("popular:%d", n), func() ([]Torrent, error) {
+		return c.Backend.Popular(ctx, n)
+	})
+}
+
+func (c *Cache) Overview(ctx context.Context, n int) (Overview, error) {
+	return cached(c, fmt.Sprintf("overview:%d", n), func() (Overview, error) {
+		return c.Backend.Overview(ctx, n)
+	})
+}
+
+func (c *Cache) Suggest(ctx context.Context, text string, n int) ([]Suggestion, error) {
+	return cached(c, fmt.Sprintf("suggest:%d:%s", n, text), func() ([]Suggestion, error) {
+		return c.Backend.Suggest(ctx, text, n)
+	})
+}
+
+func (c *Cache) Correct(ctx context.Context, terms []string) ([]string, error) {
+	key, err := json.Marshal(terms)
+	if err != nil {
+		return c.Backend.Correct(ctx, terms)
+	}
+	return cached(c, "correct:"+string(key), func() ([]string, error) {
+		return c.Backend.Correct(ctx, terms)
+	})
+}
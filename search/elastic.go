@@ -0,0 +1,694 @@
+package search
+
+import (
+	"DHT-ES-Search/querylang"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// 每条结果最多返回的命中文件数
+const matchedFilesLimit = 3
+
+type elasticBackend struct {
+	es    *elasticsearch.Client
+	index string
+	db    *sql.DB // 旧文档没有 file_list 时从 MySQL 读取文件列表，可为 nil
+}
+
+// NewElastic 返回基于 Elasticsearch 的后端，index 为读别名
+func NewElastic(es *elasticsearch.Client, index string, db *sql.DB) Backend {
+	return &elasticBackend{es: es, index: index, db: db}
+}
+
+// 不返回体积较大的文件列表
+var excludeFiles = &sourceFilter{Excludes: []string{"file_list"}}
+
+func (b *elasticBackend) search(ctx context.Context, req searchRequest) (*searchResponse, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(req); err != nil {
+		return nil, fmt.Errorf("error encoding search query: %s", err)
+	}
+
+	opts := []func(*esapi.SearchRequest){b.es.Search.WithContext(ctx), b.es.Search.WithBody(&buf)}
+	if req.PIT == nil {
+		opts = append(opts, b.es.Search.WithIndex(b.index))
+	}
+	res, err := b.es.Search(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing search: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	// 保留 sort 中 long 值的精度
+	var result searchResponse
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing search response: %s", err)
+	}
+	if result.Shards.Failed > 0 && result.Shards.Failed == result.Shards.Total {
+		return nil, &ElasticError{Status: res.StatusCode, Type: "all_shards_failed", Reason: "all shards failed"}
+	}
+	return &result, nil
+}
+
+// from/size 能访问的结果数上限，即 ES 默认的 index.max_result_window
+const maxResultWindow = 10000
+
+// PIT 的保持时间，每翻一页重新计时
+const pitKeepAlive = "5m"
+
+// 在读别名上打开 PIT，返回其 ID
+func (b *elasticBackend) openPIT(ctx context.Context) (string, error) {
+	res, err := b.es.OpenPointInTime([]string{b.index}, pitKeepAlive, b.es.OpenPointInTime.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error opening point in time: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return "", responseError(res)
+	}
+
+	var pit pointInTime
+	if err := json.NewDecoder(res.Body).Decode(&pit); err != nil {
+		return "", fmt.Errorf("error parsing point in time response: %s", err)
+	}
+	return pit.ID, nil
+}
+
+// 关闭 PIT，失败时等待其过期
+func (b *elasticBackend) closePIT(ctx context.Context, id string) error {
+	body, err := json.Marshal(pointInTime{ID: id})
+	if err != nil {
+		return err
+	}
+
+	res, err := b.es.ClosePointInTime(
+		b.es.ClosePointInTime.WithContext(ctx),
+		b.es.ClosePointInTime.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return fmt.Errorf("error closing point in time: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return responseError(res)
+	}
+	return nil
+}
+
+// 按 req 的查询和排序跳过前 n 条结果，返回最后一条的排序值，用作 search_after。
+// 每批最多 maxResultWindow 条，只取排序值；req.PIT 的 ID 随响应更新
+func (b *elasticBackend) skip(ctx context.Context, req searchRequest, n int) ([]interface{}, error) {
+	req.TrackTotalHits = false
+	req.Source = &sourceFilter{Includes: []string{"id"}}
+	req.Highlight = nil
+
+	var after []interface{}
+	for n > 0 {
+		req.Size = min(n, maxResultWindow)
+		req.SearchAfter = after
+		result, err := b.search(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		if req.PIT != nil && result.PitID != "" {
+			req.PIT.ID = result.PitID
+		}
+
+		hits := result.Hits.Hits
+		if len(hits) > 0 {
+			after = hits[len(hits)-1].Sort
+		}
+		if len(hits) < req.Size {
+			break
+		}
+		n -= len(hits)
+	}
+	return after, nil
+}
+
+// 用 _msearch 一次执行多个查询，任一查询失败时返回其错误
+func (b *elasticBackend) msearch(ctx context.Context, reqs []searchRequest) ([]*searchResponse, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, req := range reqs {
+		if err := enc.Encode(map[string]string{"index": b.index}); err != nil {
+			return nil, fmt.Errorf("error encoding search query: %s", err)
+		}
+		if err := enc.Encode(req); err != nil {
+			return nil, fmt.Errorf("error encoding search query: %s", err)
+		}
+	}
+
+	res, err := b.es.Msearch(&buf, b.es.Msearch.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error executing multi search: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, responseError(res)
+	}
+
+	var result msearchResponse
+	dec := json.NewDecoder(res.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return nil, fmt.Errorf("error parsing multi search response: %s", err)
+	}
+	if len(result.Responses) != len(reqs) {
+		return nil, fmt.Errorf("multi search returned %d responses for %d queries", len(result.Responses), len(reqs))
+	}
+
+	responses := make([]*searchResponse, len(reqs))
+	for i := range result.Responses {
+		r := &result.Responses[i]
+		if r.Error.Type != "" {
+			return nil, r.elasticError(r.Status)
+		}
+		responses[i] = &r.searchResponse
+	}
+	return responses, nil
+}
+
+// ES filter 子句
+func filterClauses(f Filter) []esQuery {
+	var res []esQuery
+
+	if f.Year > 0 {
+		res = append(res, term("year", f.Year))
+	}
+	if f.Season > 0 {
+		res = append(res, term("season", f.Season))
+	}
+	if f.Episode > 0 {
+		res = append(res, term("episode", f.Episode))
+	}
+	if f.Resolution != "" {
+		res = append(res, term("resolution", f.Resolution))
+	}
+	if f.VideoCodec != "" {
+		res = append(res, term("video_codec", f.VideoCodec))
+	}
+	if f.AudioCodec != "" {
+		res = append(res, term("audio_codec", f.AudioCodec))
+	}
+	if f.Source != "" {
+		res = append(res, term("source", f.Source))
+	}
+	if f.Group != "" {
+		res = append(res, termFold("release_group", f.Group))
+	}
+	if f.Language != "" {
+		res = append(res, term("languages", f.Language))
+	}
+	if len(f.Categories) > 0 {
+		res = append(res, terms("category", f.Categories))
+	}
+
+	if f.MinSize > 0 || f.MaxSize > 0 {
+		var r rangeQuery
+		if f.MinSize > 0 {
+			r.Gte = f.MinSize
+		}
+		if f.MaxSize > 0 {
+			r.Lte = f.MaxSize
+		}
+		res = append(res, rangeOf("length", r))
+	}
+	if r, ok := dateRangeQuery(f.AddedFrom, f.AddedTo); ok {
+		res = append(res, rangeOf("addeded", r))
+	}
+	if r, ok := dateRangeQuery(f.UpdatedFrom, f.UpdatedTo); ok {
+		res = append(res, rangeOf("updated", r))
+	}
+	switch f.Files {
+	case FilesSingle:
+		res = append(res, term("files", false))
+	case FilesMulti:
+		res = append(res, term("files", true))
+	}
+	if f.MinFiles > 0 || f.MaxFiles > 0 {
+		var r rangeQuery
+		if f.MinFiles > 0 {
+			r.Gte = f.MinFiles
+		}
+		if f.MaxFiles > 0 {
+			r.Lte = f.MaxFiles
+		}
+		res = append(res, rangeOf("file_count", r))
+	}
+
+	return res
+}
+
+// [start, end) 的日期范围，零值一端不限
+func timeRange(start, end time.Time) rangeQuery {
+	r := rangeQuery{Format: "strict_date_hour_minute_second"}
+	if !start.IsZero() {
+		r.Gte = start.Format(esDateLayout)
+	}
+	if !end.IsZero() {
+		r.Lt = end.Format(esDateLayout)
+	}
+	return r
+}
+
+func dateRangeQuery(from, to string) (rangeQuery, bool) {
+	start, end := dayRange(from, to)
+	return timeRange(start, end), !start.IsZero() || !end.IsZero()
+}
+
+// 排序方式对应的字段
+var esSortFields = map[string]string{
+	OrderRelevance: "_score",
+	OrderUpdated:   "updated",
+	OrderCnt:       "cnt",
+	OrderSize:      "length",
+	OrderName:      "name",
+	OrderAdded:     "addeded",
+	OrderFiles:     "file_count",
+}
+
+// 排序字段，再按 id 升序，保证 search_after 的位置唯一
+func sortBy(order string, asc bool) []sortField {
+	dir := "desc"
+	if asc {
+		dir = "asc"
+	}
+	return []sortField{{esSortFields[order], dir}, {"id", "asc"}}
+}
+
+// 反向排序，用于从游标位置向前取上一页
+func reverseSort(sorts []sortField) []sortField {
+	res := make([]sortField, len(sorts))
+	for i, f := range sorts {
+		f.Order = map[string]string{"asc": "desc", "desc": "asc"}[f.Order]
+		res[i] = f
+	}
+	return res
+}
+
+// ES 日期字段的范围格式
+const esDateLayout = "2006-01-02T15:04:05"
+
+// 将查询表达式编译为 bool 查询：词和短语匹配名称索引或任一文件路径，
+// 命中的文件通过 inner_hits 返回；排除项放入 must_not，字段条件放入 filter
+func exprQuery(e *querylang.Expr) boolQuery {
+	var bq boolQuery
+
+	if e.HasText() {
+		var name, path []esQuery
+		if len(e.Terms) > 0 {
+			text := strings.Join(e.Terms, " ")
+			name = append(name, match("textindex", matchQuery{
+				Query:              text,
+				Operator:           "and",
+				MinimumShouldMatch: "100%",
+				ZeroTermsQuery:     "none",
+			}))
+			path = append(path, match("file_list.path", matchQuery{Query: text, Operator: "and"}))
+		}
+		// textindex 不保留词序，短语在名称和路径上匹配
+		for _, p := range e.Phrases {
+			name = append(name, matchPhrase("name.text", phraseQuery{Query: p}))
+			path = append(path, matchPhrase("file_list.path", phraseQuery{Query: p}))
+		}
+
+		bq.Must = append(bq.Must, boolQuery{
+			Should: []esQuery{
+				boolQuery{Must: name}.query(),
+				nestedQuery{
+					Path:  "file_list",
+					Query: boolQuery{Must: path}.query(),
+					InnerHits: &innerHits{
+						Size:      matchedFilesLimit,
+						Source:    []string{"file_list.path", "file_list.length"},
+						Highlight: markFields(map[string]highlightField{"file_list.path": {}}),
+					},
+				}.query(),
+			},
+			MinimumShouldMatch: 1,
+		}.query())
+	}
+
+	for _, t := range e.ExcludeTerms {
+		bq.MustNot = append(bq.MustNot, match("textindex", matchQuery{Query: t, Operator: "and"}))
+	}
+	for _, p := range e.ExcludePhrases {
+		bq.MustNot = append(bq.MustNot,
+			matchPhrase("name.text", phraseQuery{Query: p}),
+			nestedQuery{Path: "file_list", Query: matchPhrase("file_list.path", phraseQuery{Query: p})}.query(),
+		)
+	}
+
+	if len(e.Ext) > 0 {
+		bq.Filter = append(bq.Filter, terms("extensions", e.Ext))
+	}
+	if len(e.ExcludeExt) > 0 {
+		bq.MustNot = append(bq.MustNot, terms("extensions", e.ExcludeExt))
+	}
+	if !e.Size.IsZero() {
+		bq.Filter = append(bq.Filter, rangeOf("length", intRange(e.Size)))
+	}
+	if !e.Files.IsZero() {
+		bq.Filter = append(bq.Filter, rangeOf("file_count", intRange(e.Files)))
+	}
+	if !e.Added.IsZero() {
+		bq.Filter = append(bq.Filter, rangeOf("addeded", timeRange(e.Added.From, e.Added.To)))
+	}
+	if e.Hash != "" {
+		bq.Filter = append(bq.Filter, prefix("infohash", e.Hash))
+	}
+
+	return bq
+}
+
+func intRange(r querylang.Range) rangeQuery {
+	var q rangeQuery
+	if r.Min != nil {
+		q.Gte = *r.Min
+	}
+	if r.Max != nil {
+		q.Lte = *r.Max
+	}
+	return q
+}
+
+func (b *elasticBackend) Search(ctx context.Context, q Query) (Result, error) {
+	var res Result
+
+	expr, err := querylang.Parse(q.Text)
+	if err != nil {
+		return res, err
+	}
+
+	bq := exprQuery(expr)
+
+	// 发布信息过滤，不参与评分
+	bq.Filter = append(bq.Filter, filterClauses(q.Filter)...)
+
+	order := effectiveOrder(q.Order, expr.HasText())
+	req := searchRequest{
+		Query:          bq.query(),
+		Sort:           sortBy(order, q.Asc),
+		Size:           q.Size,
+		TrackTotalHits: true,
+		Source:         excludeFiles,
+		SearchAfter:    q.After,
+	}
+	// 上一页：反向排序取游标之前的结果，再恢复顺序
+	if q.Before != nil {
+		req.Sort = reverseSort(req.Sort)
+		req.SearchAfter = q.Before
+	}
+	// 翻页期间在同一个 PIT 上查询，爬虫更新 updated、cnt 不会造成结果重复或遗漏，每页续期。
+	// 按 updated、cnt 排序且 q.Snapshot 时从第一页（或页码跳页）就打开 PIT，第二页的 search_after 与第一页在同一快照上；
+	// 其他排序第一次按游标翻页时才打开。Torznab、订阅直接查询索引。
+	// 逐批跳过的深跳页临时打开一个，用完即关闭
+	paging := q.After != nil || q.Before != nil
+	deep := !paging && q.From+q.Size > maxResultWindow
+	snapshot := !paging && q.PIT == "" && q.Snapshot && (order == OrderUpdated || order == OrderCnt)
+	keep := paging || q.PIT != "" || snapshot // 结果中返回 PIT 供之后翻页
+	opened := false
+	if q.PIT != "" {
+		req.PIT = &pointInTime{ID: q.PIT, KeepAlive: pitKeepAlive}
+	} else if paging || deep || snapshot {
+		pit, err := b.openPIT(ctx)
+		switch {
+		case err == nil:
+			req.PIT = &pointInTime{ID: pit, KeepAlive: pitKeepAlive}
+			opened = true
+		case snapshot && !deep:
+			// 打开失败（如 ES 的搜索上下文已满）时第一页照常查询，翻页时再打开
+			keep = false
+		default:
+			return res, err
+		}
+	}
+	// 出错时关闭本次打开的 PIT 和已过期的 PIT，不等其超时
+	fail := func(err error) (Result, error) {
+		err = pitError(err)
+		if req.PIT != nil && (opened || errors.Is(err, ErrPITExpired)) {
+			b.closePIT(context.WithoutCancel(ctx), req.PIT.ID)
+		}
+		return res, err
+	}
+
+	// 跳页：前 maxResultWindow 条直接用 from/size，更远的页逐批跳过
+	if deep {
+		if req.SearchAfter, err = b.skip(ctx, req, q.From); err != nil {
+			return fail(err)
+		}
+	} else if q.After == nil && q.Before == nil {
+		req.From = q.From
+	}
+	// 主查询匹配的是 textindex，名称的高亮单独指定查询
+	if expr.HasText() {
+		req.Highlight = markFields(map[string]highlightField{
+			"name.text": {HighlightQuery: match("name.text", matchQuery{Query: expr.Text()})},
+		})
+	}
+
+	result, err := b.search(ctx, req)
+	if err != nil {
+		return fail(err)
+	}
+	if req.PIT != nil && result.PitID != "" {
+		req.PIT.ID = result.PitID
+	}
+
+	hits := result.Hits.Hits
+	if q.Before != nil {
+		for i, j := 0, len(hits)-1; i < j; i, j = i+1, j-1 {
+			hits[i], hits[j] = hits[j], hits[i]
+		}
+	}
+
+	res.Total = result.Hits.Total.Value
+	for i, hit := range hits {
+		if i == 0 {
+			res.Prev = hit.Sort
+		}
+		t := hit.Source.torrent()
+		if fragments := hit.Highlight["name.text"]; len(fragments) > 0 {
+			t.Highlight = fragments[0]
+		}
+		t.MatchedFiles = matchedFiles(hit)
+		res.Torrents = append(res.Torrents, t)
+		res.Next = hit.Sort
+	}
+
+	// 本页之后没有结果时不需要快照
+	if snapshot && q.From+len(hits) >= res.Total {
+		keep = false
+	}
+	if req.PIT != nil {
+		if keep {
+			res.PIT = req.PIT.ID
+		} else {
+			b.closePIT(context.WithoutCancel(ctx), req.PIT.ID)
+		}
+	}
+	return res, nil
+}
+
+// PIT 过期或已关闭时 ES 返回 search_context_missing_exception
+func pitError(err error) error {
+	var e *ElasticError
+	if errors.As(err, &e) && e.Type == "search_context_missing_exception" {
+		return ErrPITExpired
+	}
+	return err
+}
+
+func (b *elasticBackend) Count(ctx context.Context) (int, error) {
+	result, err := b.search(ctx, searchRequest{
+		TrackTotalHits: true,
+		Size:           0, // 只获取总数，不需要具体文档
+	})
+	if err != nil {
+		return 0, err
+	}
+	return result.Hits.Total.Value, nil
+}
+
+func (b *elasticBackend) list(ctx context.Context, order string, n int) ([]Torrent, error) {
+	result, err := b.search(ctx, searchRequest{
+		Sort:   sortBy(order, false),
+		Size:   n,
+		Source: excludeFiles,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return hitTorrents(result.Hits.Hits), nil
+}
+
+func hitTorrents(hits []searchHit) []Torrent {
+	torrents := make([]Torrent, 0, len(hits))
+	for _, hit := range hits {
+		torrents = append(torrents, hit.Source.torrent())
+	}
+	return torrents
+}
+
+func (b *elasticBackend) Latest(ctx context.Context, n int) ([]Torrent, error) {
+	return b.list(ctx, OrderUpdated, n)
+}
+
+func (b *elasticBackend) Popular(ctx context.Context, n int) ([]Torrent, error) {
+	return b.list(ctx, OrderCnt, n)
+}
+
+// 首页的总数、最新和最热种子在一次 _msearch 中取回
+func (b *elasticBackend) Overview(ctx context.Context, n int) (Overview, error) {
+	var res Overview
+	responses, err := b.msearch(ctx, []searchRequest{
+		{TrackTotalHits: true, Size: 0},
+		{Sort: sortBy(OrderUpdated, false), Size: n, Source: excludeFiles},
+		{Sort: sortBy(OrderCnt, false), Size: n, Source: excludeFiles},
+	})
+	if err != nil {
+		return res, err
+	}
+
+	res.Count = responses[0].Hits.Total.Value
+	res.Latest = hitTorrents(responses[1].Hits.Hits)
+	res.Popular = hitTorrents(responses[2].Hits.Hits)
+	return res, nil
+}
+
+func (b *elasticBackend) Get(ctx context.Context, id int64) (*Torrent, error) {
+	result, err := b.search(ctx, searchRequest{
+		Query: term("id", id),
+		Size:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Hits.Hits) == 0 {
+		return nil, ErrNotFound
+	}
+
+	source := result.Hits.Hits[0].Source
+	t := source.torrent()
+
+	// 文件列表以 nested 对象存放；旧数据没有 file_list 时再查询 MySQL
+	if source.FileList != nil {
+		t.Files = fileList(source.FileList)
+	} else if t.HasFiles && b.db != nil {
+		if t.Files, err = queryFiles(ctx, b.db, t.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+func (b *elasticBackend) Suggest(ctx context.Context, text string, n int) ([]Suggestion, error) {
+	// bool_prefix 把最后一个词作为前缀，得分再乘以 log10(2+cnt)，热门种子靠前
+	query := functionScoreQuery{
+		Query: multiMatchQuery{
+			Query:    text,
+			Type:     "bool_prefix",
+			Fields:   []string{"name.suggest", "name.suggest._2gram", "name.suggest._3gram"},
+			Operator: "and",
+		}.query(),
+		FieldValueFactor: &fieldValueFactor{Field: "cnt", Modifier: "log2p", Missing: 0},
+		BoostMode:        "multiply",
+	}
+
+	result, err := b.search(ctx, searchRequest{
+		Query:    query.query(),
+		Size:     n,
+		Source:   &sourceFilter{Includes: []string{"name", "cnt"}},
+		Collapse: &collapse{Field: "name"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]Suggestion, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		list = appendSuggestion(list, Suggestion{Text: hit.Source.Name, Cnt: hit.Source.Cnt}, n)
+	}
+	return list, nil
+}
+
+func (b *elasticBackend) Correct(ctx context.Context, terms []string) ([]string, error) {
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// 每个词单独一个 suggester，建议的偏移量只相对于该词
+	suggest := make(map[string]suggester, len(terms))
+	for i, t := range terms {
+		suggest[strconv.Itoa(i)] = suggester{
+			Text: t,
+			Term: &termSuggest{Field: "textindex", SuggestMode: "missing", Size: 1},
+		}
+	}
+
+	result, err := b.search(ctx, searchRequest{Size: 0, Suggest: suggest})
+	if err != nil {
+		return nil, err
+	}
+
+	corrected := make([]string, len(terms))
+	changed := false
+	for i, t := range terms {
+		units := utf16.Encode([]rune(t))
+		entries := result.Suggest[strconv.Itoa(i)]
+		// 从后往前替换，前面的偏移量不受影响
+		for j := len(entries) - 1; j >= 0; j-- {
+			e := entries[j]
+			if len(e.Options) == 0 || e.Offset < 0 || e.Offset+e.Length > len(units) {
+				continue
+			}
+			tail := units[e.Offset+e.Length:]
+			units = append(append(units[:e.Offset:e.Offset], utf16.Encode([]rune(e.Options[0].Text))...), tail...)
+			changed = true
+		}
+		corrected[i] = string(utf16.Decode(units))
+	}
+	if !changed {
+		return nil, nil
+	}
+	return corrected, nil
+}
+
+// 提取 inner_hits 中命中的文件
+func matchedFiles(hit searchHit) []File {
+	inner, ok := hit.InnerHits["file_list"]
+	if !ok || len(inner.Hits.Hits) == 0 {
+		return nil
+	}
+
+	files := make([]File, 0, len(inner.Hits.Hits))
+	for _, h := range inner.Hits.Hits {
+		f := File{Path: h.Source.Path, Length: h.Source.Length}
+		if fragments := h.Highlight["file_list.path"]; len(fragments) > 0 {
+			f.Highlight = fragments[0]
+		}
+		files = append(files, f)
+	}
+	return files
+}
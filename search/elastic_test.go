@@ -0,0 +1,221 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 假的 ES，记录 PIT 的打开和关闭，按 from/size 返回 total 条结果
+type fakeES struct {
+	total    int
+	expired  bool // 带 PIT 的查询返回 search_context_missing_exception
+	openFail bool // 打开 PIT 失败
+
+	mu       sync.Mutex
+	opened   []string
+	closed   []string
+	searches []map[string]interface{}
+}
+
+func (f *fakeES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/_pit"):
+		if f.openFail {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"type":"too_many_pit","reason":"too many"},"status":429}`)
+			return
+		}
+		id := fmt.Sprintf("pit-%d", len(f.opened)+1)
+		f.opened = append(f.opened, id)
+		fmt.Fprintf(w, `{"id":%q}`, id)
+
+	case r.Method == http.MethodDelete && r.URL.Path == "/_pit":
+		var pit pointInTime
+		json.NewDecoder(r.Body).Decode(&pit)
+		f.closed = append(f.closed, pit.ID)
+		fmt.Fprint(w, `{"succeeded":true,"num_freed":1}`)
+
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.searches = append(f.searches, body)
+
+		pit, _ := body["pit"].(map[string]interface{})
+		if pit != nil && f.expired {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"type":"search_context_missing_exception","reason":"No search context found"},"status":404}`)
+			return
+		}
+
+		from, _ := body["from"].(float64)
+		size, _ := body["size"].(float64)
+		var hits []string
+		for i := int(from); i < min(int(from+size), f.total); i++ {
+			hits = append(hits, fmt.Sprintf(`{"_id":"%d","_source":{"id":%d,"name":"t%d"},"sort":[%d,%d]}`, i, i, i, f.total-i, i))
+		}
+		resp := fmt.Sprintf(`{"_shards":{"total":1},"hits":{"total":{"value":%d},"hits":[%s]}}`, f.total, strings.Join(hits, ","))
+		if pit != nil {
+			resp = strings.TrimSuffix(resp, "}") + fmt.Sprintf(`,"pit_id":%q}`, pit["id"])
+		}
+		fmt.Fprint(w, resp)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// 最后一次查询使用的 PIT ID，没有时为空
+func (f *fakeES) lastPIT() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.searches) == 0 {
+		return ""
+	}
+	pit, _ := f.searches[len(f.searches)-1]["pit"].(map[string]interface{})
+	id, _ := pit["id"].(string)
+	return id
+}
+
+func newFakeElastic(t *testing.T, f *fakeES) Backend {
+	t.Helper()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewElastic(es, "torrents", nil)
+}
+
+func TestElasticPIT(t *testing.T) {
+	after := []interface{}{json.Number("80"), json.Number("19")}
+
+	tests := []struct {
+		name       string
+		es         *fakeES
+		q          Query
+		wantErr    error
+		wantPIT    string // Result.PIT
+		wantUsed   string // 查询使用的 PIT
+		wantOpened int
+		wantClosed []string
+	}{
+		{
+			name:       "first page opens snapshot",
+			es:         &fakeES{total: 100},
+			q:          Query{Order: OrderUpdated, Size: 20, Snapshot: true},
+			wantPIT:    "pit-1",
+			wantUsed:   "pit-1",
+			wantOpened: 1,
+		},
+		{
+			name:       "first page by cnt",
+			es:         &fakeES{total: 100},
+			q:          Query{Order: OrderCnt, Size: 20, Snapshot: true},
+			wantPIT:    "pit-1",
+			wantUsed:   "pit-1",
+			wantOpened: 1,
+		},
+		{
+			name:       "page number jump opens snapshot",
+			es:         &fakeES{total: 100},
+			q:          Query{Order: OrderUpdated, Size: 20, From: 40, Snapshot: true},
+			wantPIT:    "pit-1",
+			wantUsed:   "pit-1",
+			wantOpened: 1,
+		},
+		{
+			name:       "single page closes snapshot",
+			es:         &fakeES{total: 5},
+			q:          Query{Order: OrderUpdated, Size: 20, Snapshot: true},
+			wantUsed:   "pit-1",
+			wantOpened: 1,
+			wantClosed: []string{"pit-1"},
+		},
+		{
+			name: "feed and torznab skip snapshot",
+			es:   &fakeES{total: 100},
+			q:    Query{Order: OrderUpdated, Size: 20},
+		},
+		{
+			name: "stable order skips snapshot",
+			es:   &fakeES{total: 100},
+			q:    Query{Order: OrderName, Size: 20, Snapshot: true},
+		},
+		{
+			name: "open failure still serves first page",
+			es:   &fakeES{total: 100, openFail: true},
+			q:    Query{Order: OrderUpdated, Size: 20, Snapshot: true},
+		},
+		{
+			name:     "next page reuses snapshot",
+			es:       &fakeES{total: 100},
+			q:        Query{Order: OrderUpdated, Size: 20, After: after, PIT: "pit-9", Snapshot: true},
+			wantPIT:  "pit-9",
+			wantUsed: "pit-9",
+		},
+		{
+			name:       "cursor without snapshot opens one",
+			es:         &fakeES{total: 100},
+			q:          Query{Order: OrderName, Size: 20, After: after},
+			wantPIT:    "pit-1",
+			wantUsed:   "pit-1",
+			wantOpened: 1,
+		},
+		{
+			name:       "expired snapshot is closed",
+			es:         &fakeES{total: 100, expired: true},
+			q:          Query{Order: OrderUpdated, Size: 20, After: after, PIT: "pit-9"},
+			wantErr:    ErrPITExpired,
+			wantClosed: []string{"pit-9"},
+		},
+		{
+			name:       "deep jump closes its snapshot",
+			es:         &fakeES{total: 30000},
+			q:          Query{Order: OrderAdded, Size: 20, From: 20000},
+			wantUsed:   "pit-1",
+			wantOpened: 1,
+			wantClosed: []string{"pit-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.es
+			res, err := newFakeElastic(t, f).Search(context.Background(), tt.q)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Search error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(res.Torrents) == 0 {
+				t.Error("no results")
+			}
+			if res.PIT != tt.wantPIT {
+				t.Errorf("Result.PIT = %q, want %q", res.PIT, tt.wantPIT)
+			}
+			if used := f.lastPIT(); used != tt.wantUsed && tt.wantErr == nil {
+				t.Errorf("search used PIT %q, want %q", used, tt.wantUsed)
+			}
+			if len(f.opened) != tt.wantOpened {
+				t.Errorf("opened %d PITs, want %d", len(f.opened), tt.wantOpened)
+			}
+			if strings.Join(f.closed, ",") != strings.Join(tt.wantClosed, ",") {
+				t.Errorf("closed %q, want %q", f.closed, tt.wantClosed)
+			}
+		})
+	}
+}
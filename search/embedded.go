@@ -0,0 +1,532 @@
+package search
+
+import (
+	"DHT-ES-Search/embedded"
+	"DHT-ES-Search/esindex"
+	"DHT-ES-Search/querylang"
+	"DHT-ES-Search/tokenizer"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+type embeddedBackend struct {
+	ix  *embedded.Index
+	tok *tokenizer.Tokenizer
+}
+
+// NewEmbedded 返回基于嵌入式索引的后端，无需 MySQL 和 Elasticsearch。
+// tok 应与爬虫生成 textindex 时的配置一致
+func NewEmbedded(ix *embedded.Index, tok *tokenizer.Tokenizer) Backend {
+	return &embeddedBackend{ix: ix, tok: tok}
+}
+
+func (b *embeddedBackend) terms(text string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range b.tok.Tokenize(text) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+func matchFilter(d *embedded.Doc, f Filter) bool {
+	if f.Year > 0 && d.Year != f.Year {
+		return false
+	}
+	if f.Season > 0 && d.Season != f.Season {
+		return false
+	}
+	if f.Episode > 0 && d.Episode != f.Episode {
+		return false
+	}
+	if f.Resolution != "" && d.Resolution != f.Resolution {
+		return false
+	}
+	if f.VideoCodec != "" && d.VideoCodec != f.VideoCodec {
+		return false
+	}
+	if f.AudioCodec != "" && d.AudioCodec != f.AudioCodec {
+		return false
+	}
+	if f.Source != "" && d.Source != f.Source {
+		return false
+	}
+	if f.Group != "" && !strings.EqualFold(d.ReleaseGroup, f.Group) {
+		return false
+	}
+	if f.Language != "" {
+		found := false
+		for _, lang := range d.Languages {
+			if lang == f.Language {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Categories) > 0 && !slices.Contains(f.Categories, d.Category) {
+		return false
+	}
+
+	if f.MinSize > 0 && d.Length < f.MinSize || f.MaxSize > 0 && d.Length > f.MaxSize {
+		return false
+	}
+	if !inDays(d.Addeded, f.AddedFrom, f.AddedTo) || !inDays(d.Updated, f.UpdatedFrom, f.UpdatedTo) {
+		return false
+	}
+	if f.Files == FilesSingle && len(d.Files) > 0 || f.Files == FilesMulti && len(d.Files) == 0 {
+		return false
+	}
+	n := docFileCount(d)
+	if f.MinFiles > 0 && n < int64(f.MinFiles) || f.MaxFiles > 0 && n > int64(f.MaxFiles) {
+		return false
+	}
+	return true
+}
+
+// 记录中的时间是否在日期区间内，时间格式可按字符串比较
+func inDays(t, from, to string) bool {
+	start, end := dayRange(from, to)
+	if !start.IsZero() && t < start.Format(embedded.TimeLayout) {
+		return false
+	}
+	if !end.IsZero() && t >= end.Format(embedded.TimeLayout) {
+		return false
+	}
+	return true
+}
+
+// 名称或任一文件路径包含短语
+func docContainsPhrase(d *embedded.Doc, phrase string) bool {
+	if querylang.ContainsPhrase(d.Name, phrase) {
+		return true
+	}
+	for _, f := range d.Files {
+		if querylang.ContainsPhrase(f.Path, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+func docFileCount(d *embedded.Doc) int64 {
+	if len(d.Files) == 0 {
+		return 1
+	}
+	return int64(len(d.Files))
+}
+
+func docHasExt(d *embedded.Doc, exts []string) bool {
+	paths := make([]string, len(d.Files))
+	for i, f := range d.Files {
+		paths[i] = f.Path
+	}
+	for _, have := range esindex.Extensions(d.Name, paths) {
+		for _, want := range exts {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 检查查询表达式中除普通词以外的条件，普通词已由倒排索引匹配
+func (b *embeddedBackend) matchExpr(d *embedded.Doc, e *querylang.Expr) bool {
+	for _, p := range e.Phrases {
+		if !docContainsPhrase(d, p) {
+			return false
+		}
+	}
+	for _, p := range e.ExcludePhrases {
+		if docContainsPhrase(d, p) {
+			return false
+		}
+	}
+	if len(e.ExcludeTerms) > 0 {
+		indexed := make(map[string]bool)
+		for _, t := range strings.Fields(d.TextIndex) {
+			indexed[t] = true
+		}
+		for _, t := range e.ExcludeTerms {
+			terms := b.terms(t)
+			all := len(terms) > 0
+			for _, term := range terms {
+				all = all && indexed[term]
+			}
+			if all {
+				return false
+			}
+		}
+	}
+
+	if len(e.Ext) > 0 && !docHasExt(d, e.Ext) {
+		return false
+	}
+	if len(e.ExcludeExt) > 0 && docHasExt(d, e.ExcludeExt) {
+		return false
+	}
+	if !e.Size.Contains(d.Length) || !e.Files.Contains(docFileCount(d)) {
+		return false
+	}
+	if !e.Added.From.IsZero() && d.Addeded < e.Added.From.Format(embedded.TimeLayout) {
+		return false
+	}
+	if !e.Added.To.IsZero() && d.Addeded >= e.Added.To.Format(embedded.TimeLayout) {
+		return false
+	}
+	if e.Hash != "" && !strings.HasPrefix(d.InfoHash, e.Hash) {
+		return false
+	}
+	return true
+}
+
+// docSorter 与 ES、MySQL 相同的排序：按排序值，相同时按 id 升序
+type docSorter struct {
+	order  string
+	asc    bool
+	scores map[int64]float64 // 相关度，仅 OrderRelevance 使用
+}
+
+// 排序值：数值为 int64，相关度为 float64，其余为字符串
+func (s docSorter) key(d *embedded.Doc) interface{} {
+	switch s.order {
+	case OrderRelevance:
+		return s.scores[d.ID]
+	case OrderCnt:
+		return int64(d.Cnt)
+	case OrderSize:
+		return d.Length
+	case OrderFiles:
+		return docFileCount(d)
+	case OrderName:
+		return d.Name
+	case OrderAdded:
+		return d.Addeded
+	}
+	return d.Updated
+}
+
+// 比较同一排序方式下的两个排序值
+func compareKeys(a, b interface{}) int {
+	switch x := a.(type) {
+	case int64:
+		y := b.(int64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	case float64:
+		y := b.(float64)
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	case string:
+		return strings.Compare(x, b.(string))
+	}
+	return 0
+}
+
+// 排序值为 key、id 为 id 的记录之后是否应为 d
+func (s docSorter) follows(d *embedded.Doc, key interface{}, id int64) bool {
+	if c := compareKeys(s.key(d), key); c != 0 {
+		return c > 0 == s.asc
+	}
+	return d.ID > id
+}
+
+func (s docSorter) sort(docs []*embedded.Doc) {
+	sort.Slice(docs, func(i, j int) bool { return s.follows(docs[j], s.key(docs[i]), docs[i].ID) })
+}
+
+// 解析游标中的排序值和 id
+func (s docSorter) cursor(c []interface{}) (interface{}, int64, error) {
+	if len(c) != 2 {
+		return nil, 0, ErrInvalidCursor
+	}
+	id, ok := cursorInt(c[1])
+	if !ok {
+		return nil, 0, ErrInvalidCursor
+	}
+
+	var key interface{}
+	switch s.key(&embedded.Doc{}).(type) {
+	case int64:
+		key, ok = cursorInt(c[0])
+	case float64:
+		key, ok = cursorFloat(c[0])
+	default:
+		key, ok = c[0].(string)
+	}
+	if !ok {
+		return nil, 0, ErrInvalidCursor
+	}
+	return key, id, nil
+}
+
+// 游标位置之后的第一条
+func (s docSorter) after(docs []*embedded.Doc, c []interface{}) (int, error) {
+	key, id, err := s.cursor(c)
+	if err != nil {
+		return 0, err
+	}
+	return sort.Search(len(docs), func(i int) bool { return s.follows(docs[i], key, id) }), nil
+}
+
+// 游标位置之前的结果为 docs[:i]，返回 i
+func (s docSorter) before(docs []*embedded.Doc, c []interface{}) (int, error) {
+	key, id, err := s.cursor(c)
+	if err != nil {
+		return 0, err
+	}
+	return sort.Search(len(docs), func(i int) bool { return s.follows(docs[i], key, id-1) }), nil
+}
+
+// 相关度：名称中出现的查询词越多、名称越短越靠前。只出现在文件路径中的词不计分
+func (b *embeddedBackend) scores(docs []*embedded.Doc, m *textMatcher) map[int64]float64 {
+	scores := make(map[int64]float64, len(docs))
+	for _, d := range docs {
+		tokens := b.tok.Tokenize(d.Name)
+		hits := 0
+		seen := make(map[string]bool)
+		for _, t := range tokens {
+			if m.tokens[t] && !seen[t] {
+				seen[t] = true
+				hits++
+			}
+		}
+		scores[d.ID] = 10*float64(hits)/float64(len(m.tokens)) + 1/float64(1+len(tokens))
+	}
+	return scores
+}
+
+func docTorrent(d *embedded.Doc) Torrent {
+	return Torrent{
+		ID:         d.ID,
+		InfoHash:   d.InfoHash,
+		Name:       d.Name,
+		Length:     d.Length,
+		HasFiles:   len(d.Files) > 0,
+		FileCount:  int(docFileCount(d)),
+		Addeded:    d.Addeded,
+		Updated:    d.Updated,
+		Cnt:        d.Cnt,
+		Category:   d.Category,
+		Resolution: d.Resolution,
+	}
+}
+
+// 路径包含全部搜索词的文件
+func matchedDocFiles(d *embedded.Doc, m *textMatcher) []File {
+	var files []File
+	for _, f := range d.Files {
+		if file, ok := m.file(f.Path, f.Length); ok {
+			files = append(files, file)
+			if len(files) == matchedFilesLimit {
+				break
+			}
+		}
+	}
+	return files
+}
+
+func (b *embeddedBackend) Search(ctx context.Context, q Query) (Result, error) {
+	var res Result
+
+	expr, err := querylang.Parse(q.Text)
+	if err != nil {
+		return res, err
+	}
+
+	// 短语的词也参与倒排索引匹配，再逐条检查词序
+	var terms []string
+	var docs []*embedded.Doc
+	if expr.HasText() {
+		// 分词后没有可搜索的词时返回空结果
+		if terms = b.terms(expr.Text()); len(terms) == 0 {
+			return res, nil
+		}
+		docs = b.ix.Match(terms)
+	} else {
+		docs = b.ix.All()
+	}
+
+	filtered := docs[:0]
+	for _, d := range docs {
+		if matchFilter(d, q.Filter) && b.matchExpr(d, expr) {
+			filtered = append(filtered, d)
+		}
+	}
+	docs = filtered
+	res.Total = len(docs)
+
+	var m *textMatcher
+	if expr.HasText() {
+		m = newTextMatcher(b.tok, expr.Text())
+	}
+
+	sorter := docSorter{order: effectiveOrder(q.Order, expr.HasText()), asc: q.Asc}
+	if sorter.order == OrderRelevance {
+		sorter.scores = b.scores(docs, m)
+	}
+	sorter.sort(docs)
+
+	start, end := 0, q.Size
+	switch {
+	case q.After != nil:
+		if start, err = sorter.after(docs, q.After); err != nil {
+			return res, err
+		}
+		end = start + q.Size
+	case q.Before != nil:
+		// 上一页：游标之前的 Size 条
+		if end, err = sorter.before(docs, q.Before); err != nil {
+			return res, err
+		}
+		start = end - q.Size
+		if start < 0 {
+			start = 0
+		}
+	case q.From > 0:
+		start, end = q.From, q.From+q.Size
+	}
+	if end > len(docs) {
+		end = len(docs)
+	}
+	if start > end {
+		start = end
+	}
+
+	for _, d := range docs[start:end] {
+		t := docTorrent(d)
+		if m != nil {
+			t.Highlight = m.highlight(d.Name)
+			t.MatchedFiles = matchedDocFiles(d, m)
+		}
+		res.Torrents = append(res.Torrents, t)
+		if res.Prev == nil {
+			res.Prev = []interface{}{sorter.key(d), d.ID}
+		}
+		res.Next = []interface{}{sorter.key(d), d.ID}
+	}
+	return res, nil
+}
+
+// 前缀最多展开的词数
+const suggestPrefixTerms = 20
+
+func (b *embeddedBackend) Suggest(ctx context.Context, text string, n int) ([]Suggestion, error) {
+	terms := b.terms(text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	// 最后一个词可能未输完，展开为索引中以它开头的词，输入以空格结尾时按完整词匹配
+	var docs []*embedded.Doc
+	if last := terms[len(terms)-1]; !strings.HasSuffix(text, " ") {
+		seen := make(map[int64]bool)
+		for _, term := range b.ix.Terms(last, suggestPrefixTerms) {
+			for _, d := range b.ix.Match(append(terms[:len(terms)-1:len(terms)-1], term)) {
+				if !seen[d.ID] {
+					seen[d.ID] = true
+					docs = append(docs, d)
+				}
+			}
+		}
+	} else {
+		docs = b.ix.Match(terms)
+	}
+
+	docSorter{order: OrderCnt}.sort(docs)
+	var list []Suggestion
+	for _, d := range docs {
+		if list = appendSuggestion(list, Suggestion{Text: d.Name, Cnt: d.Cnt}, n); len(list) == n {
+			break
+		}
+	}
+	return list, nil
+}
+
+// 短于这么多字符的词不纠正，与 ES term suggester 的 min_word_length 相同
+const correctMinLength = 4
+
+// 只纠正分词后为单个词、且索引中不存在的词
+func (b *embeddedBackend) Correct(ctx context.Context, terms []string) ([]string, error) {
+	corrected := make([]string, len(terms))
+	changed := false
+	for i, t := range terms {
+		corrected[i] = t
+		tokens := b.tok.Tokenize(t)
+		if len(tokens) != 1 || utf8.RuneCountInString(tokens[0]) < correctMinLength || b.ix.DocFreq(tokens[0]) > 0 {
+			continue
+		}
+		maxEdits := 2
+		if utf8.RuneCountInString(tokens[0]) <= 5 {
+			maxEdits = 1
+		}
+		if s, ok := b.ix.Similar(tokens[0], maxEdits); ok {
+			corrected[i] = s
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, nil
+	}
+	return corrected, nil
+}
+
+func (b *embeddedBackend) Count(ctx context.Context) (int, error) {
+	return b.ix.Len(), nil
+}
+
+func (b *embeddedBackend) list(order string, n int) []Torrent {
+	docs := b.ix.All()
+	docSorter{order: order}.sort(docs)
+	if len(docs) > n {
+		docs = docs[:n]
+	}
+
+	torrents := make([]Torrent, 0, len(docs))
+	for _, d := range docs {
+		torrents = append(torrents, docTorrent(d))
+	}
+	return torrents
+}
+
+func (b *embeddedBackend) Latest(ctx context.Context, n int) ([]Torrent, error) {
+	return b.list(OrderUpdated, n), nil
+}
+
+func (b *embeddedBackend) Popular(ctx context.Context, n int) ([]Torrent, error) {
+	return b.list(OrderCnt, n), nil
+}
+
+func (b *embeddedBackend) Overview(ctx context.Context, n int) (Overview, error) {
+	return overview(ctx, b, n)
+}
+
+func (b *embeddedBackend) Get(ctx context.Context, id int64) (*Torrent, error) {
+	d, ok := b.ix.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	t := docTorrent(d)
+	t.Files = make([]File, 0, len(d.Files))
+	for _, f := range d.Files {
+		t.Files = append(t.Files, File{Path: f.Path, Length: f.Length})
+	}
+	return &t, nil
+}
@@ -0,0 +1,257 @@
+package search
+
+import (
+	"DHT-ES-Search/crawler"
+	"DHT-ES-Search/embedded"
+	"DHT-ES-Search/tokenizer"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	_ "github.com/go-sql-driver/mysql"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 对比测试使用的种子，按入库顺序，id 从 1 开始
+type fixture struct {
+	name    string
+	length  int
+	files   []crawler.File
+	touches int // 再次收到的次数
+}
+
+var fixtures = []fixture{
+	{name: "ubuntu-24.04-desktop-amd64.iso", length: 6 << 30, touches: 3},
+	{name: "The.Matrix.1999.1080p.BluRay.x264-GRP", touches: 1, files: []crawler.File{
+		{Path: []interface{}{"The.Matrix.1999.1080p.BluRay.x264-GRP.mkv"}, Length: 8 << 30},
+		{Path: []interface{}{"Sample", "sample.mkv"}, Length: 50 << 20},
+	}},
+	{name: "The.Matrix.Reloaded.2003.720p.CAM.mp4", length: 700 << 20},
+	{name: "Artist - Album (2020) [FLAC]", files: []crawler.File{
+		{Path: []interface{}{"01 - Intro.flac"}, Length: 30 << 20},
+		{Path: []interface{}{"cover.jpg"}, Length: 1 << 20},
+	}},
+	{name: "[字幕组] 进击的巨人 第二季 1080p", files: []crawler.File{
+		{Path: []interface{}{"第01集.mkv"}, Length: 500 << 20},
+		{Path: []interface{}{"第02集.mkv"}, Length: 500 << 20},
+	}},
+	{name: "Matrix Theory Lectures.pdf", length: 5 << 20},
+}
+
+func fixtureHash(name string) string {
+	h := sha1.Sum([]byte(name))
+	return hex.EncodeToString(h[:])
+}
+
+// 按爬虫的入库流程把 fixtures 写入嵌入式索引
+func newEmbeddedFixture(t *testing.T, tok *tokenizer.Tokenizer) (Backend, *embedded.Index) {
+	t.Helper()
+	dir := t.TempDir()
+	w, err := embedded.OpenWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, f := range fixtures {
+		rec := crawler.NewRecord(&crawler.Torrent{InfoHash: fixtureHash(f.name), Name: f.name, Length: f.length, Files: f.files}, tok)
+		ri := rec.Release
+		doc := &embedded.Document{
+			InfoHash:     rec.InfoHash,
+			Name:         rec.Name,
+			Length:       int64(rec.Length),
+			TextIndex:    rec.TextIndex,
+			Title:        ri.Title,
+			Year:         ri.Year,
+			Season:       ri.Season,
+			Episode:      ri.Episode,
+			Resolution:   ri.Resolution,
+			VideoCodec:   ri.VideoCodec,
+			AudioCodec:   ri.AudioCodec,
+			Source:       ri.Source,
+			ReleaseGroup: ri.Group,
+			Languages:    ri.Languages,
+			Category:     rec.Category,
+		}
+		for _, file := range rec.Files {
+			doc.Files = append(doc.Files, embedded.File{Path: file.Path, Length: int64(file.Length)})
+		}
+		for i := 0; i <= f.touches; i++ {
+			if _, err := w.Save(doc); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	ix, err := embedded.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewEmbedded(ix, tok), ix
+}
+
+// 把嵌入式索引中的数据原样写入 DHT_TEST_MYSQL_DSN 指向的数据库，未设置时跳过。
+// 该库中的 infohash、files 表会被删除重建；全文索引须按 README 设置 innodb_ft_min_token_size = 1
+// 并关闭停用词
+func newMySQLFixture(t *testing.T, tok *tokenizer.Tokenizer, ix *embedded.Index) Backend {
+	t.Helper()
+	dsn := os.Getenv("DHT_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("DHT_TEST_MYSQL_DSN is not set")
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	schema, err := os.ReadFile("../dhtbt.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DROP TABLE IF EXISTS files, infohash"); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS .*?;`).FindAllString(string(schema), -1) {
+		if strings.Contains(stmt, "`metadata`") {
+			continue
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, d := range ix.All() {
+		_, err := db.Exec(`INSERT INTO infohash (id, infohash, name, length, files, file_count, addeded, updated, cnt, textindex,
+			title, year, season, episode, resolution, video_codec, audio_codec, source, release_group, languages, category)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			d.ID, d.InfoHash, d.Name, d.Length, len(d.Files) > 0, max(len(d.Files), 1), d.Addeded, d.Updated, d.Cnt, d.TextIndex,
+			d.Title, d.Year, d.Season, d.Episode, d.Resolution, d.VideoCodec, d.AudioCodec, d.Source, d.ReleaseGroup,
+			strings.Join(d.Languages, ","), d.Category)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, f := range d.Files {
+			if _, err := db.Exec("INSERT INTO files (infohash_id, idx, path, path_hash, length) VALUES (?, ?, ?, ?, ?)",
+				d.ID, i, f.Path, fixtureHash(f.Path), f.Length); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return NewMySQL(db, tok)
+}
+
+func resultNames(res Result) []string {
+	names := make([]string, 0, len(res.Torrents))
+	for _, t := range res.Torrents {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// 同一组查询在嵌入式和 MySQL 后端上的结果和顺序须一致。
+// 嵌入式后端总是运行；MySQL 后端在设置 DHT_TEST_MYSQL_DSN 时运行
+func TestBackendParity(t *testing.T) {
+	var (
+		ubuntu  = fixtures[0].name
+		matrix  = fixtures[1].name
+		cam     = fixtures[2].name
+		album   = fixtures[3].name
+		anime   = fixtures[4].name
+		lecture = fixtures[5].name
+	)
+
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{"term", Query{Text: "matrix"}, []string{matrix, cam, lecture}},
+		{"phrase", Query{Text: `"the matrix"`}, []string{matrix, cam}},
+		{"phrase across separators", Query{Text: `"artist album"`}, []string{album}},
+		{"phrase in file path", Query{Text: `"01 intro"`}, []string{album}},
+		{"phrase whole words", Query{Text: `"he matrix"`}, []string{}},
+		{"exclude term", Query{Text: "matrix -cam"}, []string{matrix, lecture}},
+		{"exclude phrase", Query{Text: `matrix -"matrix reloaded"`}, []string{matrix, lecture}},
+		{"file path", Query{Text: "sample"}, []string{matrix}},
+		{"ext", Query{Text: "ext:mkv"}, []string{matrix, anime}},
+		{"ext list", Query{Text: "ext:flac,pdf"}, []string{album, lecture}},
+		{"exclude ext", Query{Text: "-ext:mkv"}, []string{ubuntu, cam, album, lecture}},
+		{"size", Query{Text: "size:>1GB"}, []string{matrix, ubuntu}},
+		{"files", Query{Text: "files:>1", Order: OrderFiles}, []string{matrix, album, anime}},
+		{"cjk", Query{Text: "进击"}, []string{anime}},
+		{"cjk and latin", Query{Text: "巨人 1080p"}, []string{anime}},
+		{"hash prefix", Query{Text: "hash:" + fixtureHash(ubuntu)[:8] + "*"}, []string{ubuntu}},
+		{"stop word only", Query{Text: "the"}, []string{}},
+		{"cnt", Query{Order: OrderCnt}, []string{ubuntu, matrix, cam, album, anime, lecture}},
+		{"resolution", Query{Filter: Filter{Resolution: "1080p"}}, []string{matrix, anime}},
+		{"category", Query{Filter: Filter{Categories: []string{"audio"}}}, []string{album}},
+		{"multi-file", Query{Filter: Filter{Files: FilesMulti}}, []string{matrix, anime, album}},
+	}
+
+	tok := tokenizer.New(nil)
+	emb, ix := newEmbeddedFixture(t, tok)
+	backends := []struct {
+		name string
+		new  func(t *testing.T) Backend
+	}{
+		{"embedded", func(t *testing.T) Backend { return emb }},
+		{"mysql", func(t *testing.T) Backend { return newMySQLFixture(t, tok, ix) }},
+	}
+
+	ctx := context.Background()
+	for _, be := range backends {
+		t.Run(be.name, func(t *testing.T) {
+			b := be.new(t)
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					q := tt.q
+					q.Size = 10
+					if q.Order == "" {
+						q.Order = OrderSize
+					}
+					res, err := b.Search(ctx, q)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if got := resultNames(res); !reflect.DeepEqual(got, tt.want) || res.Total != len(tt.want) {
+						t.Errorf("Search(%q) = %d %q, want %q", q.Text, res.Total, got, tt.want)
+					}
+				})
+			}
+
+			// 游标翻页：下一页接着上一页，上一页回到原位置
+			t.Run("cursor", func(t *testing.T) {
+				q := Query{Order: OrderSize, Size: 2}
+				page1, err := b.Search(ctx, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				q.After = page1.Next
+				page2, err := b.Search(ctx, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				q.After, q.Before = nil, page2.Prev
+				back, err := b.Search(ctx, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if got := resultNames(page1); !reflect.DeepEqual(got, []string{matrix, ubuntu}) {
+					t.Errorf("page 1 = %q", got)
+				}
+				if got := resultNames(page2); !reflect.DeepEqual(got, []string{anime, cam}) {
+					t.Errorf("page 2 = %q", got)
+				}
+				if !reflect.DeepEqual(resultNames(back), resultNames(page1)) {
+					t.Errorf("back to page 1 = %q", resultNames(back))
+				}
+			})
+		})
+	}
+}
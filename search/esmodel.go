@@ -0,0 +1,351 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"io"
+	"strings"
+)
+
+// ES 查询 DSL 与响应结构
+
+// esQuery 一个查询子句，如 {"term": {...}}
+type esQuery map[string]interface{}
+
+type boolQuery struct {
+	Must               []esQuery   `json:"must,omitempty"`
+	Should             []esQuery   `json:"should,omitempty"`
+	Filter             []esQuery   `json:"filter,omitempty"`
+	MustNot            []esQuery   `json:"must_not,omitempty"`
+	MinimumShouldMatch interface{} `json:"minimum_should_match,omitempty"`
+}
+
+func (q boolQuery) query() esQuery {
+	return esQuery{"bool": q}
+}
+
+type matchQuery struct {
+	Query              string `json:"query"`
+	Operator           string `json:"operator,omitempty"`
+	MinimumShouldMatch string `json:"minimum_should_match,omitempty"`
+	ZeroTermsQuery     string `json:"zero_terms_query,omitempty"`
+}
+
+func match(field string, q matchQuery) esQuery {
+	return esQuery{"match": map[string]matchQuery{field: q}}
+}
+
+type nestedQuery struct {
+	Path      string     `json:"path"`
+	Query     esQuery    `json:"query"`
+	InnerHits *innerHits `json:"inner_hits,omitempty"`
+}
+
+func (q nestedQuery) query() esQuery {
+	return esQuery{"nested": q}
+}
+
+type innerHits struct {
+	Size      int        `json:"size"`
+	Source    []string   `json:"_source,omitempty"`
+	Highlight *highlight `json:"highlight,omitempty"`
+}
+
+// highlight 高亮请求，number_of_fragments 为 0 时返回整个字段
+type highlight struct {
+	PreTags           []string                  `json:"pre_tags"`
+	PostTags          []string                  `json:"post_tags"`
+	NumberOfFragments int                       `json:"number_of_fragments"`
+	Fields            map[string]highlightField `json:"fields"`
+}
+
+type highlightField struct {
+	HighlightQuery esQuery `json:"highlight_query,omitempty"`
+}
+
+// 使用 MarkStart/MarkEnd 标记高亮整个字段
+func markFields(fields map[string]highlightField) *highlight {
+	return &highlight{
+		PreTags:  []string{MarkStart},
+		PostTags: []string{MarkEnd},
+		Fields:   fields,
+	}
+}
+
+type multiMatchQuery struct {
+	Query    string   `json:"query"`
+	Type     string   `json:"type,omitempty"`
+	Fields   []string `json:"fields"`
+	Operator string   `json:"operator,omitempty"`
+}
+
+func (q multiMatchQuery) query() esQuery {
+	return esQuery{"multi_match": q}
+}
+
+// functionScoreQuery 按字段值调整得分
+type functionScoreQuery struct {
+	Query            esQuery           `json:"query"`
+	FieldValueFactor *fieldValueFactor `json:"field_value_factor,omitempty"`
+	BoostMode        string            `json:"boost_mode,omitempty"`
+}
+
+type fieldValueFactor struct {
+	Field    string  `json:"field"`
+	Modifier string  `json:"modifier,omitempty"`
+	Missing  float64 `json:"missing"`
+}
+
+func (q functionScoreQuery) query() esQuery {
+	return esQuery{"function_score": q}
+}
+
+type phraseQuery struct {
+	Query    string `json:"query"`
+	Analyzer string `json:"analyzer,omitempty"`
+}
+
+func matchPhrase(field string, q phraseQuery) esQuery {
+	return esQuery{"match_phrase": map[string]phraseQuery{field: q}}
+}
+
+// rangeQuery 范围查询，未设置的边界不限
+type rangeQuery struct {
+	Gte    interface{} `json:"gte,omitempty"`
+	Lte    interface{} `json:"lte,omitempty"`
+	Lt     interface{} `json:"lt,omitempty"`
+	Format string      `json:"format,omitempty"`
+}
+
+func rangeOf(field string, q rangeQuery) esQuery {
+	return esQuery{"range": map[string]rangeQuery{field: q}}
+}
+
+func terms(field string, values []string) esQuery {
+	return esQuery{"terms": map[string][]string{field: values}}
+}
+
+func prefix(field, value string) esQuery {
+	return esQuery{"prefix": map[string]string{field: value}}
+}
+
+func term(field string, value interface{}) esQuery {
+	return esQuery{"term": map[string]interface{}{field: value}}
+}
+
+// 不区分大小写的 term 查询，用于 keyword 字段
+func termFold(field, value string) esQuery {
+	return esQuery{"term": map[string]interface{}{
+		field: map[string]interface{}{"value": value, "case_insensitive": true},
+	}}
+}
+
+// sortField 排序字段，序列化为 {"field": {"order": "desc"}}
+type sortField struct {
+	Field string
+	Order string
+}
+
+func (s sortField) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]map[string]string{s.Field: {"order": s.Order}})
+}
+
+type sourceFilter struct {
+	Includes []string `json:"includes,omitempty"`
+	Excludes []string `json:"excludes,omitempty"`
+}
+
+// collapse 按字段去重，每个值只返回得分最高的一条
+type collapse struct {
+	Field string `json:"field"`
+}
+
+// pointInTime 搜索使用的 PIT，请求中不再指定索引
+type pointInTime struct {
+	ID        string `json:"id"`
+	KeepAlive string `json:"keep_alive,omitempty"`
+}
+
+type searchRequest struct {
+	Query          esQuery              `json:"query,omitempty"`
+	Sort           []sortField          `json:"sort,omitempty"`
+	From           int                  `json:"from,omitempty"`
+	Size           int                  `json:"size"`
+	TrackTotalHits bool                 `json:"track_total_hits,omitempty"`
+	Source         *sourceFilter        `json:"_source,omitempty"`
+	SearchAfter    []interface{}        `json:"search_after,omitempty"`
+	Highlight      *highlight           `json:"highlight,omitempty"`
+	Collapse       *collapse            `json:"collapse,omitempty"`
+	Suggest        map[string]suggester `json:"suggest,omitempty"`
+	PIT            *pointInTime         `json:"pit,omitempty"`
+}
+
+// suggester 对 Text 分词后逐词给出拼写建议
+type suggester struct {
+	Text string       `json:"text"`
+	Term *termSuggest `json:"term,omitempty"`
+}
+
+type termSuggest struct {
+	Field       string `json:"field"`
+	SuggestMode string `json:"suggest_mode,omitempty"`
+	Size        int    `json:"size,omitempty"`
+}
+
+// suggestEntry 一个词的建议，Offset/Length 以 UTF-16 编码单元计
+type suggestEntry struct {
+	Text    string `json:"text"`
+	Offset  int    `json:"offset"`
+	Length  int    `json:"length"`
+	Options []struct {
+		Text  string  `json:"text"`
+		Score float64 `json:"score"`
+		Freq  int     `json:"freq"`
+	} `json:"options"`
+}
+
+type searchResponse struct {
+	PitID    string `json:"pit_id"` // 使用 PIT 时返回，后续请求应使用最新的 ID
+	TimedOut bool   `json:"timed_out"`
+	Shards   struct {
+		Total  int `json:"total"`
+		Failed int `json:"failed"`
+	} `json:"_shards"`
+	Hits struct {
+		Total struct {
+			Value    int    `json:"value"`
+			Relation string `json:"relation"`
+		} `json:"total"`
+		Hits []searchHit `json:"hits"`
+	} `json:"hits"`
+	Suggest map[string][]suggestEntry `json:"suggest"`
+}
+
+// _msearch 响应，每个查询各自成功或失败
+type msearchResponse struct {
+	Responses []struct {
+		searchResponse
+		errorResponse
+		Status int `json:"status"`
+	} `json:"responses"`
+}
+
+type searchHit struct {
+	Index     string                    `json:"_index"`
+	ID        string                    `json:"_id"`
+	Source    torrentSource             `json:"_source"`
+	Sort      []interface{}             `json:"sort"`
+	Highlight map[string][]string       `json:"highlight"`
+	InnerHits map[string]innerHitResult `json:"inner_hits"`
+}
+
+type innerHitResult struct {
+	Hits struct {
+		Hits []struct {
+			Source    fileSource          `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// 索引文档中用到的字段，与 esindex.Document 对应
+type torrentSource struct {
+	ID         int64        `json:"id"`
+	InfoHash   string       `json:"infohash"`
+	Name       string       `json:"name"`
+	Length     int64        `json:"length"`
+	Files      bool         `json:"files"`
+	FileCount  int          `json:"file_count"`
+	Addeded    string       `json:"addeded"`
+	Updated    string       `json:"updated"`
+	Cnt        int          `json:"cnt"`
+	Category   string       `json:"category"`
+	Resolution string       `json:"resolution"`
+	FileList   []fileSource `json:"file_list"`
+}
+
+type fileSource struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+func (s torrentSource) torrent() Torrent {
+	return Torrent{
+		ID:         s.ID,
+		InfoHash:   s.InfoHash,
+		Name:       s.Name,
+		Length:     s.Length,
+		HasFiles:   s.Files,
+		FileCount:  s.FileCount,
+		Addeded:    s.Addeded,
+		Updated:    s.Updated,
+		Cnt:        s.Cnt,
+		Category:   s.Category,
+		Resolution: s.Resolution,
+	}
+}
+
+func fileList(list []fileSource) []File {
+	files := make([]File, 0, len(list))
+	for _, f := range list {
+		files = append(files, File{Path: f.Path, Length: f.Length})
+	}
+	return files
+}
+
+// ElasticError ES 返回的错误响应
+type ElasticError struct {
+	Status int    // HTTP 状态码
+	Type   string // 错误类型，如 index_not_found_exception
+	Reason string
+}
+
+func (e *ElasticError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("elasticsearch: %d %s", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("elasticsearch: %d %s: %s", e.Status, e.Type, e.Reason)
+}
+
+type errorResponse struct {
+	Error struct {
+		Type      string `json:"type"`
+		Reason    string `json:"reason"`
+		RootCause []struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"root_cause"`
+	} `json:"error"`
+}
+
+func (er errorResponse) elasticError(status int) *ElasticError {
+	// root_cause 通常比外层的 search_phase_execution_exception 更具体
+	e := &ElasticError{Status: status, Type: er.Error.Type, Reason: er.Error.Reason}
+	if len(er.Error.RootCause) > 0 {
+		e.Type, e.Reason = er.Error.RootCause[0].Type, er.Error.RootCause[0].Reason
+	}
+	return e
+}
+
+// 解析错误响应，响应体不是 ES 错误格式时以原文作为原因
+func responseError(res *esapi.Response) error {
+	e := &ElasticError{Status: res.StatusCode}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		e.Reason = err.Error()
+		return e
+	}
+
+	var er errorResponse
+	if json.Unmarshal(body, &er) == nil && er.Error.Type != "" {
+		return er.elasticError(res.StatusCode)
+	}
+
+	e.Reason = strings.TrimSpace(string(body))
+	if e.Reason == "" {
+		e.Reason = res.Status()
+	}
+	return e
+}
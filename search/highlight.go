@@ -0,0 +1,128 @@
+package search
+
+import (
+	"DHT-ES-Search/tokenizer"
+	"strings"
+	"unicode"
+)
+
+// 高亮标记，使用 Unicode 私用区字符，不会与名称中的 HTML 混淆，由页面转义后替换为标签
+const (
+	MarkStart = "\ue000"
+	MarkEnd   = "\ue001"
+)
+
+// StripMarks 去掉高亮标记
+func StripMarks(s string) string {
+	return strings.NewReplacer(MarkStart, "", MarkEnd, "").Replace(s)
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.M, r)
+}
+
+// markTokens 用标记包围 text 中与分词结果相同的词，中日韩文字按二元匹配。
+// tokens 为小写词元，没有命中时返回空串
+func markTokens(text string, tokens map[string]bool) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+
+	runes := []rune(StripMarks(text))
+	marked := make([]bool, len(runes))
+	hit := false
+
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			i++
+			continue
+		}
+
+		j := i
+		cjk := isCJK(runes[i])
+		for j < len(runes) && isWordRune(runes[j]) && isCJK(runes[j]) == cjk {
+			j++
+		}
+
+		if cjk {
+			if j-i == 1 && tokens[string(runes[i])] {
+				marked[i], hit = true, true
+			}
+			for k := i; k+1 < j; k++ {
+				if tokens[string(runes[k:k+2])] {
+					marked[k], marked[k+1], hit = true, true, true
+				}
+			}
+		} else if tokens[strings.ToLower(string(runes[i:j]))] {
+			for k := i; k < j; k++ {
+				marked[k] = true
+			}
+			hit = true
+		}
+		i = j
+	}
+
+	if !hit {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, r := range runes {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(MarkStart)
+		}
+		b.WriteRune(r)
+		if marked[i] && (i == len(runes)-1 || !marked[i+1]) {
+			b.WriteString(MarkEnd)
+		}
+	}
+	return b.String()
+}
+
+// 不使用 ES 的后端按分词结果判断命中并高亮
+type textMatcher struct {
+	tok    *tokenizer.Tokenizer
+	tokens map[string]bool // 查询的全部词元
+}
+
+func newTextMatcher(tok *tokenizer.Tokenizer, text string) *textMatcher {
+	m := &textMatcher{tok: tok, tokens: make(map[string]bool)}
+	for _, t := range tok.Tokenize(text) {
+		m.tokens[t] = true
+	}
+	return m
+}
+
+// contains 返回 text 是否包含全部查询词元
+func (m *textMatcher) contains(text string) bool {
+	if len(m.tokens) == 0 {
+		return false
+	}
+	have := make(map[string]bool)
+	for _, t := range m.tok.Tokenize(text) {
+		have[t] = true
+	}
+	for t := range m.tokens {
+		if !have[t] {
+			return false
+		}
+	}
+	return true
+}
+
+// highlight 返回标记后的文本，没有命中时为空
+func (m *textMatcher) highlight(text string) string {
+	return markTokens(text, m.tokens)
+}
+
+// file 路径包含全部词元时返回带高亮的文件
+func (m *textMatcher) file(path string, length int64) (File, bool) {
+	if !m.contains(path) {
+		return File{}, false
+	}
+	return File{Path: path, Length: length, Highlight: m.highlight(path)}, true
+}
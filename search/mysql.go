@@ -0,0 +1,530 @@
+package search
+
+import (
+	"DHT-ES-Search/querylang"
+	"DHT-ES-Search/tokenizer"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type mysqlBackend struct {
+	db  *sql.DB
+	tok *tokenizer.Tokenizer
+}
+
+// NewMySQL 返回基于 infohash.textindex FULLTEXT 索引的后端，无需 Elasticsearch。
+// tok 应与爬虫生成 textindex 时的配置一致
+func NewMySQL(db *sql.DB, tok *tokenizer.Tokenizer) Backend {
+	return &mysqlBackend{db: db, tok: tok}
+}
+
+const torrentColumns = "id, infohash, name, length, files, file_count, addeded, updated, cnt, category, resolution"
+
+// datetime 参数格式
+const mysqlDateLayout = "2006-01-02 15:04:05"
+
+// torrentColumns 对应的扫描目标
+func torrentDest(t *Torrent) []interface{} {
+	return []interface{}{&t.ID, &t.InfoHash, &t.Name, &t.Length, &t.HasFiles, &t.FileCount, &t.Addeded, &t.Updated, &t.Cnt, &t.Category, &t.Resolution}
+}
+
+func scanTorrent(row interface{ Scan(...interface{}) error }) (Torrent, error) {
+	var t Torrent
+	err := row.Scan(torrentDest(&t)...)
+	return t, err
+}
+
+func (b *mysqlBackend) query(ctx context.Context, query string, args ...interface{}) ([]Torrent, error) {
+	rows, err := b.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query infohash: %v", err)
+	}
+	defer rows.Close()
+
+	torrents := make([]Torrent, 0)
+	for rows.Next() {
+		t, err := scanTorrent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan infohash: %v", err)
+		}
+		torrents = append(torrents, t)
+	}
+	return torrents, rows.Err()
+}
+
+// 布尔模式查询串，与 textindex 使用相同的分词，所有词都必须出现
+func (b *mysqlBackend) booleanQuery(text string) string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range b.tok.Tokenize(text) {
+		if !seen[t] {
+			seen[t] = true
+			terms = append(terms, "+"+t)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+// 名称或任一文件路径包含短语：单词之间可以是任意个非字母数字字符，如 "foo bar" 可匹配
+// Foo - Bar、foo  bar，与嵌入式后端的 querylang.ContainsPhrase 一致。LIKE 先粗筛，
+// REGEXP 再按单词边界确认，不会匹配 foobar 或 xfoo bar。单词只含字母和数字，无需转义
+func phraseCond(phrase string) (string, []interface{}) {
+	words := querylang.Words(phrase)
+	if len(words) == 0 {
+		return "FALSE", nil
+	}
+	like := "%" + strings.Join(words, "%") + "%"
+	re := "(^|[^[:alnum:]])" + strings.Join(words, "[^[:alnum:]]+") + "([^[:alnum:]]|$)"
+	return "((name LIKE ? AND name REGEXP ?) OR EXISTS (SELECT 1 FROM files f WHERE f.infohash_id = infohash.id AND f.path LIKE ? AND f.path REGEXP ?))",
+		[]interface{}{like, re, like, re}
+}
+
+// 含有任一扩展名：多文件种子查 files 表，单文件种子查名称
+func extCond(exts []string) (string, []interface{}) {
+	var likes []string
+	var args []interface{}
+	for _, ext := range exts {
+		likes = append(likes, "%."+ext)
+	}
+
+	pathConds := strings.TrimSuffix(strings.Repeat("f.path LIKE ? OR ", len(likes)), " OR ")
+	nameConds := strings.TrimSuffix(strings.Repeat("name LIKE ? OR ", len(likes)), " OR ")
+	for _, l := range likes {
+		args = append(args, l)
+	}
+	for _, l := range likes {
+		args = append(args, l)
+	}
+
+	cond := "(EXISTS (SELECT 1 FROM files f WHERE f.infohash_id = infohash.id AND (" + pathConds + "))" +
+		" OR (files = 0 AND (" + nameConds + ")))"
+	return cond, args
+}
+
+// WHERE 条件
+func (b *mysqlBackend) where(expr *querylang.Expr, f Filter) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+
+	add := func(cond string, arg ...interface{}) {
+		conds = append(conds, cond)
+		args = append(args, arg...)
+	}
+
+	// 短语的词也加入全文条件，先用索引缩小范围
+	if expr.HasText() {
+		add("MATCH(textindex) AGAINST(? IN BOOLEAN MODE)", b.booleanQuery(expr.Text()))
+	}
+	for _, p := range expr.Phrases {
+		cond, phraseArgs := phraseCond(p)
+		add(cond, phraseArgs...)
+	}
+	for _, t := range expr.ExcludeTerms {
+		if q := b.booleanQuery(t); q != "" {
+			add("NOT MATCH(textindex) AGAINST(? IN BOOLEAN MODE)", q)
+		}
+	}
+	for _, p := range expr.ExcludePhrases {
+		cond, phraseArgs := phraseCond(p)
+		add("NOT "+cond, phraseArgs...)
+	}
+
+	if len(expr.Ext) > 0 {
+		cond, extArgs := extCond(expr.Ext)
+		add(cond, extArgs...)
+	}
+	if len(expr.ExcludeExt) > 0 {
+		cond, extArgs := extCond(expr.ExcludeExt)
+		add("NOT "+cond, extArgs...)
+	}
+	addRange := func(column string, r querylang.Range) {
+		if r.Min != nil {
+			add(column+" >= ?", *r.Min)
+		}
+		if r.Max != nil {
+			add(column+" <= ?", *r.Max)
+		}
+	}
+	addRange("length", expr.Size)
+	addRange("file_count", expr.Files)
+	if !expr.Added.From.IsZero() {
+		add("addeded >= ?", expr.Added.From.Format(mysqlDateLayout))
+	}
+	if !expr.Added.To.IsZero() {
+		add("addeded < ?", expr.Added.To.Format(mysqlDateLayout))
+	}
+	if expr.Hash != "" {
+		add("infohash LIKE ?", expr.Hash+"%")
+	}
+
+	if f.Year > 0 {
+		add("year = ?", f.Year)
+	}
+	if f.Season > 0 {
+		add("season = ?", f.Season)
+	}
+	if f.Episode > 0 {
+		add("episode = ?", f.Episode)
+	}
+	if f.Resolution != "" {
+		add("resolution = ?", f.Resolution)
+	}
+	if f.VideoCodec != "" {
+		add("video_codec = ?", f.VideoCodec)
+	}
+	if f.AudioCodec != "" {
+		add("audio_codec = ?", f.AudioCodec)
+	}
+	if f.Source != "" {
+		add("source = ?", f.Source)
+	}
+	if f.Group != "" {
+		// utf8mb4_general_ci 比较不区分大小写
+		add("release_group = ?", f.Group)
+	}
+	if f.Language != "" {
+		add("FIND_IN_SET(?, languages) > 0", f.Language)
+	}
+	if len(f.Categories) > 0 {
+		cats := make([]interface{}, len(f.Categories))
+		for i, c := range f.Categories {
+			cats[i] = c
+		}
+		add("category IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(cats)), ", ")+")", cats...)
+	}
+
+	if f.MinSize > 0 {
+		add("length >= ?", f.MinSize)
+	}
+	if f.MaxSize > 0 {
+		add("length <= ?", f.MaxSize)
+	}
+	addDays := func(column, from, to string) {
+		start, end := dayRange(from, to)
+		if !start.IsZero() {
+			add(column+" >= ?", start.Format(mysqlDateLayout))
+		}
+		if !end.IsZero() {
+			add(column+" < ?", end.Format(mysqlDateLayout))
+		}
+	}
+	addDays("addeded", f.AddedFrom, f.AddedTo)
+	addDays("updated", f.UpdatedFrom, f.UpdatedTo)
+	switch f.Files {
+	case FilesSingle:
+		add("files = ?", 0)
+	case FilesMulti:
+		add("files = ?", 1)
+	}
+	if f.MinFiles > 0 {
+		add("file_count >= ?", f.MinFiles)
+	}
+	if f.MaxFiles > 0 {
+		add("file_count <= ?", f.MaxFiles)
+	}
+
+	return conds, args
+}
+
+// 游标中的数值经过 JSON 往返后为 float64
+func cursorInt(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// 相关度等浮点游标值
+func cursorFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// 排序方式对应的列
+var mysqlSortColumns = map[string]string{
+	OrderUpdated: "updated",
+	OrderCnt:     "cnt",
+	OrderSize:    "length",
+	OrderName:    "name",
+	OrderAdded:   "addeded",
+	OrderFiles:   "file_count",
+}
+
+// 相关度为全文得分乘以 1e6 取整，游标比较时没有浮点误差
+const relevanceColumn = "CAST(MATCH(textindex) AGAINST(? IN BOOLEAN MODE) * 1000000 AS SIGNED)"
+
+// 游标中的排序值：数值列为整数，名称和时间为字符串
+func mysqlCursorValue(order string, v interface{}) (interface{}, bool) {
+	switch order {
+	case OrderCnt, OrderSize, OrderFiles, OrderRelevance:
+		if s, ok := v.(string); ok {
+			n, err := strconv.ParseInt(s, 10, 64)
+			return n, err == nil
+		}
+		return cursorInt(v)
+	}
+	s, ok := v.(string)
+	return s, ok
+}
+
+func (b *mysqlBackend) Search(ctx context.Context, q Query) (Result, error) {
+	var res Result
+
+	expr, err := querylang.Parse(q.Text)
+	if err != nil {
+		return res, err
+	}
+
+	// 分词后没有可搜索的词时与 ES 的 zero_terms_query 一致，返回空结果
+	if expr.HasText() && b.booleanQuery(expr.Text()) == "" {
+		return res, nil
+	}
+
+	conds, args := b.where(expr, q.Filter)
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	if err := b.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM infohash"+where, args...).Scan(&res.Total); err != nil {
+		return res, fmt.Errorf("count infohash: %v", err)
+	}
+
+	// 与 ES 相同的排序，游标为 [排序值, id]
+	order := effectiveOrder(q.Order, expr.HasText())
+	key, keyArgs := mysqlSortColumns[order], []interface{}(nil)
+	if order == OrderRelevance {
+		key, keyArgs = relevanceColumn, []interface{}{b.booleanQuery(expr.Text())}
+	}
+	// 上一页：反向排序取游标之前的结果，再恢复顺序
+	asc, after, idOp, idDir := q.Asc, q.After, ">", "ASC"
+	if q.Before != nil {
+		asc, after, idOp, idDir = !asc, q.Before, "<", "DESC"
+	}
+	op, dir := "<", "DESC"
+	if asc {
+		op, dir = ">", "ASC"
+	}
+
+	if after != nil {
+		if len(after) != 2 {
+			return res, ErrInvalidCursor
+		}
+		id, ok := cursorInt(after[1])
+		if !ok {
+			return res, ErrInvalidCursor
+		}
+		value, ok := mysqlCursorValue(order, after[0])
+		if !ok {
+			return res, ErrInvalidCursor
+		}
+		conds = append(conds, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[3]s ?))", key, op, idOp))
+		args = append(append(append(append(args, keyArgs...), value), keyArgs...), value, id)
+	}
+
+	query := "SELECT " + torrentColumns + ", " + key + " AS sort_key FROM infohash"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY sort_key %s, id %s LIMIT ?", dir, idDir)
+	args = append(append(keyArgs, args...), q.Size)
+	// 跳页：没有游标时按偏移量
+	if after == nil && q.From > 0 {
+		query += " OFFSET ?"
+		args = append(args, q.From)
+	}
+
+	rows, err := b.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return res, fmt.Errorf("query infohash: %v", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var t Torrent
+		var key string
+		if err := rows.Scan(append(torrentDest(&t), &key)...); err != nil {
+			return res, fmt.Errorf("scan infohash: %v", err)
+		}
+		res.Torrents = append(res.Torrents, t)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return res, fmt.Errorf("query infohash: %v", err)
+	}
+
+	if q.Before != nil {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			res.Torrents[i], res.Torrents[j] = res.Torrents[j], res.Torrents[i]
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+	if n := len(res.Torrents); n > 0 {
+		first, _ := mysqlCursorValue(order, keys[0])
+		last, _ := mysqlCursorValue(order, keys[n-1])
+		res.Prev = []interface{}{first, res.Torrents[0].ID}
+		res.Next = []interface{}{last, res.Torrents[n-1].ID}
+	}
+
+	if expr.HasText() {
+		m := newTextMatcher(b.tok, expr.Text())
+		var ids []interface{}
+		for i := range res.Torrents {
+			t := &res.Torrents[i]
+			t.Highlight = m.highlight(t.Name)
+			// 名称不包含全部词时命中来自文件路径
+			if t.HasFiles && !m.contains(t.Name) {
+				ids = append(ids, t.ID)
+			}
+		}
+		matched, err := b.matchedFiles(ctx, ids, m)
+		if err != nil {
+			return res, err
+		}
+		for i := range res.Torrents {
+			res.Torrents[i].MatchedFiles = matched[res.Torrents[i].ID]
+		}
+	}
+
+	return res, nil
+}
+
+func (b *mysqlBackend) Suggest(ctx context.Context, text string, n int) ([]Suggestion, error) {
+	query := b.booleanQuery(text)
+	if query == "" {
+		return nil, nil
+	}
+	// 最后一个词可能未输完，按前缀匹配
+	if !strings.HasSuffix(text, " ") {
+		query += "*"
+	}
+
+	rows, err := b.db.QueryContext(ctx,
+		"SELECT name, MAX(cnt) AS c FROM infohash WHERE MATCH(textindex) AGAINST(? IN BOOLEAN MODE) GROUP BY name ORDER BY c DESC LIMIT ?",
+		query, n)
+	if err != nil {
+		return nil, fmt.Errorf("query suggestions: %v", err)
+	}
+	defer rows.Close()
+
+	var list []Suggestion
+	for rows.Next() {
+		var s Suggestion
+		if err := rows.Scan(&s.Text, &s.Cnt); err != nil {
+			return nil, fmt.Errorf("scan suggestions: %v", err)
+		}
+		list = appendSuggestion(list, s, n)
+	}
+	return list, rows.Err()
+}
+
+// MySQL 全文索引不提供词典，不做拼写纠正
+func (b *mysqlBackend) Correct(ctx context.Context, terms []string) ([]string, error) {
+	return nil, nil
+}
+
+func (b *mysqlBackend) Count(ctx context.Context) (int, error) {
+	var n int
+	if err := b.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM infohash").Scan(&n); err != nil {
+		return 0, fmt.Errorf("count infohash: %v", err)
+	}
+	return n, nil
+}
+
+func (b *mysqlBackend) Latest(ctx context.Context, n int) ([]Torrent, error) {
+	return b.query(ctx, "SELECT "+torrentColumns+" FROM infohash ORDER BY updated DESC, id ASC LIMIT ?", n)
+}
+
+func (b *mysqlBackend) Popular(ctx context.Context, n int) ([]Torrent, error) {
+	return b.query(ctx, "SELECT "+torrentColumns+" FROM infohash ORDER BY cnt DESC, id ASC LIMIT ?", n)
+}
+
+func (b *mysqlBackend) Overview(ctx context.Context, n int) (Overview, error) {
+	return overview(ctx, b, n)
+}
+
+func (b *mysqlBackend) Get(ctx context.Context, id int64) (*Torrent, error) {
+	t, err := scanTorrent(b.db.QueryRowContext(ctx, "SELECT "+torrentColumns+" FROM infohash WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query infohash: %v", err)
+	}
+
+	if t.HasFiles {
+		if t.Files, err = queryFiles(ctx, b.db, t.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &t, nil
+}
+
+// 按 idx 保持种子内的原始文件顺序
+func queryFiles(ctx context.Context, db *sql.DB, id int64) ([]File, error) {
+	rows, err := db.QueryContext(ctx, "SELECT path, length FROM files WHERE infohash_id = ? ORDER BY idx", id)
+	if err != nil {
+		return nil, fmt.Errorf("query files: %v", err)
+	}
+	defer rows.Close()
+
+	files := make([]File, 0)
+	for rows.Next() {
+		var f File
+		if err := rows.Scan(&f.Path, &f.Length); err != nil {
+			return nil, fmt.Errorf("scan files: %v", err)
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
+
+// 一次查询取出本页各种子中路径包含全部搜索词的文件，每个种子最多 matchedFilesLimit 个
+func (b *mysqlBackend) matchedFiles(ctx context.Context, ids []interface{}, m *textMatcher) (map[int64][]File, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query := "SELECT infohash_id, path, length FROM files WHERE infohash_id IN (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ") ORDER BY infohash_id, idx"
+	rows, err := b.db.QueryContext(ctx, query, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("query files: %v", err)
+	}
+	defer rows.Close()
+
+	files := make(map[int64][]File, len(ids))
+	for rows.Next() {
+		var id, length int64
+		var path string
+		if err := rows.Scan(&id, &path, &length); err != nil {
+			return nil, fmt.Errorf("scan files: %v", err)
+		}
+		if len(files[id]) == matchedFilesLimit {
+			continue
+		}
+		if f, ok := m.file(path, length); ok {
+			files[id] = append(files[id], f)
+		}
+	}
+	return files, rows.Err()
+}
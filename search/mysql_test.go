@@ -0,0 +1,40 @@
+package search
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 没有 MySQL 时用 Go 的正则检查短语条件的 LIKE 和 REGEXP 模式，
+// 两者与 MySQL 一样不区分大小写
+func TestPhraseCond(t *testing.T) {
+	tests := []struct {
+		text, phrase string
+		want         bool
+	}{
+		{"The.Matrix.1999.mkv", "the matrix", true},
+		{"Foo - Bar.mkv", "foo bar", true},
+		{"foo  bar", "foo bar", true},
+		{"Sample/foo_bar.mkv", "foo bar", true},
+		{"foo", "foo", true},
+		{"foobar", "foo bar", false},
+		{"xfoo bar", "foo bar", false},
+		{"foo barx", "foo bar", false},
+		{"foo baz bar", "foo bar", false},
+	}
+
+	for _, tt := range tests {
+		_, args := phraseCond(tt.phrase)
+		like := "(?is)^" + strings.ReplaceAll(regexp.QuoteMeta(args[0].(string)), "%", ".*") + "$"
+		got := regexp.MustCompile(like).MatchString(tt.text) && regexp.MustCompile("(?i)"+args[1].(string)).MatchString(tt.text)
+		if got != tt.want {
+			t.Errorf("phraseCond(%q) on %q = %v, want %v (args %q)", tt.phrase, tt.text, got, tt.want, args)
+		}
+	}
+
+	// 没有单词的短语什么也不匹配，与嵌入式后端一致
+	if cond, args := phraseCond(" - "); cond != "FALSE" || args != nil {
+		t.Errorf("phraseCond(\" - \") = %q, %q", cond, args)
+	}
+}
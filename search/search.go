@@ -0,0 +1,254 @@
+// Package search 定义 webinterface 使用的搜索后端接口，
+// 提供 Elasticsearch、MySQL FULLTEXT 与嵌入式索引三种实现，由配置选择。
+package search
+
+import (
+	"DHT-ES-Search/querylang"
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// 排序方式
+const (
+	OrderRelevance = "relevance" // 相关度，只在有关键词时有效
+	OrderUpdated   = "updated"   // 最近一次被爬虫发现的时间
+	OrderCnt       = "cnt"       // 热度
+	OrderSize      = "size"      // 总大小
+	OrderName      = "name"
+	OrderAdded     = "added" // 收录时间
+	OrderFiles     = "files" // 文件数
+)
+
+// Orders 全部排序方式
+var Orders = []string{OrderRelevance, OrderCnt, OrderUpdated, OrderAdded, OrderSize, OrderName, OrderFiles}
+
+// ValidOrder 返回是否为支持的排序方式
+func ValidOrder(order string) bool {
+	for _, o := range Orders {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultAsc 返回排序方式的默认方向：名称升序，其余降序
+func DefaultAsc(order string) bool {
+	return order == OrderName
+}
+
+// 后端实际使用的排序：未知的排序和没有关键词时的相关度按 updated
+func effectiveOrder(order string, hasText bool) string {
+	if !ValidOrder(order) || order == OrderRelevance && !hasText {
+		return OrderUpdated
+	}
+	return order
+}
+
+// ErrNotFound 种子不存在
+var ErrNotFound = errors.New("torrent not found")
+
+// ErrInvalidCursor 分页游标无法解析
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrPITExpired 翻页使用的 point-in-time 已过期，需要重新打开
+var ErrPITExpired = errors.New("point in time expired")
+
+// Torrent 一条种子记录
+type Torrent struct {
+	ID           int64
+	InfoHash     string
+	Name         string
+	Length       int64
+	HasFiles     bool
+	FileCount    int    // 文件数，单文件种子为 1，旧数据可能为 0
+	Files        []File // 仅 Get 返回
+	MatchedFiles []File // 搜索时命中的文件，后端不支持时为空
+	Highlight    string // 名称中命中的词以 MarkStart/MarkEnd 包围，名称未命中时为空
+	Addeded      string
+	Updated      string
+	Cnt          int
+	Category     string // release.Category 计算的分类，旧数据可能为空
+	Resolution   string // 名称中的分辨率，如 1080p
+}
+
+// 各后端返回的时间格式：MySQL 和嵌入式索引为 DATETIME，ES 为 date_hour_minute_second
+var timeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05"}
+
+// parseTime 按本地时区解析种子的添加或更新时间，无法解析时返回零值
+func parseTime(s string) time.Time {
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// AddedTime 返回首次发现的时间
+func (t Torrent) AddedTime() time.Time {
+	return parseTime(t.Addeded)
+}
+
+// UpdatedTime 返回最后更新的时间
+func (t Torrent) UpdatedTime() time.Time {
+	return parseTime(t.Updated)
+}
+
+// File 种子内的文件
+type File struct {
+	Path      string
+	Length    int64
+	Highlight string // 路径中命中的词以 MarkStart/MarkEnd 包围，仅 MatchedFiles 中设置
+}
+
+// 单文件/多文件过滤
+const (
+	FilesSingle = "single"
+	FilesMulti  = "multi"
+)
+
+// DateLayout 过滤条件中的日期格式
+const DateLayout = "2006-01-02"
+
+// Filter 发布信息及大小、日期、文件数过滤条件，零值表示不过滤
+type Filter struct {
+	Year       int
+	Season     int
+	Episode    int
+	Resolution string
+	VideoCodec string
+	AudioCodec string
+	Source     string
+	Group      string
+	Language   string
+	Categories []string // 种子分类（release.Category），满足其一即可
+
+	MinSize, MaxSize       int64  // 总大小，字节
+	AddedFrom, AddedTo     string // 收录日期，YYYY-MM-DD，包含当天
+	UpdatedFrom, UpdatedTo string // 更新日期
+	Files                  string // FilesSingle 或 FilesMulti
+	MinFiles, MaxFiles     int    // 文件数，单文件种子为 1
+}
+
+// 日期区间 [from 当天 0 点, to 次日 0 点)，未设置或无法解析的一端为零值
+func dayRange(from, to string) (time.Time, time.Time) {
+	var start, end time.Time
+	if t, err := time.Parse(DateLayout, from); err == nil {
+		start = t
+	}
+	if t, err := time.Parse(DateLayout, to); err == nil {
+		end = t.AddDate(0, 0, 1)
+	}
+	return start, end
+}
+
+// Values 返回过滤条件对应的查询参数
+func (f Filter) Values() url.Values {
+	v := url.Values{}
+
+	setInt := func(key string, n int) {
+		if n > 0 {
+			v.Set(key, strconv.Itoa(n))
+		}
+	}
+	setStr := func(key, s string) {
+		if s != "" {
+			v.Set(key, s)
+		}
+	}
+
+	setInt("year", f.Year)
+	setInt("season", f.Season)
+	setInt("episode", f.Episode)
+	setStr("resolution", f.Resolution)
+	setStr("video_codec", f.VideoCodec)
+	setStr("audio_codec", f.AudioCodec)
+	setStr("source", f.Source)
+	setStr("group", f.Group)
+	setStr("lang", f.Language)
+
+	if f.MinSize > 0 {
+		v.Set("min_size", querylang.FormatSize(f.MinSize))
+	}
+	if f.MaxSize > 0 {
+		v.Set("max_size", querylang.FormatSize(f.MaxSize))
+	}
+	setStr("added_from", f.AddedFrom)
+	setStr("added_to", f.AddedTo)
+	setStr("updated_from", f.UpdatedFrom)
+	setStr("updated_to", f.UpdatedTo)
+	setStr("files", f.Files)
+	setInt("min_files", f.MinFiles)
+	setInt("max_files", f.MaxFiles)
+
+	return v
+}
+
+// Query 搜索条件。结果按 Order 和 Asc 排序，排序值相同时按 id 升序
+type Query struct {
+	Text   string
+	Order  string // 为空或不支持时按 updated
+	Asc    bool   // 升序，默认降序
+	Filter Filter
+	Size   int
+	From   int           // 跳页时跳过的结果数，只在没有 After/Before 时使用
+	After  []interface{} // 上一页最后一条的排序值，由后端在 Result.Next 中返回
+	Before []interface{} // 下一页第一条的排序值（Result.Prev），返回它之前的一页，与 After 只设置其一
+	PIT    string        // 上一页返回的 Result.PIT，为空且按游标翻页时打开新的快照
+	// 按 updated、cnt 排序时第一页就打开快照，翻页从第一页起保持一致；订阅、Torznab 只取一页，不需要
+	Snapshot bool
+}
+
+// Result 一页搜索结果
+type Result struct {
+	Torrents []Torrent
+	Total    int
+	Next     []interface{} // 下一页的 After，没有结果时为 nil
+	Prev     []interface{} // 上一页的 Before，没有结果时为 nil
+	PIT      string        // 本次查询使用的 ES point-in-time，之后翻页时传回；不支持、没有快照或只有一页时为空
+}
+
+// Overview 首页数据
+type Overview struct {
+	Count   int
+	Latest  []Torrent
+	Popular []Torrent
+}
+
+// 依次查询首页数据，用于不支持批量查询的后端
+func overview(ctx context.Context, b Backend, n int) (Overview, error) {
+	var res Overview
+	var err error
+	if res.Count, err = b.Count(ctx); err != nil {
+		return res, err
+	}
+	if res.Latest, err = b.Latest(ctx, n); err != nil {
+		return res, err
+	}
+	res.Popular, err = b.Popular(ctx, n)
+	return res, err
+}
+
+// Backend 搜索后端
+type Backend interface {
+	// Search 按关键词和过滤条件搜索一页结果
+	Search(ctx context.Context, q Query) (Result, error)
+	// Count 返回种子总数
+	Count(ctx context.Context) (int, error)
+	// Latest 返回最近更新的 n 个种子
+	Latest(ctx context.Context, n int) ([]Torrent, error)
+	// Popular 返回热度最高的 n 个种子
+	Popular(ctx context.Context, n int) ([]Torrent, error)
+	// Overview 返回首页数据：种子总数及最近更新、热度最高的各 n 个种子
+	Overview(ctx context.Context, n int) (Overview, error)
+	// Get 返回种子详情及文件列表，不存在时返回 ErrNotFound
+	Get(ctx context.Context, id int64) (*Torrent, error)
+	// Suggest 返回与输入匹配的种子名称，最后一个词按前缀匹配，按热度降序、名称去重
+	Suggest(ctx context.Context, text string, n int) ([]Suggestion, error)
+	// Correct 返回拼写纠正后的词，与 terms 一一对应；没有可纠正的词时返回 nil
+	Correct(ctx context.Context, terms []string) ([]string, error)
+}
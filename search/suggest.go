@@ -0,0 +1,103 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Suggestion 一条补全建议
+type Suggestion struct {
+	Text string
+	Cnt  int // 种子的热度或关键词的搜索次数
+}
+
+// 按 Text 去重追加，忽略大小写，最多保留 n 条
+func appendSuggestion(list []Suggestion, s Suggestion, n int) []Suggestion {
+	if len(list) >= n {
+		return list
+	}
+	for _, x := range list {
+		if strings.EqualFold(x.Text, s.Text) {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// 关键词至少被搜索过这么多次才会出现在建议中，避免展示个别用户的输入
+const minQueryCount = 2
+
+// 单个关键词的最大长度
+const maxQueryLength = 100
+
+// QueryStats 统计有结果的搜索关键词，用于补全建议。可并发使用
+type QueryStats struct {
+	mu     sync.Mutex
+	counts map[string]int
+	max    int
+}
+
+// NewQueryStats 返回最多记录 max 个关键词的统计
+func NewQueryStats(max int) *QueryStats {
+	return &QueryStats{counts: make(map[string]int), max: max}
+}
+
+// 小写并合并空白
+func normalizeQuery(q string) string {
+	return strings.Join(strings.Fields(strings.ToLower(q)), " ")
+}
+
+// Add 记录一次搜索
+func (s *QueryStats) Add(q string) {
+	q = normalizeQuery(q)
+	if q == "" || len(q) > maxQueryLength {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 已满时所有计数减半并删除归零的关键词，近期的热门关键词得以保留
+	for len(s.counts) >= s.max {
+		if _, ok := s.counts[q]; ok {
+			break
+		}
+		for k, n := range s.counts {
+			if n /= 2; n == 0 {
+				delete(s.counts, k)
+			} else {
+				s.counts[k] = n
+			}
+		}
+	}
+	s.counts[q]++
+}
+
+// Suggest 返回以 prefix 开头的热门关键词，按搜索次数降序
+func (s *QueryStats) Suggest(prefix string, n int) []Suggestion {
+	prefix = normalizeQuery(prefix)
+	if prefix == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	var list []Suggestion
+	for q, cnt := range s.counts {
+		if cnt >= minQueryCount && q != prefix && strings.HasPrefix(q, prefix) {
+			list = append(list, Suggestion{Text: q, Cnt: cnt})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Cnt != list[j].Cnt {
+			return list[i].Cnt > list[j].Cnt
+		}
+		return list[i].Text < list[j].Text
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"DHT-ES-Search/crawler"
+	"DHT-ES-Search/embedded"
+	"DHT-ES-Search/exporter"
+	"DHT-ES-Search/harness"
+	"DHT-ES-Search/importer"
+	"DHT-ES-Search/release"
+	"DHT-ES-Search/tokenizer"
+	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/sha1"
 	"database/sql"
 	"encoding/hex"
 	"errors"
@@ -14,7 +24,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"sort"
+	"runtime"
 	"strings"
 	"sync"
 	"syscall"
@@ -22,31 +32,18 @@ import (
 )
 
 var (
-	db       *sql.DB
-	l        *log.Logger
-	port     string
-	replacer *strings.Replacer
+	cfg     *config.Config
+	l       *log.Logger
+	port    string
+	portPtr *string
+	tok     *tokenizer.Tokenizer
 )
 
 const (
 	logFileName    = "logger.log"
 	configFileName = "config.json"
-	maxRetries     = 3
-	retryDelay     = time.Second * 2
 )
 
-type file struct {
-	Path   []interface{} `json:"path"`
-	Length int           `json:"length"`
-}
-
-type bitTorrent struct {
-	InfoHash string `json:"infohash"`
-	Name     string `json:"name"`
-	Files    []file `json:"files,omitempty"`
-	Length   int    `json:"length,omitempty"`
-}
-
 func init() {
 	// 初始化日志
 	f, err := os.OpenFile(logFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
@@ -58,33 +55,48 @@ func init() {
 	l = log.New(multi, "main: ", log.Ldate|log.Ltime|log.Lshortfile)
 
 	// 取配置文件
-	cfg, err := config.ParseJsonFile(configFileName)
+	cfg, err = config.ParseJsonFile(configFileName)
 	if err != nil {
 		l.Fatalln("无法打开配置文件", configFileName, ":", err)
 	}
 
+	// 设置默认端口
+	port, _ = cfg.String("spider.port")
+	if port == "" {
+		port = "6881"
+	}
+	portPtr = flag.String("port", port, "DHT端口")
+
+	// 初始化分词器，未配置停用词时使用默认列表
+	var stopWords []string
+	if list, err := cfg.List("tokenizer.stopwords"); err == nil {
+		stopWords = make([]string, 0, len(list))
+		for _, w := range list {
+			if s, ok := w.(string); ok {
+				stopWords = append(stopWords, s)
+			}
+		}
+	}
+	tok = tokenizer.New(stopWords)
+}
+
+// 连接数据库
+func openDatabase() (*sql.DB, error) {
 	// 获取并验证数据库配置
 	host, _ := cfg.String("database.host")
 	name, _ := cfg.String("database.name")
 	user, _ := cfg.String("database.user")
 	pass, _ := cfg.String("database.password")
-	port, _ = cfg.String("spider.port")
-
-	// 设置默认端口
-	if port == "" {
-		port = "6881"
-	}
 
 	if host == "" || name == "" || user == "" {
-		l.Fatalln("数据库配置不完整")
+		return nil, errors.New("数据库配置不完整")
 	}
 
-	// 连接数据库
-	dsn := fmt.Sprintf("%s:%s@%s/%s", user, pass, host, name)
-	var dbErr error
-	db, dbErr = sql.Open("mysql", dsn)
-	if dbErr != nil {
-		l.Fatalln("数据库连接错误", dbErr.Error())
+	// 使用 utf8mb4 以支持 emoji 及生僻字
+	dsn := fmt.Sprintf("%s:%s@%s/%s?charset=utf8mb4&collation=utf8mb4_general_ci", user, pass, host, name)
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("数据库连接错误: %v", err)
 	}
 
 	// 配置连接池
@@ -94,77 +106,40 @@ func init() {
 
 	// 验证数据库连接
 	if err := db.Ping(); err != nil {
-		l.Fatalln("数据库连接错误", err.Error())
+		db.Close()
+		return nil, fmt.Errorf("数据库连接错误: %v", err)
 	}
 
-	portPtr := flag.String("port", port, "DHT端口")
-	flag.Parse()
-	port = *portPtr
-
-	// 初始化字符串替换器
-	replacer = strings.NewReplacer(
-		"/", " ",
-		"[", " ",
-		"(", " ",
-		"]", " ",
-		")", " ",
-		".", " ",
-		"_", " ",
-	)
+	return db, nil
 }
 
-func GenerateSearchIndex(text string) string {
-	// 清洗文本
-	text = replacer.Replace(text)
-	uniq := map[string]int{}
-
-	// 统计词频
-	for _, s := range strings.Split(text, " ") {
-		if s != "" {
-			if cv, presen := uniq[s]; presen {
-				uniq[s] = cv + 1
-			} else {
-				uniq[s] = 1
-			}
-		}
-	}
-
-	// 排序
-	type kvt struct {
-		Key   string
-		Value int
-	}
-	kv := make([]kvt, 0, len(uniq))
-	for k, v := range uniq {
-		kv = append(kv, kvt{k, v})
-	}
-
-	sort.Slice(kv, func(i, j int) bool { return kv[i].Value > kv[j].Value })
+// 路径哈希，用于在 text 列上建立索引
+func pathHash(path string) string {
+	sum := sha1.Sum([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
 
-	// 生成索引
-	var indexText string
-	for _, i := range kv {
-		indexText += i.Key + " "
-	}
+// MySQL 存储
+type mysqlStore struct {
+	db *sql.DB
+}
 
-	return strings.TrimSpace(indexText)
+func (s *mysqlStore) SaveTorrent(ctx context.Context, bt *crawler.Torrent) error {
+	return processTorrent(ctx, s.db, bt)
 }
 
-// 重试机制
-func withRetry(operation func() error) error {
-	var err error
-	for i := 0; i < maxRetries; i++ {
-		if err = operation(); err == nil {
-			return nil
-		}
-		time.Sleep(retryDelay)
-		l.Printf("操作失败，正在重试 (%d/%d): %v", i+1, maxRetries, err)
+// 查询种子是否已入库
+func (s *mysqlStore) Exists(ctx context.Context, infoHash string) (bool, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM infohash WHERE infohash = ?", infoHash).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
 	}
-	return err
+	return err == nil, err
 }
 
 // 处理种子信息
-func processTorrent(ctx context.Context, bt *bitTorrent) error {
+func processTorrent(ctx context.Context, db *sql.DB, bt *crawler.Torrent) error {
 	// 使用事务处理
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -181,25 +156,16 @@ func processTorrent(ctx context.Context, bt *bitTorrent) error {
 
 	if errors.Is(err, sql.ErrNoRows) {
 		// 插入新记录
-		textIndex := bt.Name
-		totalLength := bt.Length
-
-		// 处理文件信息
-		if len(bt.Files) > 0 {
-			for _, f := range bt.Files {
-				totalLength += f.Length
-				for _, p := range f.Path {
-					textIndex += " " + p.(string)
-				}
-			}
-		}
-
-		// 生成搜索索引
-		textIndex = GenerateSearchIndex(textIndex)
+		rec := crawler.NewRecord(bt, tok)
+		ri := rec.Release
 
 		result, err := tx.ExecContext(ctx,
-			"INSERT INTO infohash (infohash, name, files, length, addeded, updated, textindex) VALUES (?, ?, ?, ?, NOW(), NOW(), ?)",
-			bt.InfoHash, bt.Name, len(bt.Files) > 0, totalLength, textIndex)
+			"INSERT INTO infohash (infohash, name, files, file_count, length, addeded, updated, textindex, "+
+				"title, year, season, episode, resolution, video_codec, audio_codec, source, release_group, languages, category) "+
+				"VALUES (?, ?, ?, ?, ?, NOW(), NOW(), ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			rec.InfoHash, rec.Name, len(rec.Files) > 0, rec.FileCount(), rec.Length, rec.TextIndex,
+			ri.Title, ri.Year, ri.Season, ri.Episode, ri.Resolution, ri.VideoCodec, ri.AudioCodec, ri.Source,
+			ri.Group, strings.Join(ri.Languages, ","), rec.Category)
 		if err != nil {
 			return fmt.Errorf("插入记录失败: %v", err)
 		}
@@ -209,23 +175,24 @@ func processTorrent(ctx context.Context, bt *bitTorrent) error {
 			return fmt.Errorf("获取插入ID失败: %v", err)
 		}
 
+		// 保存原始元数据，供导出 .torrent 使用
+		if len(rec.Metadata) > 0 {
+			if _, err := tx.ExecContext(ctx, "INSERT INTO metadata (infohash_id, info) VALUES (?, ?)", id, rec.Metadata); err != nil {
+				return fmt.Errorf("插入元数据失败: %v", err)
+			}
+		}
+
 		// 插入文件信息
-		if len(bt.Files) > 0 {
-			stmt, err := tx.PrepareContext(ctx, "INSERT INTO files (infohash_id, path, length) VALUES (?, ?, ?)")
+		if len(rec.Files) > 0 {
+			stmt, err := tx.PrepareContext(ctx, "INSERT INTO files (infohash_id, idx, path, path_hash, length) VALUES (?, ?, ?, ?, ?)")
 			if err != nil {
 				return fmt.Errorf("准备文件插入语句失败: %v", err)
 			}
 			defer stmt.Close()
 
-			for _, f := range bt.Files {
-				path := ""
-				for i, p := range f.Path {
-					if i > 0 {
-						path += "/"
-					}
-					path += p.(string)
-				}
-				if _, err := stmt.ExecContext(ctx, id, path, f.Length); err != nil {
+			// idx 记录文件在种子中的原始顺序
+			for i, f := range rec.Files {
+				if _, err := stmt.ExecContext(ctx, id, i, f.Path, pathHash(f.Path), f.Length); err != nil {
 					return fmt.Errorf("插入文件信息失败: %v", err)
 				}
 			}
@@ -243,7 +210,82 @@ func processTorrent(ctx context.Context, bt *bitTorrent) error {
 	return tx.Commit()
 }
 
-func main() {
+// 嵌入式存储，写入数据目录下的记录日志，由 webinterface 建立索引
+type embeddedStore struct {
+	w *embedded.Writer
+}
+
+func (s *embeddedStore) SaveTorrent(ctx context.Context, bt *crawler.Torrent) error {
+	rec := crawler.NewRecord(bt, tok)
+	ri := rec.Release
+
+	doc := &embedded.Document{
+		InfoHash:     rec.InfoHash,
+		Name:         rec.Name,
+		Length:       int64(rec.Length),
+		Files:        make([]embedded.File, len(rec.Files)),
+		TextIndex:    rec.TextIndex,
+		Title:        ri.Title,
+		Year:         ri.Year,
+		Season:       ri.Season,
+		Episode:      ri.Episode,
+		Resolution:   ri.Resolution,
+		VideoCodec:   ri.VideoCodec,
+		AudioCodec:   ri.AudioCodec,
+		Source:       ri.Source,
+		ReleaseGroup: ri.Group,
+		Languages:    ri.Languages,
+		Category:     rec.Category,
+	}
+	for i, f := range rec.Files {
+		doc.Files[i] = embedded.File{Path: f.Path, Length: int64(f.Length)}
+	}
+
+	isNew, err := s.w.Save(doc)
+	if err != nil {
+		return fmt.Errorf("写入记录失败: %v", err)
+	}
+	if isNew {
+		l.Printf("新增种子: %s, 文件数: %d", bt.InfoHash, len(bt.Files))
+	} else {
+		l.Printf("更新种子: %s", bt.InfoHash)
+	}
+	return nil
+}
+
+func (s *embeddedStore) Exists(ctx context.Context, infoHash string) (bool, error) {
+	return s.w.Exists(infoHash), nil
+}
+
+// 按 search.backend 打开存储：embedded 时写入本地数据目录，否则写入 MySQL
+func openStore() (crawler.Store, func() error, error) {
+	if backend, _ := cfg.String("search.backend"); backend == "embedded" {
+		dir, _ := cfg.String("embedded.path")
+		if dir == "" {
+			dir = "data"
+		}
+		w, err := embedded.OpenWriter(dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("打开数据目录失败: %v", err)
+		}
+		l.Println("嵌入式模式，数据目录:", dir)
+		return &embeddedStore{w: w}, w.Close, nil
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &mysqlStore{db: db}, db.Close, nil
+}
+
+// 爬取 DHT 网络
+func runCrawl() error {
+	store, closeStore, err := openStore()
+	if err != nil {
+		return err
+	}
+
 	// 创建上下文和取消函数
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -252,65 +294,15 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-	// 启动DHT网络
-	w := dht.NewWire(65536, 1024, 256)
+	c := crawler.New(store, l)
 
 	// 使用WaitGroup管理goroutine
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	// 处理DHT响应
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case resp := <-w.Response():
-				metadata, err := dht.Decode(resp.MetadataInfo)
-				if err != nil {
-					l.Printf("解码元数据失败: %v", err)
-					continue
-				}
-
-				info, ok := metadata.(map[string]interface{})
-				if !ok {
-					l.Printf("元数据类型错误")
-					continue
-				}
-
-				if _, ok := info["name"]; !ok {
-					continue
-				}
-
-				bt := bitTorrent{
-					InfoHash: hex.EncodeToString(resp.InfoHash),
-					Name:     info["name"].(string),
-				}
-
-				// 处理文件信息
-				if v, ok := info["files"]; ok {
-					vFiles := v.([]interface{})
-					bt.Files = make([]file, len(vFiles))
-					for i, item := range vFiles {
-						f := item.(map[string]interface{})
-						bt.Files[i] = file{
-							Path:   f["path"].([]interface{}),
-							Length: f["length"].(int),
-						}
-					}
-				} else if v, ok := info["length"]; ok {
-					bt.Length = v.(int)
-				}
-
-				// 使用重试机制处理种子信息
-				if err := withRetry(func() error {
-					return processTorrent(ctx, &bt)
-				}); err != nil {
-					l.Printf("处理种子失败: %v", err)
-				}
-			}
-		}
+		c.HandleResponses(ctx)
 	}()
 
 	// 监听信号
@@ -321,20 +313,16 @@ func main() {
 	}()
 
 	// 启动DHT爬虫
-	go w.Run()
+	go c.Wire.Run()
 
 	// DHT配置
-	c := dht.NewCrawlConfig()
-	c.Address = ":" + port
-	c.PrimeNodes = append(c.PrimeNodes, "router.bitcomet.com:6881")
-
-	c.OnAnnouncePeer = func(infoHash, ip string, port int) {
-		// 收到新的peer通知
-		w.Request([]byte(infoHash), ip, port)
-	}
+	dc := dht.NewCrawlConfig()
+	dc.Address = ":" + port
+	dc.PrimeNodes = append(dc.PrimeNodes, "router.bitcomet.com:6881")
+	dc.OnAnnouncePeer = c.OnAnnouncePeer
 
 	// 启动DHT服务
-	d := dht.New(c)
+	d := dht.New(dc)
 	go d.Run()
 
 	l.Println("DHT爬虫已启动，使用端口:", port)
@@ -343,8 +331,270 @@ func main() {
 	wg.Wait()
 
 	// 关闭资源
-	if err := db.Close(); err != nil {
-		l.Printf("关闭数据库连接失败: %v", err)
+	if err := closeStore(); err != nil {
+		l.Printf("关闭存储失败: %v", err)
+	}
+	return nil
+}
+
+// 将导入记录转换为种子信息
+func importedTorrent(item importer.Item) (*crawler.Torrent, error) {
+	if item.Kind == importer.KindInfo {
+		return crawler.Decode(item.InfoHash, item.Data)
+	}
+	return crawler.ParseDump(item.Data)
+}
+
+// 批量导入 .torrent 文件、tar 包及 JSONL/bencode 转储
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	workers := fs.Int("workers", runtime.NumCPU(), "并发数")
+	checkpoint := fs.String("checkpoint", "import.checkpoint", "断点文件，记录每个来源文件已导入的记录数，为空时不记录")
+	skipExisting := fs.Bool("skip-existing", true, "跳过已入库的种子，为 false 时与爬虫一样更新 cnt")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "用法: spider import [参数] 路径...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("缺少导入路径")
+	}
+
+	store, closeStore, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+
+	stats, err := importer.Run(ctx, fs.Args(), importer.Options{
+		Workers:    *workers,
+		Checkpoint: *checkpoint,
+		Logger:     l,
+	}, func(ctx context.Context, item importer.Item) error {
+		bt, err := importedTorrent(item)
+		if err != nil {
+			// 解析失败的记录重试也不会成功，断点越过它
+			return fmt.Errorf("%w: %v", importer.ErrInvalid, err)
+		}
+
+		if *skipExisting {
+			found, err := store.Exists(ctx, bt.InfoHash)
+			if err != nil {
+				return err
+			}
+			if found {
+				return importer.ErrSkipped
+			}
+		}
+
+		return crawler.Retry(l, func() error {
+			return store.SaveTorrent(ctx, bt)
+		})
+	})
+
+	l.Printf("导入结束: 来源 %d, 新增 %d, 跳过 %d, 失败 %d, 耗时 %s",
+		stats.Sources, stats.Imported, stats.Skipped, stats.Failed, time.Since(start).Round(time.Second))
+	return err
+}
+
+// 导出为 JSONL、CSV 或 .torrent 的 tar 包，指定水位文件时只导出上次之后更新的记录
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", exporter.FormatJSONL, "导出格式: jsonl、csv 或 torrent")
+	out := fs.String("out", "-", "输出文件，- 为标准输出，以 .gz 结尾时 gzip 压缩")
+	from := fs.String("from", "", "收录日期下限，如 2024-01-01")
+	to := fs.String("to", "", "收录日期上限（包含当天），如 2024-06-30")
+	category := fs.String("category", "", "分类: "+strings.Join(release.Categories, "、"))
+	minCnt := fs.Int("min-cnt", 0, "最小热度 (cnt)")
+	watermark := fs.String("watermark", "", "水位文件，记录上次导出的位置，为空时全量导出")
+	fs.Parse(args)
+
+	var w io.Writer = os.Stdout
+	if *out == "-" {
+		// 标准输出留给导出数据
+		l.SetOutput(os.Stderr)
+	} else {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	bw := bufio.NewWriterSize(w, 1<<20)
+	w = bw
+	var gz *gzip.Writer
+	if strings.HasSuffix(*out, ".gz") {
+		gz = gzip.NewWriter(bw)
+		w = gz
+	}
+
+	db, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	start := time.Now()
+	stats, err := exporter.Run(ctx, db, w, exporter.Options{
+		Format:    *format,
+		From:      *from,
+		To:        *to,
+		Category:  *category,
+		MinCnt:    *minCnt,
+		Watermark: *watermark,
+		Logger:    l,
+	})
+	if err != nil {
+		return err
+	}
+
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	// 输出全部写出后才更新水位
+	if err := exporter.WriteWatermark(*watermark, stats.Watermark); err != nil {
+		return err
+	}
+
+	l.Printf("导出结束: %d 条, 跳过 %d 条 (无元数据), 耗时 %s",
+		stats.Exported, stats.Skipped, time.Since(start).Round(time.Second))
+	return nil
+}
+
+// 离线自测：本地假节点提供元数据，假 announce 触发爬虫，结果写入内存存储
+func runSelfTest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 30*time.Second, "等待元数据下载的超时时间")
+	fs.Parse(args)
+
+	peer, err := harness.NewFakePeer()
+	if err != nil {
+		return fmt.Errorf("启动本地节点失败: %v", err)
+	}
+	defer peer.Close()
+
+	// 指定 .torrent 文件时使用文件内容，否则使用内置样例
+	var hashes [][]byte
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		h, err := peer.AddTorrent(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		hashes = append(hashes, h)
+	}
+	if len(hashes) == 0 {
+		samples, err := selfTestSamples()
+		if err != nil {
+			return err
+		}
+		for _, info := range samples {
+			hashes = append(hashes, peer.AddInfo(info))
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	store := crawler.NewMemoryStore(tok, l)
+	c := crawler.New(store, l)
+	go c.Wire.Run()
+	go c.HandleResponses(ctx)
+
+	announcer := harness.FakeAnnouncer{OnAnnouncePeer: c.OnAnnouncePeer}
+	for _, h := range hashes {
+		announcer.Announce(h, peer)
+	}
+
+	// 等待全部种子入库
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		missing := 0
+		for _, h := range hashes {
+			if _, ok := store.Get(hex.EncodeToString(h)); !ok {
+				missing++
+			}
+		}
+		if missing == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("自测超时，%d/%d 个种子未入库", missing, len(hashes))
+		case <-ticker.C:
+		}
+	}
+
+	for _, h := range hashes {
+		rec, _ := store.Get(hex.EncodeToString(h))
+		l.Printf("自测通过: %s %q 文件数: %d 大小: %d 索引: %q",
+			rec.InfoHash, rec.Name, len(rec.Files), rec.Length, rec.TextIndex)
+	}
+	return nil
+}
+
+// 自测内置样例
+func selfTestSamples() ([][]byte, error) {
+	single, err := harness.SampleInfo("Show.Name.S02E05.1080p.WEB-DL.x265.HEVC-GROUP.mkv", 1<<30, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	multi, err := harness.SampleInfo("[字幕组] 进击的巨人 第二季 1080p", 0, []harness.SampleFile{
+		{Path: "第01集.mkv", Length: 500 << 20},
+		{Path: "第02集.mkv", Length: 510 << 20},
+		{Path: "extras/Making.Of 😀.mp4", Length: 80 << 20},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{single, multi}, nil
+}
+
+func main() {
+	flag.Parse()
+	port = *portPtr
+
+	var err error
+	switch cmd := flag.Arg(0); cmd {
+	case "", "crawl":
+		err = runCrawl()
+	case "import":
+		err = runImport(flag.Args()[1:])
+	case "export":
+		err = runExport(flag.Args()[1:])
+	case "selftest":
+		err = runSelfTest(flag.Args()[1:])
+	default:
+		err = fmt.Errorf("未知命令: %s", cmd)
+	}
+
+	if err != nil {
+		l.Fatalln(err)
 	}
 	l.Println("程序已关闭")
 }
@@ -0,0 +1,118 @@
+// 搜索框输入补全：为带 data-suggest 属性的输入框显示 /api/suggest 返回的建议
+(function ($) {
+    'use strict';
+
+    var DELAY = 150; // 停止输入后多久发起请求，毫秒
+    var MIN_LENGTH = 2;
+
+    function attach($input) {
+        var $menu = $('<ul class="dropdown-menu suggest-menu"></ul>');
+        var timer = null;
+        var xhr = null;
+        var active = -1;
+
+        $input.attr('autocomplete', 'off');
+        $input.wrap('<span class="suggest"></span>').after($menu);
+
+        function items() {
+            return $menu.children('li');
+        }
+
+        function hide() {
+            $menu.hide().empty();
+            active = -1;
+        }
+
+        function select(i) {
+            var $items = items();
+            $items.removeClass('active');
+            active = i;
+            if (i >= 0) {
+                $items.eq(i).addClass('active');
+            }
+        }
+
+        function choose(text) {
+            $input.val(text);
+            hide();
+            $input.closest('form').submit();
+        }
+
+        function show(list) {
+            $menu.empty();
+            active = -1;
+            if (!list.length) {
+                $menu.hide();
+                return;
+            }
+            $.each(list, function (i, s) {
+                var icon = s.type === 'query' ? 'glyphicon-search' : 'glyphicon-magnet';
+                var $a = $('<a href="#"></a>')
+                    .append($('<span class="glyphicon text-muted"></span>').addClass(icon))
+                    .append(' ')
+                    .append(document.createTextNode(s.text));
+                $a.on('mousedown', function (e) {
+                    e.preventDefault();
+                    choose(s.text);
+                });
+                $menu.append($('<li></li>').data('text', s.text).append($a));
+            });
+            $menu.show();
+        }
+
+        function fetch() {
+            var q = $input.val();
+            if (xhr) {
+                xhr.abort();
+            }
+            if ($.trim(q).length < MIN_LENGTH) {
+                hide();
+                return;
+            }
+            xhr = $.getJSON('/api/suggest', {q: q}, function (res) {
+                if (res.query === $input.val()) {
+                    show(res.suggestions);
+                }
+            });
+        }
+
+        $input.on('input', function () {
+            clearTimeout(timer);
+            timer = setTimeout(fetch, DELAY);
+        });
+
+        $input.on('keydown', function (e) {
+            var n = items().length;
+            if (!$menu.is(':visible') || n === 0) {
+                return;
+            }
+            switch (e.which) {
+            case 38: // 上
+                e.preventDefault();
+                select(active <= 0 ? n - 1 : active - 1);
+                break;
+            case 40: // 下
+                e.preventDefault();
+                select(active >= n - 1 ? 0 : active + 1);
+                break;
+            case 13: // 回车
+                if (active >= 0) {
+                    e.preventDefault();
+                    choose(items().eq(active).data('text'));
+                }
+                break;
+            case 27: // Esc
+                hide();
+                break;
+            }
+        });
+
+        $input.on('blur', hide);
+    }
+
+    $(function () {
+        $('input[data-suggest]').each(function () {
+            attach($(this));
+        });
+    });
+})(jQuery);
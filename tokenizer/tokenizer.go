@@ -0,0 +1,179 @@
+// Package tokenizer 为 textindex 生成多语言分词结果，
+// 同时供 MySQL FULLTEXT 与 Elasticsearch 使用。
+package tokenizer
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// DefaultStopWords 为未配置停用词时使用的默认列表
+var DefaultStopWords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "by", "for", "from",
+	"in", "is", "it", "of", "on", "or", "the", "to", "with",
+	"www", "com", "net", "org",
+}
+
+// Tokenizer 分词器
+type Tokenizer struct {
+	stopWords map[string]struct{}
+}
+
+// New 创建分词器，stopWords 为 nil 时使用 DefaultStopWords
+func New(stopWords []string) *Tokenizer {
+	if stopWords == nil {
+		stopWords = DefaultStopWords
+	}
+
+	t := &Tokenizer{stopWords: make(map[string]struct{}, len(stopWords))}
+	for _, w := range stopWords {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			t.stopWords[w] = struct{}{}
+		}
+	}
+	return t
+}
+
+// 字符类别
+const (
+	classOther = iota
+	classLetter
+	classDigit
+	classCJK
+)
+
+func classify(r rune) int {
+	switch {
+	case isCJK(r):
+		return classCJK
+	case unicode.IsLetter(r) || unicode.Is(unicode.M, r):
+		return classLetter
+	case unicode.IsDigit(r):
+		return classDigit
+	}
+	return classOther
+}
+
+// 中日韩文字没有空格分隔，使用二元切分。长音符 ー 属于通用字符，也算作假名
+func isCJK(r rune) bool {
+	return r == 'ー' || r == 'ｰ' || unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// Tokenize 将文本切分为小写词元，按出现顺序返回，可能包含重复
+func (t *Tokenizer) Tokenize(text string) []string {
+	var tokens []string
+
+	emit := func(tok string) {
+		if tok == "" {
+			return
+		}
+		if _, stop := t.stopWords[tok]; stop {
+			return
+		}
+		tokens = append(tokens, tok)
+	}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		class := classify(runes[i])
+		if class == classOther {
+			i++
+			continue
+		}
+
+		// 中日韩字符连续片段
+		if class == classCJK {
+			j := i
+			for j < len(runes) && classify(runes[j]) == classCJK {
+				j++
+			}
+			for _, tok := range bigrams(runes[i:j]) {
+				emit(tok)
+			}
+			i = j
+			continue
+		}
+
+		// 字母与数字组成的单词
+		j := i
+		for j < len(runes) {
+			c := classify(runes[j])
+			if c != classLetter && c != classDigit {
+				break
+			}
+			j++
+		}
+		word := runes[i:j]
+		parts := splitWord(word)
+		emit(strings.ToLower(string(word)))
+		if len(parts) > 1 {
+			for _, p := range parts {
+				emit(strings.ToLower(p))
+			}
+		}
+		i = j
+	}
+
+	return tokens
+}
+
+// Index 生成 textindex：词元去重后按词频降序排列，词频相同保持出现顺序
+func (t *Tokenizer) Index(text string) string {
+	tokens := t.Tokenize(text)
+
+	freq := make(map[string]int, len(tokens))
+	uniq := make([]string, 0, len(tokens))
+	for _, tok := range tokens {
+		if freq[tok] == 0 {
+			uniq = append(uniq, tok)
+		}
+		freq[tok]++
+	}
+
+	sort.SliceStable(uniq, func(i, j int) bool { return freq[uniq[i]] > freq[uniq[j]] })
+
+	return strings.Join(uniq, " ")
+}
+
+// 单字直接返回，多字按相邻两字切分
+func bigrams(run []rune) []string {
+	if len(run) == 1 {
+		return []string{string(run)}
+	}
+
+	res := make([]string, 0, len(run)-1)
+	for i := 0; i+1 < len(run); i++ {
+		res = append(res, string(run[i:i+2]))
+	}
+	return res
+}
+
+// 按驼峰及字母/数字边界拆分单词：
+// S01E02 -> S 01 E 02, x264 -> x 264, HEVCRemux -> HEVC Remux
+func splitWord(word []rune) []string {
+	var parts []string
+	start := 0
+
+	for i := 1; i < len(word); i++ {
+		prev, cur := word[i-1], word[i]
+		split := false
+
+		switch {
+		case unicode.IsDigit(prev) != unicode.IsDigit(cur):
+			split = true
+		case unicode.IsLower(prev) && unicode.IsUpper(cur):
+			split = true
+		case unicode.IsUpper(prev) && unicode.IsUpper(cur) &&
+			i+1 < len(word) && unicode.IsLower(word[i+1]):
+			split = true
+		}
+
+		if split {
+			parts = append(parts, string(word[start:i]))
+			start = i
+		}
+	}
+
+	return append(parts, string(word[start:]))
+}
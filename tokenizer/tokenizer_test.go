@@ -0,0 +1,66 @@
+package tokenizer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"latin", "Ubuntu Desktop", []string{"ubuntu", "desktop"}},
+		{"stop words", "The Lord of the Rings", []string{"lord", "rings"}},
+		{"cjk bigrams", "流浪地球", []string{"流浪", "浪地", "地球"}},
+		{"cjk single", "龙", []string{"龙"}},
+		{"kana and hangul", "ワンピース 기생충", []string{"ワン", "ンピ", "ピー", "ース", "기생", "생충"}},
+		{"mixed scripts", "流浪地球2 Wandering", []string{"流浪", "浪地", "地球", "2", "wandering"}},
+		{"episode", "S01E02", []string{"s01e02", "s", "01", "e", "02"}},
+		{"codec", "x264", []string{"x264", "x", "264"}},
+		{"camel case", "HEVCRemux", []string{"hevcremux", "hevc", "remux"}},
+		{"punctuation", "Movie.2019.1080p-GROUP[rarbg]", []string{"movie", "2019", "1080p", "1080", "p", "group", "rarbg"}},
+		{"empty", " .-_ ", nil},
+	}
+
+	tok := New(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tok.Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"dedup", "foo bar foo", "foo bar"},
+		{"frequency order", "one two two three three three", "three two one"},
+		{"ties keep order", "b d c", "b d c"},
+		{"episode parts", "Show S01E01 E01", "01 e show s01e01 s e01"},
+		{"cjk repeated", "你好你好", "你好 好你"},
+		{"empty", "", ""},
+	}
+
+	tok := New(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tok.Index(tt.text); got != tt.want {
+				t.Errorf("Index(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStopWords(t *testing.T) {
+	tok := New([]string{" Foo ", ""})
+	if got, want := tok.Tokenize("foo the bar"), []string{"the", "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,267 @@
+// Package torznab 实现 Torznab 索引器接口的响应格式和分类映射，
+// 供 Sonarr、Radarr、Jackett 等程序搜索。规范见 https://torznab.github.io/spec-1.3-draft/
+package torznab
+
+import (
+	"DHT-ES-Search/release"
+	"encoding/xml"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 响应的命名空间
+const (
+	NamespaceAtom    = "http://www.w3.org/2005/Atom"
+	NamespaceTorznab = "http://torznab.com/schemas/2015/feed"
+)
+
+// 错误码
+const (
+	ErrIncorrectCredentials = 100
+	ErrMissingParameter     = 200
+	ErrIncorrectParameter   = 201
+	ErrNoSuchFunction       = 202
+	ErrUnknown              = 900
+)
+
+// Newznab 标准分类
+const (
+	CategoryMovies    = 2000
+	CategoryMoviesSD  = 2030
+	CategoryMoviesHD  = 2040
+	CategoryMoviesUHD = 2045
+	CategoryAudio     = 3000
+	CategoryPC        = 4000
+	CategoryTV        = 5000
+	CategoryTVSD      = 5030
+	CategoryTVHD      = 5040
+	CategoryTVUHD     = 5045
+	CategoryBooks     = 7000
+	CategoryEbook     = 7020
+	CategoryOther     = 8000
+)
+
+// Category 一个分类及其子分类
+type Category struct {
+	ID      int      `xml:"id,attr"`
+	Name    string   `xml:"name,attr"`
+	Subcats []Subcat `xml:"subcat"`
+}
+
+// Subcat 子分类
+type Subcat struct {
+	ID   int    `xml:"id,attr"`
+	Name string `xml:"name,attr"`
+}
+
+// Categories caps 中列出的分类
+var Categories = []Category{
+	{ID: CategoryMovies, Name: "Movies", Subcats: []Subcat{
+		{CategoryMoviesSD, "Movies/SD"}, {CategoryMoviesHD, "Movies/HD"}, {CategoryMoviesUHD, "Movies/UHD"},
+	}},
+	{ID: CategoryAudio, Name: "Audio"},
+	{ID: CategoryPC, Name: "PC"},
+	{ID: CategoryTV, Name: "TV", Subcats: []Subcat{
+		{CategoryTVSD, "TV/SD"}, {CategoryTVHD, "TV/HD"}, {CategoryTVUHD, "TV/UHD"},
+	}},
+	{ID: CategoryBooks, Name: "Books", Subcats: []Subcat{{CategoryEbook, "Books/Ebook"}}},
+	{ID: CategoryOther, Name: "Other"},
+}
+
+// 本地分类对应的顶级分类，image、archive、other 以及旧数据的空分类都归入 Other
+var topCategories = map[string]int{
+	release.CategoryMovie:    CategoryMovies,
+	release.CategoryTV:       CategoryTV,
+	release.CategoryAudio:    CategoryAudio,
+	release.CategorySoftware: CategoryPC,
+	release.CategoryEbook:    CategoryBooks,
+}
+
+// CategoryIDs 返回种子所属的 Torznab 分类：顶级分类，视频再按清晰度加上子分类
+func CategoryIDs(category, resolution string) []int {
+	top, ok := topCategories[category]
+	if !ok {
+		return []int{CategoryOther}
+	}
+
+	ids := []int{top}
+	switch top {
+	case CategoryMovies, CategoryTV:
+		sub := top + 30 // SD
+		switch resolution {
+		case "2160p":
+			sub = top + 45
+		case "1080p", "1080i", "720p":
+			sub = top + 40
+		}
+		ids = append(ids, sub)
+	case CategoryBooks:
+		ids = append(ids, CategoryEbook)
+	}
+	return ids
+}
+
+// LocalCategories 把请求的 Torznab 分类换算为本地分类，子分类按所属的顶级分类。
+// 返回 nil 表示不限分类
+func LocalCategories(ids []int) []string {
+	set := make(map[string]bool)
+	for _, id := range ids {
+		top := id / 1000 * 1000
+		if top == CategoryOther {
+			set[release.CategoryImage] = true
+			set[release.CategoryArchive] = true
+			set[release.CategoryOther] = true
+			set[""] = true
+			continue
+		}
+		for c, t := range topCategories {
+			if t == top {
+				set[c] = true
+			}
+		}
+	}
+	if len(set) == 0 {
+		return nil
+	}
+
+	res := make([]string, 0, len(set))
+	for c := range set {
+		res = append(res, c)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ParseIDs 解析逗号分隔的分类参数，忽略无法解析的项
+func ParseIDs(s string) []int {
+	var ids []int
+	for _, f := range strings.Split(s, ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(f)); err == nil && id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Caps t=caps 的响应
+type Caps struct {
+	XMLName    xml.Name   `xml:"caps"`
+	Server     Server     `xml:"server"`
+	Limits     Limits     `xml:"limits"`
+	Searching  Searching  `xml:"searching"`
+	Categories []Category `xml:"categories>category"`
+}
+
+type Server struct {
+	Title string `xml:"title,attr"`
+}
+
+type Limits struct {
+	Max     int `xml:"max,attr"`
+	Default int `xml:"default,attr"`
+}
+
+type Searching struct {
+	Search      SearchCaps `xml:"search"`
+	TVSearch    SearchCaps `xml:"tv-search"`
+	MovieSearch SearchCaps `xml:"movie-search"`
+}
+
+// SearchCaps 一种搜索是否可用及支持的参数
+type SearchCaps struct {
+	Available       string `xml:"available,attr"` // yes 或 no
+	SupportedParams string `xml:"supportedParams,attr"`
+}
+
+// Feed 搜索结果，RSS 2.0 格式
+type Feed struct {
+	XMLName   xml.Name `xml:"rss"`
+	Version   string   `xml:"version,attr"`
+	AtomNS    string   `xml:"xmlns:atom,attr"`
+	TorznabNS string   `xml:"xmlns:torznab,attr"`
+	Channel   Channel  `xml:"channel"`
+}
+
+type Channel struct {
+	AtomLink    AtomLink `xml:"atom:link"`
+	Title       string   `xml:"title"`
+	Description string   `xml:"description"`
+	Link        string   `xml:"link"`
+	Response    Response `xml:"torznab:response"`
+	Items       []Item   `xml:"item"`
+}
+
+type AtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Response 本页在全部结果中的位置
+type Response struct {
+	Offset int `xml:"offset,attr"`
+	Total  int `xml:"total,attr"`
+}
+
+// Item 一个种子
+type Item struct {
+	Title     string    `xml:"title"`
+	GUID      GUID      `xml:"guid"`
+	Link      string    `xml:"link"`
+	Comments  string    `xml:"comments,omitempty"`
+	PubDate   string    `xml:"pubDate,omitempty"`
+	Size      int64     `xml:"size"`
+	Category  []int     `xml:"category"`
+	Enclosure Enclosure `xml:"enclosure"`
+	Attrs     []Attr    `xml:"torznab:attr"`
+}
+
+type GUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type Enclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// Attr torznab:attr 扩展属性
+type Attr struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// NewFeed 返回带命名空间的空结果
+func NewFeed(title, link, self string) *Feed {
+	return &Feed{
+		Version:   "2.0",
+		AtomNS:    NamespaceAtom,
+		TorznabNS: NamespaceTorznab,
+		Channel: Channel{
+			AtomLink:    AtomLink{Href: self, Rel: "self", Type: "application/rss+xml"},
+			Title:       title,
+			Description: title,
+			Link:        link,
+		},
+	}
+}
+
+// Error 错误响应
+type Error struct {
+	XMLName     xml.Name `xml:"error"`
+	Code        int      `xml:"code,attr"`
+	Description string   `xml:"description,attr"`
+}
+
+func (e *Error) Error() string {
+	return "torznab: " + strconv.Itoa(e.Code) + " " + e.Description
+}
+
+// Seeders 按热度估计做种数。DHT 中只能看到种子被请求的次数 cnt，以它作为估计，
+// 至少为 1，避免被客户端当作无人做种而过滤
+func Seeders(cnt int) int {
+	return max(cnt, 1)
+}
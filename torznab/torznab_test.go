@@ -0,0 +1,85 @@
+package torznab
+
+import (
+	"DHT-ES-Search/release"
+	"reflect"
+	"testing"
+)
+
+func TestCategoryIDs(t *testing.T) {
+	tests := []struct {
+		category, resolution string
+		want                 []int
+	}{
+		{release.CategoryMovie, "2160p", []int{CategoryMovies, CategoryMoviesUHD}},
+		{release.CategoryMovie, "1080p", []int{CategoryMovies, CategoryMoviesHD}},
+		{release.CategoryMovie, "", []int{CategoryMovies, CategoryMoviesSD}},
+		{release.CategoryTV, "720p", []int{CategoryTV, CategoryTVHD}},
+		{release.CategoryTV, "1080i", []int{CategoryTV, CategoryTVHD}},
+		{release.CategoryTV, "480p", []int{CategoryTV, CategoryTVSD}},
+		{release.CategoryAudio, "1080p", []int{CategoryAudio}},
+		{release.CategorySoftware, "", []int{CategoryPC}},
+		{release.CategoryEbook, "", []int{CategoryBooks, CategoryEbook}},
+		{release.CategoryImage, "", []int{CategoryOther}},
+		{release.CategoryArchive, "", []int{CategoryOther}},
+		{release.CategoryOther, "", []int{CategoryOther}},
+		// 旧数据没有分类
+		{"", "", []int{CategoryOther}},
+	}
+
+	for _, tt := range tests {
+		if got := CategoryIDs(tt.category, tt.resolution); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CategoryIDs(%q, %q) = %v, want %v", tt.category, tt.resolution, got, tt.want)
+		}
+	}
+}
+
+func TestLocalCategories(t *testing.T) {
+	other := []string{"", release.CategoryArchive, release.CategoryImage, release.CategoryOther}
+
+	tests := []struct {
+		name string
+		ids  []int
+		want []string
+	}{
+		{"none", nil, nil},
+		{"movies", []int{CategoryMovies}, []string{release.CategoryMovie}},
+		// 子分类按所属的顶级分类
+		{"subcategory", []int{CategoryTVHD}, []string{release.CategoryTV}},
+		{"several", []int{CategoryMoviesUHD, CategoryTVSD, CategoryAudio}, []string{release.CategoryAudio, release.CategoryMovie, release.CategoryTV}},
+		{"duplicates", []int{CategoryTV, CategoryTVHD, CategoryTVUHD}, []string{release.CategoryTV}},
+		{"books", []int{CategoryEbook}, []string{release.CategoryEbook}},
+		{"pc", []int{CategoryPC}, []string{release.CategorySoftware}},
+		{"other", []int{CategoryOther}, other},
+		{"other subcategory", []int{8010}, other},
+		// 没有对应本地分类的 Newznab 分类
+		{"unknown", []int{6000}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LocalCategories(tt.ids); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LocalCategories(%v) = %q, want %q", tt.ids, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseIDs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []int
+	}{
+		{"", nil},
+		{"2000", []int{2000}},
+		{"2000,5040", []int{2000, 5040}},
+		{" 2000 , 5040 ", []int{2000, 5040}},
+		{"2000,,abc,-1,0,5040", []int{2000, 5040}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseIDs(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseIDs(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
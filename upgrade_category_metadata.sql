@@ -0,0 +1,17 @@
+-- --------------------------------------------------------
+-- 增加种子分类字段和原始元数据表
+-- category 由爬虫/导入在入库时根据文件类型计算
+-- metadata 保存 info 字典原始字节，供导出 .torrent 使用
+-- --------------------------------------------------------
+
+USE `dhtbt`;
+
+ALTER TABLE `infohash`
+  ADD COLUMN `category` varchar(16) NOT NULL DEFAULT '' AFTER `languages`,
+  ADD KEY `category` (`category`);
+
+CREATE TABLE IF NOT EXISTS `metadata` (
+  `infohash_id` int(11) NOT NULL,
+  `info` mediumblob NOT NULL,
+  PRIMARY KEY (`infohash_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
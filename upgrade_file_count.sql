@@ -0,0 +1,16 @@
+-- --------------------------------------------------------
+-- 增加文件数字段，供高级搜索 files: 过滤使用
+-- 单文件种子的文件数为 1，多文件种子按 files 表回填
+-- length 索引供 size: 过滤使用
+-- --------------------------------------------------------
+
+USE `dhtbt`;
+
+ALTER TABLE `infohash`
+  ADD COLUMN `file_count` int(11) NOT NULL DEFAULT '1' AFTER `files`,
+  ADD KEY `file_count` (`file_count`),
+  ADD KEY `length` (`length`);
+
+UPDATE `infohash` i
+  JOIN (SELECT `infohash_id`, COUNT(*) AS n FROM `files` GROUP BY `infohash_id`) f ON f.`infohash_id` = i.`id`
+  SET i.`file_count` = f.n;
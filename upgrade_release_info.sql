@@ -0,0 +1,22 @@
+-- --------------------------------------------------------
+-- 为 infohash 增加发布信息字段
+-- 由爬虫在入库时解析种子名称写入，旧数据保持默认值
+-- --------------------------------------------------------
+
+USE `dhtbt`;
+
+ALTER TABLE `infohash`
+  ADD COLUMN `title` text NOT NULL AFTER `textindex`,
+  ADD COLUMN `year` smallint(6) NOT NULL DEFAULT '0' AFTER `title`,
+  ADD COLUMN `season` smallint(6) NOT NULL DEFAULT '0' AFTER `year`,
+  ADD COLUMN `episode` int(11) NOT NULL DEFAULT '0' AFTER `season`,
+  ADD COLUMN `resolution` varchar(16) NOT NULL DEFAULT '' AFTER `episode`,
+  ADD COLUMN `video_codec` varchar(16) NOT NULL DEFAULT '' AFTER `resolution`,
+  ADD COLUMN `audio_codec` varchar(16) NOT NULL DEFAULT '' AFTER `video_codec`,
+  ADD COLUMN `source` varchar(16) NOT NULL DEFAULT '' AFTER `audio_codec`,
+  ADD COLUMN `release_group` varchar(255) NOT NULL DEFAULT '' AFTER `source`,
+  ADD COLUMN `languages` varchar(64) NOT NULL DEFAULT '' AFTER `release_group`,
+  ADD KEY `year` (`year`),
+  ADD KEY `season_episode` (`season`, `episode`),
+  ADD KEY `resolution` (`resolution`),
+  ADD KEY `source` (`source`);
@@ -0,0 +1,38 @@
+-- --------------------------------------------------------
+-- 已有数据库升级到 utf8mb4
+-- 适用于按旧版 dhtbt.sql (utf8, varchar(250)) 建立的库
+-- 执行前请先备份数据
+-- --------------------------------------------------------
+
+USE `dhtbt`;
+
+ALTER DATABASE `dhtbt` CHARACTER SET utf8mb4;
+
+-- infohash: name 改为 text，整表转换为 utf8mb4
+ALTER TABLE `infohash` ROW_FORMAT=DYNAMIC;
+ALTER TABLE `infohash` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `infohash` MODIFY `name` text NOT NULL;
+
+-- files: 增加主键、文件序号和路径哈希，path 改为 text
+ALTER TABLE `files` ROW_FORMAT=DYNAMIC;
+ALTER TABLE `files` CONVERT TO CHARACTER SET utf8mb4;
+ALTER TABLE `files`
+  ADD COLUMN `id` bigint(20) NOT NULL AUTO_INCREMENT FIRST,
+  ADD PRIMARY KEY (`id`),
+  ADD COLUMN `idx` int(11) NOT NULL DEFAULT '0' AFTER `infohash_id`,
+  MODIFY `path` text NOT NULL,
+  ADD COLUMN `path_hash` char(40) NOT NULL DEFAULT '' AFTER `path`;
+
+-- 旧数据没有文件顺序，按写入顺序 (id) 补齐序号
+SET @prev := 0, @n := 0;
+UPDATE `files`
+SET `idx` = (@n := IF(@prev = `infohash_id`, @n + 1, 0)),
+    `infohash_id` = (@prev := `infohash_id`)
+ORDER BY `infohash_id`, `id`;
+
+UPDATE `files` SET `path_hash` = SHA1(`path`);
+
+ALTER TABLE `files`
+  DROP KEY `infohash_id`,
+  ADD UNIQUE KEY `infohash_id_idx` (`infohash_id`, `idx`),
+  ADD KEY `path_hash` (`path_hash`);
@@ -1,10 +1,26 @@
 package main
 
 import (
+	"DHT-ES-Search/cursor"
+	"DHT-ES-Search/embedded"
+	"DHT-ES-Search/esindex"
+	"DHT-ES-Search/feed"
+	"DHT-ES-Search/querylang"
+	"DHT-ES-Search/search"
+	"DHT-ES-Search/tokenizer"
+	"DHT-ES-Search/torznab"
 	"bytes"
 	"context"
+	"crypto/sha1"
+	"crypto/subtle"
 	"database/sql"
+	_ "embed"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
 	"github.com/elastic/go-elasticsearch/v8"
 	_ "github.com/go-sql-driver/mysql"
@@ -13,29 +29,37 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"sort"
+	"os/signal"
+	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 // 数据结构定义
 type (
 	file struct {
-		Path   string
-		Length string
+		Path     string
+		PathHTML template.HTML // 带高亮的路径
+		Length   string
 	}
 
 	Files []file
 
 	bitTorrent struct {
-		Id        int64
-		InfoHash  string
-		Name      string
-		HaveFiles bool
-		Files     []file
-		Length    string
+		Id           int64
+		InfoHash     string
+		Name         string
+		NameHTML     template.HTML // 带高亮的名称，没有命中时为转义后的名称
+		HaveFiles    bool
+		Files        []file
+		MatchedFiles []file // 搜索时命中的文件
+		Length       string
 	}
 
 	MainData struct {
@@ -46,15 +70,99 @@ type (
 	}
 
 	SearchData struct {
-		Title      string       // 页面标题
-		Query      string       // 搜索关键词
-		Order      string       // 排序方式
-		Founded    []bitTorrent // 搜索结果
-		Page       int          // 当前页码
-		TotalPages int          // 总页数
-		TotalCount int          // 搜索结果数
-		PrevSort   string       // 上一页的 sort 值
-		NextSort   string       // 下一页的 sort 值
+		Title      string             // 页面标题
+		Query      string             // 搜索关键词
+		Order      string             // 排序方式
+		Dir        string             // 排序方向，asc 或 desc
+		Sorts      []sortOption       // 排序切换链接
+		Founded    []bitTorrent       // 搜索结果
+		Page       int                // 当前页码
+		TotalPages int                // 总页数
+		TotalCount int                // 搜索结果数
+		PrevURL    string             // 上一页链接，第一页时为空
+		NextURL    string             // 下一页链接，最后一页时为空
+		PageLink   func(n int) string // 第 n 页的链接
+		JumpPages  int                // 页码链接能跳到的最大页码
+		Expired    bool               // 翻页快照已过期，结果可能已经变化
+		Filter     ReleaseFilter
+		Options    releaseOptions
+		Error      string // 查询语法错误
+		Corrected  string // 结果很少时拼写纠正后的查询，提示 Did you mean
+		Original   string // 原查询没有结果、已改用纠正后的查询时为原查询
+	}
+
+	// searchPage 一页搜索结果及分页状态，由 searchTorrents 返回
+	searchPage struct {
+		Query      string
+		Order      string
+		Asc        bool
+		HasText    bool // 查询中有关键词，可以按相关度排序
+		Filter     ReleaseFilter
+		Result     search.Result
+		Page       int
+		TotalPages int
+		Jumped     bool   // 按页码跳页，没有游标位置
+		Expired    bool   // 翻页快照已过期，已在新快照上查询
+		Corrected  string // 拼写纠正后的查询，结果更多但未采用
+		Original   string // 原查询没有结果、已改用纠正后的查询时为原查询
+	}
+
+	// JSON 接口返回的种子，大小以字节计，时间为 RFC 3339
+	apiTorrent struct {
+		ID           int64     `json:"id"`
+		InfoHash     string    `json:"infohash"`
+		Name         string    `json:"name"`
+		Size         int64     `json:"size"`
+		FileCount    int       `json:"file_count"`
+		Added        string    `json:"added,omitempty"`
+		Updated      string    `json:"updated,omitempty"`
+		Popularity   int       `json:"popularity"` // 被请求的次数
+		Magnet       string    `json:"magnet"`
+		MatchedFiles []apiFile `json:"matched_files,omitempty"` // 搜索时命中的文件
+	}
+
+	apiFile struct {
+		Path string `json:"path"`
+		Size int64  `json:"size"`
+	}
+
+	// GET /api/v1/search 的响应
+	apiSearchResult struct {
+		Query      string       `json:"query"`
+		Order      string       `json:"order"`
+		Dir        string       `json:"dir"`
+		Total      int          `json:"total"`
+		Page       int          `json:"page"`
+		TotalPages int          `json:"total_pages"`
+		Results    []apiTorrent `json:"results"`
+		PrevCursor string       `json:"prev_cursor,omitempty"`
+		NextCursor string       `json:"next_cursor,omitempty"`
+		Corrected  string       `json:"corrected,omitempty"`
+		Original   string       `json:"original,omitempty"`
+		Expired    bool         `json:"snapshot_expired,omitempty"`
+	}
+
+	// 排序切换链接：当前排序再次点击时切换方向
+	sortOption struct {
+		Order  string
+		Label  string
+		Dir    string // 链接使用的方向
+		Active bool
+		Asc    bool // 当前方向，仅 Active 时有意义
+	}
+
+	// 发布信息、大小、日期和文件数过滤条件，零值表示不过滤
+	ReleaseFilter struct {
+		search.Filter
+	}
+
+	// 搜索表单的下拉选项
+	releaseOptions struct {
+		Resolutions []string
+		Sources     []string
+		VideoCodecs []string
+		AudioCodecs []string
+		Languages   []string
 	}
 
 	DetailData struct {
@@ -69,10 +177,16 @@ type (
 type AppConfig struct {
 	DB            *sql.DB
 	ES            *elasticsearch.Client
+	Index         string // ES 读别名，指向当前版本的索引
+	Search        search.Backend
+	Queries       *search.QueryStats // 有结果的搜索关键词，用于输入补全
+	Cursors       *cursor.Signer     // 签名翻页游标
+	TorznabKey    string             // Torznab 接口的 API key，为空时不可用
 	Logger        *log.Logger
 	Config        *config.Config
 	BindPort      string
 	BindInterface string
+	DebugAddr     string // 调试接口 /debug/vars 的监听地址，只绑定本机，为空时不开启
 	Templates     *Templates
 }
 
@@ -87,10 +201,200 @@ const (
 	configFileName = "config.json"
 )
 
-// Files 排序接口实现
-func (slice Files) Len() int           { return len(slice) }
-func (slice Files) Less(i, j int) bool { return slice[i].Path < slice[j].Path }
-func (slice Files) Swap(i, j int)      { slice[i], slice[j] = slice[j], slice[i] }
+// 输入补全
+const (
+	suggestMinLength  = 2                     // 少于这么多字符时不查询
+	suggestLimit      = 8                     // 最多返回的建议数
+	suggestQueries    = 3                     // 其中热门关键词的条数
+	suggestTimeout    = 50 * time.Millisecond // 超时后返回已有的建议
+	suggestQueryStats = 10000                 // 最多记录的关键词数
+)
+
+// 第一页结果少于这么多条时尝试拼写纠正
+const correctThreshold = 3
+
+// 每页结果数
+const pageSize = 30
+
+// 最新种子和热门种子订阅中的种子数
+const feedLength = 50
+
+// Torznab 每次最多返回的结果数
+const torznabMaxLimit = 100
+
+// 列表接口默认和最多返回的种子数
+const (
+	apiListLimit    = 50
+	apiListMaxLimit = 100
+)
+
+// infohash 为 40 位小写十六进制
+var infoHashPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// JSON 接口的 OpenAPI 文档
+//
+//go:embed api/openapi.json
+var openAPIDoc []byte
+
+// 页码链接最远能跳到的结果数，更远的页只能逐页翻
+const maxJumpResults = 100000
+
+// 调试接口的默认监听地址，只接受本机连接
+const defaultDebugAddr = "127.0.0.1:6060"
+
+// 查询结果缓存的默认条数和保存秒数
+const (
+	defaultCacheSize = 1000
+	defaultCacheTTL  = 30
+)
+
+var searchOptions = releaseOptions{
+	Resolutions: []string{"2160p", "1080p", "720p", "576p", "480p"},
+	Sources:     []string{"BluRay", "WEB", "HDTV", "DVD", "CAM"},
+	VideoCodecs: []string{"H.264", "H.265", "AV1", "VP9", "XviD", "DivX"},
+	AudioCodecs: []string{"AAC", "AC3", "EAC3", "DTS", "DTS-HD", "TrueHD", "Atmos", "FLAC", "MP3", "Opus"},
+	Languages:   []string{"en", "zh", "ja", "ko", "ru", "fr", "de", "es", "it", "multi"},
+}
+
+var orderLabels = map[string]string{
+	search.OrderRelevance: "Relevance",
+	search.OrderCnt:       "Popularity",
+	search.OrderUpdated:   "Updated",
+	search.OrderAdded:     "Added",
+	search.OrderSize:      "Size",
+	search.OrderName:      "Name",
+	search.OrderFiles:     "Files",
+}
+
+// 从查询参数中读取排序方式和方向。有关键词时默认按相关度，否则按更新时间；未指定方向时使用该排序的默认方向
+func parseOrder(form url.Values, query string) (string, bool) {
+	hasText := false
+	if expr, err := querylang.Parse(query); err == nil {
+		hasText = expr.HasText()
+	}
+
+	order := form.Get("order")
+	if !search.ValidOrder(order) || order == search.OrderRelevance && !hasText {
+		order = search.OrderUpdated
+		if hasText {
+			order = search.OrderRelevance
+		}
+	}
+
+	switch form.Get("dir") {
+	case "asc":
+		return order, true
+	case "desc":
+		return order, false
+	}
+	return order, search.DefaultAsc(order)
+}
+
+// 搜索第一页的链接
+func searchURL(query, order string, asc bool, filter ReleaseFilter) string {
+	v := filter.Values()
+	v.Set("q", query)
+	v.Set("order", order)
+	v.Set("dir", sortDir(asc))
+	return "/search/?" + v.Encode()
+}
+
+// 第 n 页的链接，first 为 searchURL 返回的第一页地址
+func pageURL(first string, n int) string {
+	if n <= 1 {
+		return first
+	}
+	return first + "&page=" + strconv.Itoa(n)
+}
+
+func sortDir(asc bool) string {
+	if asc {
+		return "asc"
+	}
+	return "desc"
+}
+
+// 排序切换链接，没有关键词时不提供相关度
+func sortOptions(order string, asc, hasText bool) []sortOption {
+	var res []sortOption
+	for _, o := range search.Orders {
+		if o == search.OrderRelevance && !hasText {
+			continue
+		}
+		opt := sortOption{Order: o, Label: orderLabels[o], Dir: sortDir(search.DefaultAsc(o))}
+		if o == order {
+			opt.Active, opt.Asc, opt.Dir = true, asc, sortDir(!asc)
+		}
+		res = append(res, opt)
+	}
+	return res
+}
+
+// 从查询参数中读取过滤条件，无法解析的值视为不过滤
+func parseReleaseFilter(form url.Values) ReleaseFilter {
+	atoi := func(key string) int {
+		n, err := strconv.Atoi(form.Get(key))
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	size := func(key string) int64 {
+		n, err := querylang.ParseSize(form.Get(key))
+		if err != nil || n < 0 {
+			return 0
+		}
+		return n
+	}
+	date := func(key string) string {
+		s := strings.TrimSpace(form.Get(key))
+		if _, err := time.Parse(search.DateLayout, s); err != nil {
+			return ""
+		}
+		return s
+	}
+
+	f := search.Filter{
+		Year:        atoi("year"),
+		Season:      atoi("season"),
+		Episode:     atoi("episode"),
+		Resolution:  strings.TrimSpace(form.Get("resolution")),
+		VideoCodec:  strings.TrimSpace(form.Get("video_codec")),
+		AudioCodec:  strings.TrimSpace(form.Get("audio_codec")),
+		Source:      strings.TrimSpace(form.Get("source")),
+		Group:       strings.TrimSpace(form.Get("group")),
+		Language:    strings.TrimSpace(form.Get("lang")),
+		MinSize:     size("min_size"),
+		MaxSize:     size("max_size"),
+		AddedFrom:   date("added_from"),
+		AddedTo:     date("added_to"),
+		UpdatedFrom: date("updated_from"),
+		UpdatedTo:   date("updated_to"),
+		MinFiles:    atoi("min_files"),
+		MaxFiles:    atoi("max_files"),
+	}
+	if files := form.Get("files"); files == search.FilesSingle || files == search.FilesMulti {
+		f.Files = files
+	}
+	return ReleaseFilter{f}
+}
+
+// 表单中显示的大小，如 700MB
+func (f ReleaseFilter) SizeValue(n int64) string {
+	if n <= 0 {
+		return ""
+	}
+	return querylang.FormatSize(n)
+}
+
+// Query 返回分页链接中附加的过滤参数
+func (f ReleaseFilter) Query() template.URL {
+	v := f.Values()
+	if len(v) == 0 {
+		return ""
+	}
+	return template.URL("&" + v.Encode())
+}
 
 // 应用初始化
 func newAppConfig() (*AppConfig, error) {
@@ -104,21 +408,55 @@ func newAppConfig() (*AppConfig, error) {
 		return nil, fmt.Errorf("config loading failed: %v", err)
 	}
 
-	if err := app.setupDatabase(); err != nil {
-		return nil, fmt.Errorf("database setup failed: %v", err)
+	// 嵌入式模式不需要 MySQL
+	if app.backend() != "embedded" {
+		if err := app.setupDatabase(); err != nil {
+			return nil, fmt.Errorf("database setup failed: %v", err)
+		}
 	}
 
-	if err := app.setupElasticsearch(); err != nil {
-		return nil, fmt.Errorf("elasticsearch setup failed: %v", err)
+	if err := app.setupSearch(); err != nil {
+		return nil, fmt.Errorf("search backend setup failed: %v", err)
+	}
+
+	if err := app.setupCursors(); err != nil {
+		return nil, fmt.Errorf("cursor setup failed: %v", err)
 	}
 
 	if err := app.setupTemplates(); err != nil {
 		return nil, fmt.Errorf("template setup failed: %v", err)
 	}
 
+	// 未配置时使用默认地址，配置为空串时不开启
+	debugAddr, err := app.Config.String("webinterface.debug_addr")
+	if err != nil {
+		debugAddr = defaultDebugAddr
+	}
+	app.DebugAddr = debugAddr
+
+	app.TorznabKey, _ = app.Config.String("torznab.apikey")
+	if app.TorznabKey == "" {
+		app.Logger.Printf("torznab.apikey is not set, Torznab API only answers t=caps")
+	}
+
 	return app, nil
 }
 
+// 翻页游标的签名密钥，未配置时每次启动随机生成，重启后旧的翻页链接失效
+func (app *AppConfig) setupCursors() error {
+	secret, _ := app.Config.String("webinterface.cursor_secret")
+	if secret == "" {
+		app.Logger.Printf("webinterface.cursor_secret is not set, pagination links will expire on restart")
+	}
+
+	signer, err := cursor.NewSigner([]byte(secret))
+	if err != nil {
+		return err
+	}
+	app.Cursors = signer
+	return nil
+}
+
 func (app *AppConfig) setupLogger() error {
 	f, err := os.OpenFile(logFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -149,7 +487,7 @@ func (app *AppConfig) setupDatabase() error {
 	user, _ := app.Config.String("database.user")
 	pass, _ := app.Config.String("database.password")
 
-	dsn := fmt.Sprintf("%s:%s@%s/%s", user, pass, host, name)
+	dsn := fmt.Sprintf("%s:%s@%s/%s?charset=utf8mb4&collation=utf8mb4_general_ci", user, pass, host, name)
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return err
@@ -180,6 +518,13 @@ var funcMap = template.FuncMap{
 		return b
 	},
 	"div": func(a, b int) int { return a / b },
+	"seq": func(from, to int) []int {
+		var res []int
+		for i := from; i <= to; i++ {
+			res = append(res, i)
+		}
+		return res
+	},
 }
 
 func (app *AppConfig) setupTemplates() error {
@@ -252,559 +597,1024 @@ func (app *AppConfig) getListOfTorrents(sqlQuery string) []bitTorrent {
 	return res
 }
 
-func (app *AppConfig) mainHandler(w http.ResponseWriter, r *http.Request) {
-	// 获取总数量
-	countQuery := map[string]interface{}{
-		"track_total_hits": true,
-		"size":             0, // 只获取总数，不需要具体文档
+// 转换为页面使用的结构
+func torrentView(t search.Torrent) bitTorrent {
+	return bitTorrent{
+		Id:           t.ID,
+		InfoHash:     t.InfoHash,
+		Name:         t.Name,
+		NameHTML:     markHTML(t.Name, t.Highlight),
+		Length:       humanizeFileSize(int(t.Length)),
+		HaveFiles:    t.HasFiles,
+		Files:        fileViews(t.Files),
+		MatchedFiles: fileViews(t.MatchedFiles),
 	}
+}
 
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(countQuery); err != nil {
-		app.Logger.Printf("Error encoding count query: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+// 转义文本并把高亮标记替换为 <mark>，highlighted 为空时只转义 text
+func markHTML(text, highlighted string) template.HTML {
+	if highlighted == "" {
+		highlighted = text
+	}
+	escaped := template.HTMLEscapeString(highlighted)
+
+	var b strings.Builder
+	open := false
+	for _, r := range escaped {
+		switch string(r) {
+		case search.MarkStart:
+			if !open {
+				b.WriteString("<mark>")
+				open = true
+			}
+		case search.MarkEnd:
+			if open {
+				b.WriteString("</mark>")
+				open = false
+			}
+		default:
+			b.WriteRune(r)
+		}
 	}
-
-	// 执行计数查询
-	res, err := app.ES.Search(
-		app.ES.Search.WithContext(context.Background()),
-		app.ES.Search.WithIndex("infohash_index"),
-		app.ES.Search.WithBody(&buf),
-	)
-	if err != nil {
-		app.Logger.Printf("Error getting count: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	if open {
+		b.WriteString("</mark>")
 	}
-	defer res.Body.Close()
+	return template.HTML(b.String())
+}
 
-	var countResult map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&countResult); err != nil {
-		app.Logger.Printf("Error parsing count response: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+func torrentViews(list []search.Torrent) []bitTorrent {
+	res := make([]bitTorrent, 0, len(list))
+	for _, t := range list {
+		res = append(res, torrentView(t))
 	}
+	return res
+}
 
-	countOfTorrents := int(countResult["hits"].(map[string]interface{})["total"].(map[string]interface{})["value"].(float64))
+func fileViews(list []search.File) Files {
+	if list == nil {
+		return nil
+	}
+	files := Files{}
+	for _, f := range list {
+		files = append(files, file{
+			Path:     f.Path,
+			PathHTML: markHTML(f.Path, f.Highlight),
+			Length:   humanizeFileSize(int(f.Length)),
+		})
+	}
+	return files
+}
 
-	// 获取最新种子
-	latestQuery := map[string]interface{}{
-		"sort": []map[string]interface{}{
-			{"updated": map[string]interface{}{"order": "desc"}},
-			{"id": map[string]interface{}{"order": "asc"}},
-		},
-		"size": 50,
+// 按搜索错误类型返回 HTTP 状态码
+func (app *AppConfig) searchError(w http.ResponseWriter, what string, err error) {
+	app.Logger.Printf("Error %s: %v", what, err)
+	if status, msg := searchStatus(w, err); status != 0 {
+		http.Error(w, msg, status)
 	}
+}
 
-	buf.Reset()
-	if err := json.NewEncoder(&buf).Encode(latestQuery); err != nil {
-		app.Logger.Printf("Error encoding latest query: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+// 搜索错误对应的状态码和提示，客户端已断开时返回 0。服务繁忙时设置 Retry-After
+func searchStatus(w http.ResponseWriter, err error) (int, string) {
+	var esErr *search.ElasticError
+	var synErr *querylang.SyntaxError
+	switch {
+	case errors.As(err, &synErr):
+		return http.StatusBadRequest, synErr.Error()
+	case errors.Is(err, search.ErrNotFound):
+		return http.StatusNotFound, "Not Found"
+	case errors.Is(err, search.ErrInvalidCursor):
+		return http.StatusBadRequest, "Invalid sort value"
+	case errors.Is(err, cursor.ErrInvalid):
+		return http.StatusBadRequest, "Invalid cursor"
+	case errors.Is(err, context.Canceled):
+		// 客户端已断开
+		return 0, ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "Search Timeout"
+	case errors.As(err, &esErr):
+		switch {
+		case esErr.Status == http.StatusBadRequest:
+			return http.StatusBadRequest, "Invalid Search Query"
+		case esErr.Type == "index_not_found_exception":
+			return http.StatusServiceUnavailable, "Search Index Not Available"
+		case esErr.Status == http.StatusTooManyRequests || esErr.Status == http.StatusServiceUnavailable:
+			w.Header().Set("Retry-After", "10")
+			return http.StatusServiceUnavailable, "Search Service Busy"
+		default:
+			return http.StatusBadGateway, "Search Service Error"
+		}
+	default:
+		return http.StatusInternalServerError, "Internal Server Error"
 	}
+}
 
-	// 获取最新种子
-	latestRes, err := app.ES.Search(
-		app.ES.Search.WithContext(context.Background()),
-		app.ES.Search.WithIndex("infohash_index"),
-		app.ES.Search.WithBody(&buf),
-	)
+func (app *AppConfig) mainHandler(w http.ResponseWriter, r *http.Request) {
+	// 总数量、最新种子和最受欢迎的种子一次取回
+	overview, err := app.Search.Overview(r.Context(), 50)
 	if err != nil {
-		app.Logger.Printf("Error getting latest torrents: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		app.searchError(w, "getting main page", err)
 		return
 	}
-	defer latestRes.Body.Close()
 
-	// 获取最受欢迎的种子
-	popularQuery := map[string]interface{}{
-		"sort": []map[string]interface{}{
-			{"cnt": map[string]interface{}{"order": "desc"}},
-			{"id": map[string]interface{}{"order": "asc"}},
-		},
-		"size": 50,
+	data := MainData{
+		Title:           "Welcome to DHT search engine!",
+		CountOfTorrents: overview.Count,
+		Lastest:         torrentViews(overview.Latest),
+		Populatest:      torrentViews(overview.Popular),
 	}
 
-	buf.Reset()
-	if err := json.NewEncoder(&buf).Encode(popularQuery); err != nil {
-		app.Logger.Printf("Error encoding popular query: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	app.Logger.Printf("Main page opened. Count of torrents: %d", overview.Count)
+
+	if err := app.Templates.Main.ExecuteTemplate(w, "base", data); err != nil {
+		app.Logger.Printf("Template execution error: %v", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
 	}
+}
 
-	popularRes, err := app.ES.Search(
-		app.ES.Search.WithContext(context.Background()),
-		app.ES.Search.WithIndex("infohash_index"),
-		app.ES.Search.WithBody(&buf),
-	)
-	if err != nil {
-		app.Logger.Printf("Error getting popular torrents: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+// ES 连接
+func (app *AppConfig) setupElasticsearch() error {
+	esURL, _ := app.Config.String("elasticsearch.url")
+	if esURL == "" {
+		esURL = "http://localhost:9200"
 	}
-	defer popularRes.Body.Close()
 
-	// 解析结果
-	var latestResult, popularResult map[string]interface{}
-	if err := json.NewDecoder(latestRes.Body).Decode(&latestResult); err != nil {
-		app.Logger.Printf("Error parsing latest response: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+	cfg := elasticsearch.Config{
+		Addresses: []string{esURL},
 	}
-	if err := json.NewDecoder(popularRes.Body).Decode(&popularResult); err != nil {
-		app.Logger.Printf("Error parsing popular response: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		return
+
+	client, err := elasticsearch.NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("elasticsearch connection error: %v", err)
 	}
 
-	// 转换结果为 bitTorrent 结构
-	lastest := make([]bitTorrent, 0)
-	for _, hit := range latestResult["hits"].(map[string]interface{})["hits"].([]interface{}) {
-		source := hit.(map[string]interface{})["_source"].(map[string]interface{})
+	app.ES = client
 
-		// 添加空值检查
-		var id int64
-		var length int
+	app.Index, _ = app.Config.String("elasticsearch.alias")
+	if app.Index == "" {
+		app.Index = esindex.DefaultAlias
+	}
+	return nil
+}
 
-		if idVal, ok := source["id"].(float64); ok {
-			id = int64(idVal)
-		}
+// 配置的搜索后端，默认 Elasticsearch
+func (app *AppConfig) backend() string {
+	backend, _ := app.Config.String("search.backend")
+	if backend == "" {
+		backend = "elasticsearch"
+	}
+	return backend
+}
 
-		if lengthVal, ok := source["length"].(float64); ok {
-			length = int(lengthVal)
+// 与爬虫生成 textindex 使用相同的停用词
+func (app *AppConfig) newTokenizer() *tokenizer.Tokenizer {
+	var stopWords []string
+	if list, err := app.Config.List("tokenizer.stopwords"); err == nil {
+		for _, w := range list {
+			if s, ok := w.(string); ok {
+				stopWords = append(stopWords, s)
+			}
 		}
+	}
+	return tokenizer.New(stopWords)
+}
 
-		// 检查必要字段是否存在
-		infohash, _ := source["infohash"].(string)
-		name, _ := source["name"].(string)
-		files, _ := source["files"].(bool)
+// 按配置选择搜索后端
+func (app *AppConfig) setupSearch() error {
+	backend := app.backend()
 
-		lastest = append(lastest, bitTorrent{
-			Id:        id,
-			InfoHash:  infohash,
-			Name:      name,
-			Length:    humanizeFileSize(length),
-			HaveFiles: files,
-		})
+	switch backend {
+	case "elasticsearch":
+		if err := app.setupElasticsearch(); err != nil {
+			return err
+		}
+		app.Search = search.NewElastic(app.ES, app.Index, app.DB)
+	case "mysql":
+		app.Search = search.NewMySQL(app.DB, app.newTokenizer())
+	case "embedded":
+		// 读取爬虫写入的数据目录，定期加载新记录
+		dir, _ := app.Config.String("embedded.path")
+		if dir == "" {
+			dir = "data"
+		}
+		ix, err := embedded.Open(dir)
+		if err != nil {
+			return fmt.Errorf("embedded index open error: %v", err)
+		}
+		go ix.Watch(context.Background(), 5*time.Second, app.Logger)
+		app.Logger.Printf("Embedded index loaded from %s: %d torrents", dir, ix.Len())
+		app.Search = search.NewEmbedded(ix, app.newTokenizer())
+	default:
+		return fmt.Errorf("unknown search backend: %s", backend)
 	}
 
-	populatest := make([]bitTorrent, 0)
-	for _, hit := range popularResult["hits"].(map[string]interface{})["hits"].([]interface{}) {
-		source := hit.(map[string]interface{})["_source"].(map[string]interface{})
+	// 缓存热门查询的结果，命中率在调试端口 /debug/vars 的 search_cache 中查看
+	size, err := app.Config.Int("search.cache_size")
+	if err != nil {
+		size = defaultCacheSize
+	}
+	ttl, err := app.Config.Int("search.cache_ttl")
+	if err != nil {
+		ttl = defaultCacheTTL
+	}
+	if size > 0 && ttl > 0 {
+		cache := search.NewCache(app.Search, size, time.Duration(ttl)*time.Second)
+		expvar.Publish("search_cache", expvar.Func(func() interface{} { return cache.Stats() }))
+		app.Search = cache
+		app.Logger.Printf("Search cache: %d entries, TTL %ds", size, ttl)
+	}
 
-		// 添加空值检查
-		var id int64
-		var length int
+	app.Queries = search.NewQueryStats(suggestQueryStats)
+	app.Logger.Printf("Search backend: %s", backend)
+	return nil
+}
 
-		if idVal, ok := source["id"].(float64); ok {
-			id = int64(idVal)
+// searchTorrents 解析搜索参数或翻页游标并查询一页结果，HTML 页面、JSON 接口和订阅共用。
+// snapshot 为 true 时第一页就打开快照，之后的翻页链接沿用；只取一页的订阅不需要。
+// 查询语法错误或游标无效时返回错误，page 中仍包含已解析的查询条件
+func (app *AppConfig) searchTorrents(ctx context.Context, form url.Values, snapshot bool) (*searchPage, error) {
+	page := &searchPage{Page: 1}
+
+	// 翻页链接只有游标，查询条件、排序、位置和快照都从游标中取出
+	var after, before []interface{}
+	var pit string
+	if token := form.Get("cursor"); token != "" {
+		c, err := app.Cursors.Decode(token)
+		if err != nil {
+			return page, err
 		}
-
-		if lengthVal, ok := source["length"].(float64); ok {
-			length = int(lengthVal)
+		if form, err = url.ParseQuery(c.Filter); err != nil {
+			return page, cursor.ErrInvalid
 		}
+		form.Set("q", c.Query)
+		form.Set("order", c.Order)
+		form.Set("dir", sortDir(c.Asc))
+		page.Page, after, before, pit = c.Page, c.After, c.Before, c.PIT
+	} else if p, err := strconv.Atoi(form.Get("page")); err == nil && p > 1 {
+		// 页码链接按偏移量跳页
+		page.Page = min(p, maxJumpResults/pageSize)
+	}
+	page.Jumped = after == nil && before == nil
 
-		// 检查必要字段是否存在
-		infohash, _ := source["infohash"].(string)
-		name, _ := source["name"].(string)
-		files, _ := source["files"].(bool)
+	page.Query = form.Get("q")
+	page.Order, page.Asc = parseOrder(form, page.Query)
+	page.Filter = parseReleaseFilter(form)
 
-		populatest = append(populatest, bitTorrent{
-			Id:        id,
-			InfoHash:  infohash,
-			Name:      name,
-			Length:    humanizeFileSize(length),
-			HaveFiles: files,
-		})
+	expr, err := querylang.Parse(page.Query)
+	if err != nil {
+		return page, err
+	}
+	page.HasText = expr.HasText()
+
+	// 总数与当前页数据一次取回
+	q := search.Query{
+		Text:     page.Query,
+		Order:    page.Order,
+		Asc:      page.Asc,
+		Filter:   page.Filter.Filter,
+		Size:     pageSize,
+		From:     (page.Page - 1) * pageSize,
+		After:    after,
+		Before:   before,
+		PIT:      pit,
+		Snapshot: snapshot,
+	}
+	page.Result, err = app.Search.Search(ctx, q)
+	// 快照过期时在新快照上继续翻页，并提示用户结果可能已经变化
+	if errors.Is(err, search.ErrPITExpired) {
+		page.Expired, q.PIT = true, ""
+		page.Result, err = app.Search.Search(ctx, q)
+	}
+	if err != nil {
+		return page, err
+	}
+
+	// 结果很少时尝试拼写纠正：原查询没有结果时直接使用纠正后的结果，否则只给出纠正后的查询
+	if page.Page == 1 && page.Result.Total < correctThreshold && form.Get("nocorrect") == "" {
+		if c := app.correctQuery(ctx, page.Query); c != "" {
+			q := search.Query{Text: c, Order: page.Order, Asc: page.Asc, Filter: page.Filter.Filter, Size: pageSize}
+			res, err := app.Search.Search(ctx, q)
+			if err != nil {
+				app.Logger.Printf("Corrected query error: %q: %v", c, err)
+			} else if res.Total > page.Result.Total {
+				if page.Result.Total == 0 {
+					page.Original, page.Query, page.Result = page.Query, c, res
+				} else {
+					page.Corrected = c
+				}
+			}
+		}
 	}
 
-	data := MainData{
-		Title:           "Welcome to DHT search engine!",
-		CountOfTorrents: countOfTorrents,
-		Lastest:         lastest,
-		Populatest:      populatest,
+	page.TotalPages = max((page.Result.Total+pageSize-1)/pageSize, 1)
+	// 游标翻页时结果减少则修正页码，跳页超出范围由调用方处理
+	if page.Page > page.TotalPages && !page.Jumped {
+		page.Page = page.TotalPages
 	}
 
-	app.Logger.Printf("Main page opened. Count of torrents: %d", countOfTorrents)
+	return page, nil
+}
 
-	if err := app.Templates.Main.ExecuteTemplate(w, "base", data); err != nil {
-		app.Logger.Printf("Template execution error: %v", err)
-		http.Error(w, "Template Error", http.StatusInternalServerError)
+// 第一页有结果的关键词计入热门关键词。订阅的定时轮询不计入
+func (app *AppConfig) addQuery(p *searchPage) {
+	if p.Page == 1 && p.Result.Total > 0 {
+		app.Queries.Add(p.Query)
 	}
 }
 
-// ES 连接
-func (app *AppConfig) setupElasticsearch() error {
-	esURL, _ := app.Config.String("elasticsearch.url")
-	if esURL == "" {
-		esURL = "http://localhost:9200"
+// 第 n 页的游标，没有排序位置时按偏移量跳页
+func (p *searchPage) cursor(n int) cursor.Cursor {
+	return cursor.Cursor{
+		Query:  p.Query,
+		Filter: p.Filter.Values().Encode(),
+		Order:  p.Order,
+		Asc:    p.Asc,
+		Page:   n,
+		PIT:    p.Result.PIT,
 	}
+}
 
-	cfg := elasticsearch.Config{
-		Addresses: []string{esURL},
+// 上一页的游标，第一页时返回 false；回到第一页时不需要排序位置
+func (p *searchPage) prev() (cursor.Cursor, bool) {
+	if p.Page <= 1 {
+		return cursor.Cursor{}, false
+	}
+	c := p.cursor(p.Page - 1)
+	if p.Page > 2 && p.Result.Prev != nil {
+		c.Before = p.Result.Prev
 	}
+	return c, true
+}
 
-	client, err := elasticsearch.NewClient(cfg)
-	if err != nil {
-		return fmt.Errorf("elasticsearch connection error: %v", err)
+// 下一页的游标，最后一页时返回 false
+func (p *searchPage) next() (cursor.Cursor, bool) {
+	if p.Page >= p.TotalPages || p.Result.Next == nil {
+		return cursor.Cursor{}, false
 	}
+	c := p.cursor(p.Page + 1)
+	c.After = p.Result.Next
+	return c, true
+}
 
-	app.ES = client
-	return nil
+// 游标对应的搜索页链接，没有排序位置和快照时使用普通的页码链接
+func (app *AppConfig) pageLink(p *searchPage, c cursor.Cursor) string {
+	if c.After == nil && c.Before == nil && c.PIT == "" {
+		return pageURL(searchURL(p.Query, p.Order, p.Asc, p.Filter), c.Page)
+	}
+	return app.cursorURL(c)
 }
 
-func (app *AppConfig) searchTorrents(query string, order string, page, pageSize int, searchAfter []interface{}) ([]bitTorrent, int, []interface{}, error) {
-	var buf bytes.Buffer
+func (app *AppConfig) searchHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := app.searchTorrents(r.Context(), r.URL.Query(), true)
+
+	// 查询语法错误时在搜索页显示原因
+	var synErr *querylang.SyntaxError
+	if errors.As(err, &synErr) {
+		app.Logger.Printf("Query syntax error: %q: %v", page.Query, err)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		if err := app.Templates.Search.ExecuteTemplate(w, "base", SearchData{
+			Title:      "Search Results: " + page.Query,
+			Query:      page.Query,
+			Order:      page.Order,
+			Dir:        sortDir(page.Asc),
+			Page:       1,
+			TotalPages: 1,
+			Filter:     page.Filter,
+			Options:    searchOptions,
+			Error:      err.Error(),
+		}); err != nil {
+			app.Logger.Printf("Template execution error: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		app.searchError(w, fmt.Sprintf("getting page %d", page.Page), err)
+		return
+	}
+	app.addQuery(page)
 
-	// 构建搜索查询
-	searchQuery := map[string]interface{}{
-		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"must": []map[string]interface{}{},
-			},
-		},
-		"sort": []map[string]interface{}{},
-		"size": pageSize,
+	// 跳页超出结果范围时转到最后一页
+	if page.Page > page.TotalPages {
+		http.Redirect(w, r, app.pageLink(page, page.cursor(page.TotalPages)), http.StatusFound)
+		return
 	}
 
-	// 添加查询条件
-	if query != "" {
-		searchQuery["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"] = []map[string]interface{}{
-			{
-				"match": map[string]interface{}{
-					"textindex": map[string]interface{}{
-						"query":                query,
-						"operator":             "and",
-						"minimum_should_match": "100%",
-						"analyzer":             "standard",
-						"zero_terms_query":     "none",
-					},
-				},
-			},
-		}
+	// 上一页、下一页使用签名游标；页码链接在有快照时也使用游标，保持在同一快照上，
+	// 否则为普通链接
+	var prevURL, nextURL string
+	if c, ok := page.prev(); ok {
+		prevURL = app.pageLink(page, c)
+	}
+	if c, ok := page.next(); ok {
+		nextURL = app.pageLink(page, c)
 	}
 
-	// 根据排序方式添加排序字段
-	if order == "cnt" {
-		searchQuery["sort"] = []map[string]interface{}{
-			{"cnt": map[string]interface{}{"order": "desc"}},
-			{"id": map[string]interface{}{"order": "asc"}},
-		}
-	} else {
-		searchQuery["sort"] = []map[string]interface{}{
-			{"updated": map[string]interface{}{"order": "desc"}},
-			{"id": map[string]interface{}{"order": "asc"}},
+	data := SearchData{
+		Title:      "Search Results: " + page.Query,
+		Query:      page.Query,
+		Order:      page.Order,
+		Dir:        sortDir(page.Asc),
+		Sorts:      sortOptions(page.Order, page.Asc, page.HasText),
+		Founded:    torrentViews(page.Result.Torrents),
+		Page:       page.Page,
+		TotalPages: page.TotalPages,
+		TotalCount: page.Result.Total,
+		PrevURL:    prevURL,
+		NextURL:    nextURL,
+		PageLink:   func(n int) string { return app.pageLink(page, page.cursor(n)) },
+		JumpPages:  min(page.TotalPages, maxJumpResults/pageSize),
+		Expired:    page.Expired,
+		Filter:     page.Filter,
+		Options:    searchOptions,
+		Corrected:  page.Corrected,
+		Original:   page.Original,
+	}
+
+	app.Logger.Printf("Query: %s, Order: %s %s, Page: %d, TotalPages: %d", page.Query, page.Order, sortDir(page.Asc), page.Page, page.TotalPages)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := app.Templates.Search.ExecuteTemplate(w, "base", data); err != nil {
+		app.Logger.Printf("Template execution error: %v", err)
+		if !strings.Contains(err.Error(), "write: broken pipe") {
+			http.Error(w, "Template Error", http.StatusInternalServerError)
 		}
 	}
+}
 
-	// 如果有 search_after 数，加入到查询中
-	if searchAfter != nil {
-		searchQuery["search_after"] = searchAfter
+// 带签名游标的翻页链接，游标编码失败时返回空串
+func (app *AppConfig) cursorURL(c cursor.Cursor) string {
+	token, err := app.Cursors.Encode(c)
+	if err != nil {
+		app.Logger.Printf("Cursor encoding error: %v", err)
+		return ""
 	}
+	return "/search/?cursor=" + token
+}
 
-	// 编码
-	if err := json.NewEncoder(&buf).Encode(searchQuery); err != nil {
-		return nil, 0, nil, fmt.Errorf("error encoding search query: %s", err)
+// 拼写纠正后的查询，只替换普通词，没有可纠正的词时返回空串
+func (app *AppConfig) correctQuery(ctx context.Context, query string) string {
+	expr, err := querylang.Parse(query)
+	if err != nil || len(expr.Terms) == 0 {
+		return ""
 	}
 
-	// 送请求
-	res, err := app.ES.Search(
-		app.ES.Search.WithContext(context.Background()),
-		app.ES.Search.WithIndex("infohash_index"),
-		app.ES.Search.WithBody(&buf),
-		app.ES.Search.WithTrackTotalHits(true),
-	)
+	terms, err := app.Search.Correct(ctx, expr.Terms)
 	if err != nil {
-		return nil, 0, nil, fmt.Errorf("error executing search: %s", err)
+		app.Logger.Printf("Spelling correction error: %q: %v", query, err)
+		return ""
 	}
-	defer res.Body.Close()
-
-	// 解析响应
-	var result map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return nil, 0, nil, fmt.Errorf("error parsing search response: %s", err)
+	if terms == nil {
+		return ""
+	}
+	if c := querylang.ReplaceTerms(query, terms); c != query {
+		return c
 	}
+	return ""
+}
 
-	// 获取总命中数
-	totalHits := int(result["hits"].(map[string]interface{})["total"].(map[string]interface{})["value"].(float64))
+// 输入补全，返回热门关键词和匹配的种子名称。后端超时或出错时只返回已有的建议
+func (app *AppConfig) suggestHandler(w http.ResponseWriter, r *http.Request) {
+	type suggestion struct {
+		Text string `json:"text"`
+		Type string `json:"type"` // query 或 torrent
+	}
+	q := r.FormValue("q")
+	res := struct {
+		Query       string       `json:"query"`
+		Suggestions []suggestion `json:"suggestions"`
+	}{Query: q, Suggestions: []suggestion{}}
+
+	if len([]rune(strings.TrimSpace(q))) >= suggestMinLength {
+		queries := app.Queries.Suggest(q, suggestQueries)
+		for _, s := range queries {
+			res.Suggestions = append(res.Suggestions, suggestion{Text: s.Text, Type: "query"})
+		}
 
-	// 提取搜索结果
-	var torrents []bitTorrent
-	var lastSort []interface{}
-	hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
+		ctx, cancel := context.WithTimeout(r.Context(), suggestTimeout)
+		defer cancel()
+		torrents, err := app.Search.Suggest(ctx, q, suggestLimit-len(queries))
+		if err != nil && !errors.Is(err, context.Canceled) {
+			app.Logger.Printf("Suggest error: %q: %v", q, err)
+		}
+		for _, s := range torrents {
+			res.Suggestions = append(res.Suggestions, suggestion{Text: s.Text, Type: "torrent"})
+		}
+	}
 
-	for _, hit := range hits {
-		source := hit.(map[string]interface{})["_source"].(map[string]interface{})
-		sortValues := hit.(map[string]interface{})["sort"].([]interface{})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		app.Logger.Printf("Suggest response error: %v", err)
+	}
+}
 
-		id := int64(source["id"].(float64))
-		length := int(source["length"].(float64))
+// 种子的 magnet 链接
+func magnetURI(infoHash, name string) string {
+	return "magnet:?xt=urn:btih:" + infoHash + "&dn=" + url.QueryEscape(name)
+}
 
-		torrents = append(torrents, bitTorrent{
-			Id:        id,
-			InfoHash:  source["infohash"].(string),
-			Name:      source["name"].(string),
-			Length:    humanizeFileSize(length),
-			HaveFiles: source["files"].(bool),
-		})
-		lastSort = sortValues
+// 按 infohash 查找种子，返回包含文件列表的详情，格式不对或不存在时返回 search.ErrNotFound
+func (app *AppConfig) torrentByHash(ctx context.Context, infoHash string) (*search.Torrent, error) {
+	infoHash = strings.ToLower(infoHash)
+	if !infoHashPattern.MatchString(infoHash) {
+		return nil, search.ErrNotFound
+	}
+	res, err := app.Search.Search(ctx, search.Query{Text: "hash:" + infoHash, Size: 1})
+	if err != nil {
+		return nil, err
 	}
+	if len(res.Torrents) == 0 {
+		return nil, search.ErrNotFound
+	}
+	return app.Search.Get(ctx, res.Torrents[0].ID)
+}
 
-	return torrents, totalHits, lastSort, nil
+func apiTorrentView(t search.Torrent) apiTorrent {
+	res := apiTorrent{
+		ID:         t.ID,
+		InfoHash:   t.InfoHash,
+		Name:       t.Name,
+		Size:       t.Length,
+		FileCount:  t.FileCount,
+		Popularity: t.Cnt,
+		Magnet:     magnetURI(t.InfoHash, t.Name),
+	}
+	if added := t.AddedTime(); !added.IsZero() {
+		res.Added = added.Format(time.RFC3339)
+	}
+	if updated := t.UpdatedTime(); !updated.IsZero() {
+		res.Updated = updated.Format(time.RFC3339)
+	}
+	for _, f := range t.MatchedFiles {
+		res.MatchedFiles = append(res.MatchedFiles, apiFile{Path: f.Path, Size: f.Length})
+	}
+	return res
 }
 
-func (app *AppConfig) searchHandler(w http.ResponseWriter, r *http.Request) {
-	defer func() {
-		if err := recover(); err != nil {
-			app.Logger.Printf("Panic in searchHandler: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-		}
-	}()
+func apiTorrentViews(list []search.Torrent) []apiTorrent {
+	res := make([]apiTorrent, 0, len(list))
+	for _, t := range list {
+		res = append(res, apiTorrentView(t))
+	}
+	return res
+}
 
-	query := r.FormValue("q")
-	order := r.FormValue("order")
-	if order != "cnt" {
-		order = "updated"
+func (app *AppConfig) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	// magnet 链接中的 & 不转义
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		app.Logger.Printf("JSON encoding error: %v", err)
 	}
+}
 
-	pageStr := r.FormValue("page")
-	if pageStr == "" {
-		pageStr = "1"
+// JSON 接口的错误响应 {"error": "..."}
+func (app *AppConfig) apiError(w http.ResponseWriter, what string, err error) {
+	app.Logger.Printf("API error %s: %v", what, err)
+	if status, msg := searchStatus(w, err); status != 0 {
+		app.writeJSON(w, status, map[string]string{"error": msg})
 	}
+}
 
-	pageNum, err := strconv.Atoi(pageStr)
-	if err != nil || pageNum < 1 {
-		pageNum = 1
+// GET /api/v1/search，参数与搜索页相同，翻页时只传 cursor
+func (app *AppConfig) apiSearchHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := app.searchTorrents(r.Context(), r.URL.Query(), true)
+	if err != nil {
+		app.apiError(w, "searching", err)
+		return
 	}
+	app.addQuery(page)
 
-	pageSize := 30
+	res := apiSearchResult{
+		Query:      page.Query,
+		Order:      page.Order,
+		Dir:        sortDir(page.Asc),
+		Total:      page.Result.Total,
+		Page:       page.Page,
+		TotalPages: page.TotalPages,
+		Results:    apiTorrentViews(page.Result.Torrents),
+		Corrected:  page.Corrected,
+		Original:   page.Original,
+		Expired:    page.Expired,
+	}
+	if c, ok := page.prev(); ok {
+		res.PrevCursor, _ = app.Cursors.Encode(c)
+	}
+	if c, ok := page.next(); ok {
+		res.NextCursor, _ = app.Cursors.Encode(c)
+	}
+	app.writeJSON(w, http.StatusOK, res)
+}
 
-	// 获取总记录数和计算总页数
-	_, totalCount, _, err := app.searchTorrents(query, order, 1, 0, nil)
+// GET /api/v1/torrents/{infohash}
+func (app *AppConfig) apiTorrentHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := app.torrentByHash(r.Context(), mux.Vars(r)["infohash"])
 	if err != nil {
-		app.Logger.Printf("Error getting total count: %v", err)
-		http.Error(w, "Search Error", http.StatusInternalServerError)
+		app.apiError(w, "getting torrent", err)
 		return
 	}
+	app.writeJSON(w, http.StatusOK, apiTorrentView(*t))
+}
 
-	// 计算总页数
-	totalPages := (totalCount + pageSize - 1) / pageSize
-	if totalPages == 0 {
-		totalPages = 1
+// GET /api/v1/torrents/{infohash}/files，单文件种子返回以种子名称为路径的一个文件
+func (app *AppConfig) apiFilesHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := app.torrentByHash(r.Context(), mux.Vars(r)["infohash"])
+	if err != nil {
+		app.apiError(w, "getting torrent files", err)
+		return
 	}
 
-	// 验证并修正页码
-	if pageNum > totalPages {
-		pageNum = totalPages
+	files := make([]apiFile, 0, len(t.Files))
+	for _, f := range t.Files {
+		files = append(files, apiFile{Path: f.Path, Size: f.Length})
 	}
-	if pageNum < 1 {
-		pageNum = 1
+	if len(files) == 0 && !t.HasFiles {
+		files = append(files, apiFile{Path: t.Name, Size: t.Length})
 	}
+	app.writeJSON(w, http.StatusOK, struct {
+		InfoHash string    `json:"infohash"`
+		Files    []apiFile `json:"files"`
+	}{t.InfoHash, files})
+}
 
-	// 获取当前页数据
-	var currentPageResults []bitTorrent
-	var currentSort []interface{}
-	var prevSort, nextSort string
+// 列表接口的 limit 参数，默认 apiListLimit 条，不超过 apiListMaxLimit
+func listLimit(r *http.Request) int {
+	n, err := strconv.Atoi(r.FormValue("limit"))
+	if err != nil || n <= 0 {
+		return apiListLimit
+	}
+	return min(n, apiListMaxLimit)
+}
 
-	// 从请求中获取 sort 值
-	if sortStr := r.FormValue("sort"); sortStr != "" {
-		if err = json.Unmarshal([]byte(sortStr), &currentSort); err != nil {
-			app.Logger.Printf("Error parsing sort value: %v", err)
-			http.Error(w, "Invalid sort value", http.StatusBadRequest)
+// GET /api/v1/latest 和 /api/v1/popular
+func (app *AppConfig) apiListHandler(list func(ctx context.Context, n int) ([]search.Torrent, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		torrents, err := list(r.Context(), listLimit(r))
+		if err != nil {
+			app.apiError(w, "listing torrents", err)
 			return
 		}
+		app.writeJSON(w, http.StatusOK, struct {
+			Results []apiTorrent `json:"results"`
+		}{apiTorrentViews(torrents)})
+	}
+}
+
+// GET /api/v1/openapi.json
+func (app *AppConfig) apiDocHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(openAPIDoc)
+}
+
+// 请求对应的站点地址，用于生成绝对链接，经反向代理时参考 X-Forwarded-Proto
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
 	}
+	return scheme + "://" + r.Host
+}
 
-	// 从请求中获取 prevSort 值
-	if prevSortStr := r.FormValue("prevSort"); prevSortStr != "" {
-		prevSort = prevSortStr
+func (app *AppConfig) writeXML(w http.ResponseWriter, contentType string, v interface{}) {
+	w.Header().Set("Content-Type", contentType)
+	io.WriteString(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		app.Logger.Printf("XML encoding error: %v", err)
 	}
+}
 
-	// 取当前页数据
-	if pageNum == 1 {
-		// 第一页直接获取
-		results, _, lastSort, err := app.searchTorrents(query, order, 1, pageSize, nil)
-		if err != nil {
-			app.Logger.Printf("Error getting first page: %v", err)
-			http.Error(w, "Search Error", http.StatusInternalServerError)
-			return
+// Torznab 错误以 200 状态码和 <error> 返回，Sonarr、Radarr 按其中的错误码处理
+func (app *AppConfig) torznabError(w http.ResponseWriter, code int, description string) {
+	app.writeXML(w, "application/xml; charset=utf-8", &torznab.Error{Code: code, Description: description})
+}
+
+func torznabCaps() *torznab.Caps {
+	return &torznab.Caps{
+		Server: torznab.Server{Title: "DHT-ES-Search"},
+		Limits: torznab.Limits{Max: torznabMaxLimit, Default: torznabMaxLimit},
+		Searching: torznab.Searching{
+			Search:      torznab.SearchCaps{Available: "yes", SupportedParams: "q"},
+			TVSearch:    torznab.SearchCaps{Available: "yes", SupportedParams: "q,season,ep"},
+			MovieSearch: torznab.SearchCaps{Available: "yes", SupportedParams: "q,year"},
+		},
+		Categories: torznab.Categories,
+	}
+}
+
+// 去掉标题中的查询语法：引号和词首的 -，使其只作为普通词匹配
+func torznabText(q string) string {
+	words := strings.Fields(strings.ReplaceAll(q, `"`, " "))
+	res := words[:0]
+	for _, w := range words {
+		if w = strings.TrimLeft(w, "-"); w != "" {
+			res = append(res, w)
 		}
-		currentPageResults = results
-		// 保存下一页的 sort 值
-		if lastSort != nil {
-			if sortBytes, err := json.Marshal(lastSort); err == nil {
-				nextSort = string(sortBytes)
-			}
+	}
+	return strings.Join(res, " ")
+}
+
+// 按 Torznab 参数生成搜索条件，参数无法解析时返回 *torznab.Error
+func torznabQuery(form url.Values) (search.Query, error) {
+	fn := form.Get("t")
+	if fn != "search" && fn != "tvsearch" && fn != "movie" {
+		return search.Query{}, &torznab.Error{Code: torznab.ErrNoSuchFunction, Description: "No such function"}
+	}
+
+	q := search.Query{
+		Text:  torznabText(form.Get("q")),
+		Order: search.OrderRelevance, // 没有关键词时按更新时间，即 RSS 同步
+		Size:  torznabMaxLimit,
+	}
+	q.Filter.Categories = torznab.LocalCategories(torznab.ParseIDs(form.Get("cat")))
+
+	// 整数参数，未设置时为 0
+	intParam := func(name string) (int, error) {
+		s := form.Get(name)
+		if s == "" {
+			return 0, nil
 		}
-	} else {
-		// 用传入的 sort 值获取当前页
-		results, _, lastSort, err := app.searchTorrents(query, order, 1, pageSize, currentSort)
-		if err != nil {
-			app.Logger.Printf("Error getting page %d: %v", pageNum, err)
-			http.Error(w, "Search Error", http.StatusInternalServerError)
-			return
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, &torznab.Error{Code: torznab.ErrIncorrectParameter, Description: "Incorrect parameter: " + name}
 		}
-		currentPageResults = results
+		return n, nil
+	}
 
-		// 保存下一页的 sort 值
-		if lastSort != nil {
-			if sortBytes, err := json.Marshal(lastSort); err == nil {
-				nextSort = string(sortBytes)
+	var err error
+	if fn == "tvsearch" {
+		if q.Filter.Season, err = intParam("season"); err != nil {
+			return q, err
+		}
+		// 按日期播出的剧集 ep 为 MM/DD，不按集数过滤
+		if ep := form.Get("ep"); !strings.Contains(ep, "/") {
+			if q.Filter.Episode, err = intParam("ep"); err != nil {
+				return q, err
 			}
 		}
 	}
-
-	data := SearchData{
-		Title:      "Search Results: " + query,
-		Query:      query,
-		Order:      order,
-		Founded:    currentPageResults,
-		Page:       pageNum,
-		TotalPages: totalPages,
-		TotalCount: totalCount,
-		PrevSort:   prevSort,
-		NextSort:   nextSort,
+	if fn == "movie" {
+		if q.Filter.Year, err = intParam("year"); err != nil {
+			return q, err
+		}
 	}
 
-	app.Logger.Printf("Query: %s, Order: %s, Page: %d, TotalPages: %d", query, order, pageNum, totalPages)
-
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	if err := app.Templates.Search.ExecuteTemplate(w, "base", data); err != nil {
-		app.Logger.Printf("Template execution error: %v", err)
-		if !strings.Contains(err.Error(), "write: broken pipe") {
-			http.Error(w, "Template Error", http.StatusInternalServerError)
-		}
+	limit, err := intParam("limit")
+	if err != nil {
+		return q, err
+	}
+	if limit > 0 {
+		q.Size = min(limit, torznabMaxLimit)
+	}
+	if q.From, err = intParam("offset"); err != nil {
+		return q, err
 	}
+	q.From = min(q.From, maxJumpResults)
+	return q, nil
 }
 
-func (app *AppConfig) detailsHandler(w http.ResponseWriter, r *http.Request) {
-	hashID := r.FormValue("id")
-
-	// 构建查询
-	query := map[string]interface{}{
-		"query": map[string]interface{}{
-			"term": map[string]interface{}{
-				"id": hashID,
-			},
-		},
+// Torznab 接口 /torznab/api，t=caps 不需要 API key
+func (app *AppConfig) torznabHandler(w http.ResponseWriter, r *http.Request) {
+	form := r.URL.Query()
+	if form.Get("t") == "caps" {
+		app.writeXML(w, "application/xml; charset=utf-8", torznabCaps())
+		return
 	}
 
-	var buf bytes.Buffer
-	if err := json.NewEncoder(&buf).Encode(query); err != nil {
-		app.Logger.Printf("Error encoding query: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	key := form.Get("apikey")
+	if app.TorznabKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(app.TorznabKey)) != 1 {
+		app.torznabError(w, torznab.ErrIncorrectCredentials, "Incorrect user credentials")
 		return
 	}
 
-	// 执行查询
-	res, err := app.ES.Search(
-		app.ES.Search.WithContext(context.Background()),
-		app.ES.Search.WithIndex("infohash_index"),
-		app.ES.Search.WithBody(&buf),
-	)
-	if err != nil {
-		app.Logger.Printf("Error getting torrent details: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	q, err := torznabQuery(form)
+	var tzErr *torznab.Error
+	if errors.As(err, &tzErr) {
+		app.torznabError(w, tzErr.Code, tzErr.Description)
 		return
 	}
-	defer res.Body.Close()
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		app.Logger.Printf("Error parsing response: %v", err)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	res, err := app.Search.Search(r.Context(), q)
+	if err != nil {
+		app.Logger.Printf("Torznab search error: %q: %v", q.Text, err)
+		if status, msg := searchStatus(w, err); status != 0 {
+			app.torznabError(w, torznab.ErrUnknown, msg)
+		}
 		return
 	}
 
-	hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
-	if len(hits) == 0 {
-		http.Error(w, "Not Found", http.StatusNotFound)
-		return
+	base := baseURL(r)
+	feed := torznab.NewFeed("DHT-ES-Search", base+"/", base+r.URL.Path)
+	feed.Channel.Response = torznab.Response{Offset: q.From, Total: res.Total}
+	for _, t := range res.Torrents {
+		feed.Channel.Items = append(feed.Channel.Items, torznabItem(base, t))
 	}
 
-	source := hits[0].(map[string]interface{})["_source"].(map[string]interface{})
+	app.Logger.Printf("Torznab %s: %q, %d of %d", form.Get("t"), q.Text, len(res.Torrents), res.Total)
+	app.writeXML(w, "application/rss+xml; charset=utf-8", feed)
+}
 
-	// 提取字段
-	var (
-		id        int64
-		infohash  string
-		name      string
-		length    int
-		haveFiles bool
-		addeded   string
-		updated   string
-	)
+func torznabItem(base string, t search.Torrent) torznab.Item {
+	magnet := magnetURI(t.InfoHash, t.Name)
+	seeders := strconv.Itoa(torznab.Seeders(t.Cnt))
+	item := torznab.Item{
+		Title:     t.Name,
+		GUID:      torznab.GUID{Value: t.InfoHash},
+		Link:      magnet,
+		Comments:  base + "/details/?id=" + strconv.FormatInt(t.ID, 10),
+		Size:      t.Length,
+		Category:  torznab.CategoryIDs(t.Category, t.Resolution),
+		Enclosure: torznab.Enclosure{URL: magnet, Length: t.Length, Type: "application/x-bittorrent"},
+		Attrs: []torznab.Attr{
+			{Name: "size", Value: strconv.FormatInt(t.Length, 10)},
+			{Name: "files", Value: strconv.Itoa(max(t.FileCount, 1))},
+			{Name: "seeders", Value: seeders},
+			{Name: "peers", Value: seeders},
+			{Name: "infohash", Value: t.InfoHash},
+			{Name: "magneturl", Value: magnet},
+		},
+	}
+	if added := t.AddedTime(); !added.IsZero() {
+		item.PubDate = added.Format(time.RFC1123Z)
+	}
+	for _, id := range item.Category {
+		item.Attrs = append(item.Attrs, torznab.Attr{Name: "category", Value: strconv.Itoa(id)})
+	}
+	return item
+}
 
-	// 添加类型检查
-	if idVal, ok := source["id"].(float64); ok {
-		id = int64(idVal)
+// 订阅的最后修改时间：种子中最新的收录或更新时间，没有种子时为零值
+func feedModified(list []search.Torrent) time.Time {
+	var res time.Time
+	for _, t := range list {
+		for _, tm := range []time.Time{t.AddedTime(), t.UpdatedTime()} {
+			if tm.After(res) {
+				res = tm
+			}
+		}
 	}
-	if lengthVal, ok := source["length"].(float64); ok {
-		length = int(lengthVal)
+	return res
+}
+
+// 订阅中种子的简介
+func feedSummary(t search.Torrent) string {
+	return fmt.Sprintf("Size: %s, Files: %d", humanizeFileSize(int(t.Length)), max(t.FileCount, 1))
+}
+
+// 输出订阅。ETag 为内容的哈希，Last-Modified 为 modified，由 http.ServeContent 处理
+// If-None-Match 和 If-Modified-Since，内容未变化时返回 304，订阅程序轮询时不必重新下载
+func (app *AppConfig) writeFeed(w http.ResponseWriter, r *http.Request, contentType string, modified time.Time, v interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		app.Logger.Printf("XML encoding error: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
 	}
-	infohash, _ = source["infohash"].(string)
-	name, _ = source["name"].(string)
-	haveFiles, _ = source["files"].(bool)
-	addeded, _ = source["addeded"].(string)
-	updated, _ = source["updated"].(string)
 
-	app.Logger.Printf("Details request for: %s (%s)", name, humanizeFileSize(length))
+	sum := sha1.Sum(buf.Bytes())
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", modified, bytes.NewReader(buf.Bytes()))
+}
 
-	files := Files{}
-	if haveFiles {
-		files, err = app.getTorrentFiles(id)
+func rssItem(base string, t search.Torrent) feed.Item {
+	return feed.Item{
+		Title:       t.Name,
+		Link:        base + "/details/?id=" + strconv.FormatInt(t.ID, 10),
+		Description: feedSummary(t),
+		GUID:        feed.GUID{Value: "urn:btih:" + t.InfoHash},
+		PubDate:     feed.RSSTime(t.AddedTime()),
+		Enclosure:   feed.Enclosure{URL: magnetURI(t.InfoHash, t.Name), Length: t.Length, Type: feed.TypeBitTorrent},
+	}
+}
+
+// GET /feed/latest.rss 和 /feed/popular.rss
+func (app *AppConfig) rssListHandler(title string, list func(ctx context.Context, n int) ([]search.Torrent, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		torrents, err := list(r.Context(), feedLength)
 		if err != nil {
-			app.Logger.Printf("Error getting torrent files: %v", err)
+			app.searchError(w, "getting feed", err)
+			return
 		}
-	}
 
-	sort.Sort(files)
+		base := baseURL(r)
+		modified := feedModified(torrents)
+		rss := feed.NewRSS(title, base+"/", title+" on DHT-ES-Search", base+r.URL.Path)
+		rss.Channel.LastBuildDate = feed.RSSTime(modified)
+		for _, t := range torrents {
+			rss.Channel.Items = append(rss.Channel.Items, rssItem(base, t))
+		}
+		app.writeFeed(w, r, "application/rss+xml; charset=utf-8", modified, rss)
+	}
+}
 
-	data := DetailData{
-		Title: "Details: " + name,
-		Torrent: bitTorrent{
-			InfoHash:  infohash,
-			Name:      name,
-			HaveFiles: haveFiles,
-			Files:     files,
-			Length:    humanizeFileSize(length),
+func atomEntry(base string, t search.Torrent) feed.Entry {
+	added, updated := t.AddedTime(), t.UpdatedTime()
+	if updated.IsZero() {
+		updated = added
+	}
+	return feed.Entry{
+		ID:        "urn:btih:" + t.InfoHash,
+		Title:     t.Name,
+		Published: feed.AtomTime(added),
+		Updated:   feed.AtomTime(atomUpdated(updated)),
+		Links: []feed.Link{
+			{Href: base + "/details/?id=" + strconv.FormatInt(t.ID, 10), Rel: "alternate", Type: "text/html"},
+			{Href: magnetURI(t.InfoHash, t.Name), Rel: "enclosure", Type: feed.TypeBitTorrent, Length: t.Length},
 		},
-		Addeded: addeded,
-		Updated: updated,
+		Summary: feedSummary(t),
 	}
+}
 
-	if err := app.Templates.Details.ExecuteTemplate(w, "base", data); err != nil {
-		app.Logger.Printf("Template execution error: %v", err)
-		http.Error(w, "Template Error", http.StatusInternalServerError)
+// Atom 要求 updated，时间未知时使用固定的时间，使内容和 ETag 不随轮询变化
+func atomUpdated(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Unix(0, 0).UTC()
 	}
+	return t
 }
 
-func (app *AppConfig) getTorrentFiles(torrentID int64) (Files, error) {
-	files := Files{}
+// GET /feed/search.atom，参数与搜索页相同，只有第一页。默认按收录时间从新到旧，
+// 订阅后可以收到新匹配的种子
+func (app *AppConfig) atomSearchHandler(w http.ResponseWriter, r *http.Request) {
+	form := r.URL.Query()
+	form.Del("cursor")
+	form.Del("page")
+	form.Set("nocorrect", "1")
+	if form.Get("order") == "" {
+		form.Set("order", search.OrderAdded)
+	}
 
-	rows, err := app.DB.Query("SELECT path, length FROM files WHERE infohash_id=?", torrentID)
+	page, err := app.searchTorrents(r.Context(), form, false)
 	if err != nil {
-		return files, err
+		app.searchError(w, "getting search feed", err)
+		return
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var path string
-		var length int
+	title := "All torrents"
+	if page.Query != "" {
+		title = "Search: " + page.Query
+	}
+	base := baseURL(r)
+	self := base + r.URL.RequestURI()
+	modified := feedModified(page.Result.Torrents)
+	atom := &feed.Atom{
+		ID:      self,
+		Title:   title,
+		Updated: feed.AtomTime(atomUpdated(modified)),
+		Links: []feed.Link{
+			{Href: self, Rel: "self", Type: "application/atom+xml"},
+			{Href: base + searchURL(page.Query, page.Order, page.Asc, page.Filter), Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for _, t := range page.Result.Torrents {
+		atom.Entries = append(atom.Entries, atomEntry(base, t))
+	}
+	app.writeFeed(w, r, "application/atom+xml; charset=utf-8", modified, atom)
+}
 
-		if err := rows.Scan(&path, &length); err != nil {
-			app.Logger.Printf("Error scanning file row: %v", err)
-			continue
-		}
+func (app *AppConfig) detailsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
 
-		files = append(files, file{
-			Path:   path,
-			Length: humanizeFileSize(length),
-		})
+	t, err := app.Search.Get(r.Context(), id)
+	if errors.Is(err, search.ErrNotFound) {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.searchError(w, "getting torrent details", err)
+		return
+	}
+
+	app.Logger.Printf("Details request for: %s (%s)", t.Name, humanizeFileSize(int(t.Length)))
+
+	torrent := torrentView(*t)
+	if torrent.Files == nil {
+		torrent.Files = Files{}
+	}
+
+	data := DetailData{
+		Title:   "Details: " + t.Name,
+		Torrent: torrent,
+		Addeded: t.Addeded,
+		Updated: t.Updated,
 	}
 
-	return files, nil
+	if err := app.Templates.Details.ExecuteTemplate(w, "base", data); err != nil {
+		app.Logger.Printf("Template execution error: %v", err)
+		http.Error(w, "Template Error", http.StatusInternalServerError)
+	}
 }
 
 func (app *AppConfig) setupRoutes() *mux.Router {
@@ -813,6 +1623,17 @@ func (app *AppConfig) setupRoutes() *mux.Router {
 	r.HandleFunc("/", app.mainHandler).Methods("GET")
 	r.HandleFunc("/search/", app.searchHandler).Methods("GET")
 	r.HandleFunc("/details/", app.detailsHandler).Methods("GET")
+	r.HandleFunc("/api/suggest", app.suggestHandler).Methods("GET")
+	r.HandleFunc("/api/v1/openapi.json", app.apiDocHandler).Methods("GET")
+	r.HandleFunc("/api/v1/search", app.apiSearchHandler).Methods("GET")
+	r.HandleFunc("/api/v1/torrents/{infohash}", app.apiTorrentHandler).Methods("GET")
+	r.HandleFunc("/api/v1/torrents/{infohash}/files", app.apiFilesHandler).Methods("GET")
+	r.HandleFunc("/api/v1/latest", app.apiListHandler(app.Search.Latest)).Methods("GET")
+	r.HandleFunc("/api/v1/popular", app.apiListHandler(app.Search.Popular)).Methods("GET")
+	r.HandleFunc("/torznab/api", app.torznabHandler).Methods("GET")
+	r.HandleFunc("/feed/latest.rss", app.rssListHandler("Latest torrents", app.Search.Latest)).Methods("GET")
+	r.HandleFunc("/feed/popular.rss", app.rssListHandler("Popular torrents", app.Search.Popular)).Methods("GET")
+	r.HandleFunc("/feed/search.atom", app.atomSearchHandler).Methods("GET")
 
 	staticHandler := http.StripPrefix("/static/", http.FileServer(http.Dir("./static/")))
 	r.PathPrefix("/static/").Handler(staticHandler)
@@ -820,14 +1641,133 @@ func (app *AppConfig) setupRoutes() *mux.Router {
 	return r
 }
 
+// 调试接口单独监听，不挂在对外的路由上：/debug/vars 包含命令行参数、内存统计和缓存命中率
+func (app *AppConfig) serveDebug() {
+	if app.DebugAddr == "" {
+		return
+	}
+	if host, _, err := net.SplitHostPort(app.DebugAddr); err != nil || !isLoopback(host) {
+		app.Logger.Printf("Warning: debug server %s is not bound to localhost", app.DebugAddr)
+	}
+
+	debugMux := http.NewServeMux()
+	debugMux.Handle("/debug/vars", expvar.Handler())
+	app.Logger.Printf("Debug server listening on %s", app.DebugAddr)
+	if err := http.ListenAndServe(app.DebugAddr, debugMux); err != nil {
+		app.Logger.Printf("Debug server failed: %v", err)
+	}
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// 重建 ES 索引：导入新版本索引后切换别名，搜索不中断
+func (app *AppConfig) runReindex(args []string) error {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	replicas := fs.Int("replicas", 1, "导入完成后的副本数")
+	batch := fs.Int("batch", 1000, "每批从 MySQL 读取的记录数")
+	workers := fs.Int("workers", 2, "bulk 并发数")
+	force := fs.Bool("force", false, "文档数量校验失败时仍然切换别名")
+	fs.Parse(args)
+
+	if app.DB == nil {
+		return errors.New("reindex reads from MySQL and is not available in embedded mode")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	result, err := esindex.Reindex(ctx, app.ES, app.DB, esindex.ReindexOptions{
+		Alias:     app.Index,
+		Replicas:  *replicas,
+		BatchSize: *batch,
+		Workers:   *workers,
+		Force:     *force,
+		Logger:    app.Logger,
+	})
+	if err != nil {
+		return err
+	}
+
+	app.Logger.Printf("重建完成: %s, 共 %d 条", result.Index, result.Indexed)
+	if len(result.Previous) > 0 {
+		app.Logger.Printf("旧索引 %s 已不再使用，确认无误后可删除", strings.Join(result.Previous, ", "))
+	}
+	return nil
+}
+
+// 写入索引模板，别名不存在时创建空索引，并报告现有索引与代码定义的偏差
+func (app *AppConfig) initIndex(ctx context.Context, replicas int) error {
+	result, err := esindex.Init(ctx, app.ES, app.Index, replicas)
+	if err != nil {
+		return err
+	}
+
+	if !result.IK {
+		app.Logger.Printf("IK analysis plugin not found, using built-in analyzers")
+	}
+	if result.Created != "" {
+		app.Logger.Printf("Created index %s for alias %s", result.Created, app.Index)
+	}
+	for _, d := range result.Drift {
+		app.Logger.Printf("Mapping drift: %s", d)
+	}
+	if len(result.Drift) > 0 {
+		app.Logger.Printf("Run \"webinterface reindex\" to rebuild the index with the current mapping")
+	}
+	return nil
+}
+
 func main() {
+	flag.Parse()
+
 	app, err := newAppConfig()
 	if err != nil {
 		log.Fatalf("Application initialization failed: %v", err)
 	}
-	defer app.DB.Close()
+	if app.DB != nil {
+		defer app.DB.Close()
+	}
+
+	// 使用 MySQL 后端时，维护 ES 索引的命令仍需连接 ES
+	if cmd := flag.Arg(0); (cmd == "es-init" || cmd == "reindex") && app.ES == nil {
+		if err := app.setupElasticsearch(); err != nil {
+			app.Logger.Fatalf("Elasticsearch setup failed: %v", err)
+		}
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "", "serve":
+		// ES 暂时不可用时仍然启动，搜索请求会各自报错
+		if app.ES != nil {
+			if err := app.initIndex(context.Background(), 1); err != nil {
+				app.Logger.Printf("Index initialization failed: %v", err)
+			}
+		}
+	case "es-init":
+		fs := flag.NewFlagSet("es-init", flag.ExitOnError)
+		replicas := fs.Int("replicas", 1, "新建索引的副本数")
+		fs.Parse(flag.Args()[1:])
+		if err := app.initIndex(context.Background(), *replicas); err != nil {
+			app.Logger.Fatalf("Index initialization failed: %v", err)
+		}
+		return
+	case "reindex":
+		if err := app.runReindex(flag.Args()[1:]); err != nil {
+			app.Logger.Fatalf("Reindex failed: %v", err)
+		}
+		return
+	default:
+		app.Logger.Fatalf("Unknown command: %s", cmd)
+	}
 
 	router := app.setupRoutes()
+	go app.serveDebug()
 
 	app.Logger.Printf("Listening on %s:%s", app.BindInterface, app.BindPort)
 	if err := http.ListenAndServe(app.BindInterface+":"+app.BindPort, router); err != nil {
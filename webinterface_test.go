@@ -0,0 +1,522 @@
+package main
+
+// 根目录有 spider.go 和 webinterface.go 两个 main，按文件运行：
+// go test webinterface.go webinterface_test.go
+
+import (
+	"DHT-ES-Search/cursor"
+	"DHT-ES-Search/search"
+	"DHT-ES-Search/torznab"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 替代搜索后端，返回预设的结果并记录收到的查询；未用到的方法由嵌入的 nil 接口 panic
+type stubBackend struct {
+	search.Backend
+
+	result   search.Result
+	err      error
+	torrents []search.Torrent // hash: 查询和 Get 在其中查找
+
+	mu      sync.Mutex
+	queries []search.Query
+	listN   int // Latest、Popular 收到的数量
+}
+
+func (b *stubBackend) Search(ctx context.Context, q search.Query) (search.Result, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queries = append(b.queries, q)
+	if b.err != nil {
+		return search.Result{}, b.err
+	}
+	if hash, ok := strings.CutPrefix(q.Text, "hash:"); ok {
+		var res search.Result
+		for _, t := range b.torrents {
+			if t.InfoHash == hash {
+				res.Torrents, res.Total = []search.Torrent{t}, 1
+			}
+		}
+		return res, nil
+	}
+	return b.result, nil
+}
+
+func (b *stubBackend) Get(ctx context.Context, id int64) (*search.Torrent, error) {
+	for _, t := range b.torrents {
+		if t.ID == id {
+			return &t, nil
+		}
+	}
+	return nil, search.ErrNotFound
+}
+
+func (b *stubBackend) Latest(ctx context.Context, n int) ([]search.Torrent, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listN = n
+	return b.result.Torrents, b.err
+}
+
+func (b *stubBackend) Popular(ctx context.Context, n int) ([]search.Torrent, error) {
+	return b.Latest(ctx, n)
+}
+
+func (b *stubBackend) Correct(ctx context.Context, terms []string) ([]string, error) {
+	return nil, nil
+}
+
+func (b *stubBackend) lastQuery() search.Query {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.queries) == 0 {
+		return search.Query{}
+	}
+	return b.queries[len(b.queries)-1]
+}
+
+func newTestApp(t *testing.T, b *stubBackend) *AppConfig {
+	t.Helper()
+	signer, err := cursor.NewSigner([]byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AppConfig{
+		Search:     b,
+		Queries:    search.NewQueryStats(suggestQueryStats),
+		Cursors:    signer,
+		TorznabKey: "secret",
+		Logger:     log.New(io.Discard, "", 0),
+	}
+}
+
+// 经路由发出 GET 请求
+func serve(app *AppConfig, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	app.setupRoutes().ServeHTTP(w, req)
+	return w
+}
+
+func TestTorznabQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		form string
+		code int // torznab 错误码，0 表示成功
+		want search.Query
+	}{
+		{"missing function", "q=ubuntu", torznab.ErrNoSuchFunction, search.Query{}},
+		{"unknown function", "t=music&q=ubuntu", torznab.ErrNoSuchFunction, search.Query{}},
+		{"search", `t=search&q="the matrix" -cam`, 0,
+			search.Query{Text: "the matrix cam", Order: search.OrderRelevance, Size: torznabMaxLimit}},
+		{"rss sync", "t=search&limit=20&offset=40", 0,
+			search.Query{Order: search.OrderRelevance, Size: 20, From: 40}},
+		{"limit capped", "t=search&limit=1000", 0,
+			search.Query{Order: search.OrderRelevance, Size: torznabMaxLimit}},
+		{"offset capped", "t=search&offset=999999999", 0,
+			search.Query{Order: search.OrderRelevance, Size: torznabMaxLimit, From: maxJumpResults}},
+		{"categories", "t=search&cat=5040,3000", 0,
+			search.Query{Order: search.OrderRelevance, Size: torznabMaxLimit, Filter: search.Filter{Categories: []string{"audio", "tv"}}}},
+		{"tv", "t=tvsearch&q=show&season=2&ep=5", 0,
+			search.Query{Text: "show", Order: search.OrderRelevance, Size: torznabMaxLimit, Filter: search.Filter{Season: 2, Episode: 5}}},
+		{"daily episode", "t=tvsearch&q=show&ep=05/12", 0,
+			search.Query{Text: "show", Order: search.OrderRelevance, Size: torznabMaxLimit}},
+		{"movie", "t=movie&q=film&year=1999", 0,
+			search.Query{Text: "film", Order: search.OrderRelevance, Size: torznabMaxLimit, Filter: search.Filter{Year: 1999}}},
+		// 不支持按 IMDb 编号搜索，caps 中也不声明
+		{"imdbid ignored", "t=movie&imdbid=tt0133093", 0,
+			search.Query{Order: search.OrderRelevance, Size: torznabMaxLimit}},
+		// season 只对 tvsearch 有效
+		{"season ignored", "t=search&season=x", 0,
+			search.Query{Order: search.OrderRelevance, Size: torznabMaxLimit}},
+		{"bad season", "t=tvsearch&season=x", torznab.ErrIncorrectParameter, search.Query{}},
+		{"bad episode", "t=tvsearch&ep=-1", torznab.ErrIncorrectParameter, search.Query{}},
+		{"bad year", "t=movie&year=199x", torznab.ErrIncorrectParameter, search.Query{}},
+		{"bad limit", "t=search&limit=ten", torznab.ErrIncorrectParameter, search.Query{}},
+		{"negative offset", "t=search&offset=-5", torznab.ErrIncorrectParameter, search.Query{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form, err := url.ParseQuery(tt.form)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := torznabQuery(form)
+			if tt.code != 0 {
+				tzErr, ok := err.(*torznab.Error)
+				if !ok || tzErr.Code != tt.code {
+					t.Fatalf("torznabQuery(%s) error = %v, want code %d", tt.form, err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("torznabQuery(%s) error: %v", tt.form, err)
+			}
+			if !reflect.DeepEqual(q, tt.want) {
+				t.Errorf("torznabQuery(%s)\n got %+v\nwant %+v", tt.form, q, tt.want)
+			}
+		})
+	}
+}
+
+func TestTorznabHandler(t *testing.T) {
+	b := &stubBackend{result: search.Result{Total: 1, Torrents: []search.Torrent{
+		{ID: 1, InfoHash: strings.Repeat("ab", 20), Name: "Show.S02E05.1080p", Length: 1 << 30, Category: "tv", Resolution: "1080p", Cnt: 3},
+	}}}
+	app := newTestApp(t, b)
+
+	tests := []struct {
+		name  string
+		query string
+		code  int // torznab 错误码，0 表示返回结果
+	}{
+		{"caps without key", "t=caps", 0},
+		{"missing key", "t=search&q=show", torznab.ErrIncorrectCredentials},
+		{"wrong key", "t=search&q=show&apikey=wrong", torznab.ErrIncorrectCredentials},
+		{"bad parameter", "t=tvsearch&season=x&apikey=secret", torznab.ErrIncorrectParameter},
+		{"no such function", "t=book&apikey=secret", torznab.ErrNoSuchFunction},
+		{"search", "t=tvsearch&q=show&season=2&apikey=secret", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.torznabHandler(w, httptest.NewRequest(http.MethodGet, "/torznab/api?"+tt.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", w.Code)
+			}
+
+			var tzErr torznab.Error
+			err := xml.Unmarshal(w.Body.Bytes(), &tzErr)
+			if tt.code != 0 {
+				if err != nil || tzErr.Code != tt.code {
+					t.Errorf("response %s, want error code %d", w.Body, tt.code)
+				}
+				return
+			}
+			if err == nil {
+				t.Errorf("unexpected error response %s", w.Body)
+			}
+		})
+	}
+
+	// 未配置 API key 时只响应 caps
+	app.TorznabKey = ""
+	w := httptest.NewRecorder()
+	app.torznabHandler(w, httptest.NewRequest(http.MethodGet, "/torznab/api?t=search&apikey=", nil))
+	if !strings.Contains(w.Body.String(), `code="100"`) {
+		t.Errorf("empty key accepted: %s", w.Body)
+	}
+}
+
+// caps 声明的参数都由 torznabQuery 处理
+func TestTorznabCaps(t *testing.T) {
+	handled := map[string]bool{"q": true, "season": true, "ep": true, "year": true}
+	s := torznabCaps().Searching
+	for _, c := range []torznab.SearchCaps{s.Search, s.TVSearch, s.MovieSearch} {
+		for _, p := range strings.Split(c.SupportedParams, ",") {
+			if !handled[p] {
+				t.Errorf("caps advertise %q, which torznabQuery ignores", p)
+			}
+		}
+	}
+}
+
+var (
+	multiHash  = strings.Repeat("1a", 20)
+	singleHash = strings.Repeat("2b", 20)
+	oldHash    = strings.Repeat("3c", 20)
+)
+
+var apiTorrents = []search.Torrent{
+	{ID: 1, InfoHash: multiHash, Name: "Album", Length: 31 << 20, HasFiles: true, FileCount: 2, Files: []search.File{
+		{Path: "01 - Intro.flac", Length: 30 << 20}, {Path: "cover.jpg", Length: 1 << 20},
+	}},
+	{ID: 2, InfoHash: singleHash, Name: "ubuntu.iso", Length: 6 << 30, FileCount: 1},
+	// 文件列表缺失的多文件种子不能当作单文件
+	{ID: 3, InfoHash: oldHash, Name: "Old", Length: 100, HasFiles: true},
+}
+
+// 搜索错误经 searchStatus 映射为状态码和 {"error": ...}
+func TestAPIStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		err        error // 后端返回的错误
+		status     int
+		msg        string
+		retryAfter string
+	}{
+		{"syntax error", `/api/v1/search?q=foo+%22bar`, nil, http.StatusBadRequest, "syntax error at position 5: missing closing quote", ""},
+		{"bad cursor", "/api/v1/search?cursor=garbage", nil, http.StatusBadRequest, "Invalid cursor", ""},
+		{"bad sort value", "/api/v1/search?q=ubuntu", search.ErrInvalidCursor, http.StatusBadRequest, "Invalid sort value", ""},
+		{"unknown hash", "/api/v1/torrents/" + strings.Repeat("0", 40), nil, http.StatusNotFound, "Not Found", ""},
+		{"malformed hash", "/api/v1/torrents/xyz", nil, http.StatusNotFound, "Not Found", ""},
+		{"unknown hash files", "/api/v1/torrents/" + strings.Repeat("0", 40) + "/files", nil, http.StatusNotFound, "Not Found", ""},
+		{"busy", "/api/v1/search?q=ubuntu", &search.ElasticError{Status: http.StatusTooManyRequests}, http.StatusServiceUnavailable, "Search Service Busy", "10"},
+		{"unavailable", "/api/v1/torrents/" + singleHash, &search.ElasticError{Status: http.StatusServiceUnavailable}, http.StatusServiceUnavailable, "Search Service Busy", "10"},
+		{"no index", "/api/v1/search?q=ubuntu", &search.ElasticError{Status: http.StatusNotFound, Type: "index_not_found_exception"}, http.StatusServiceUnavailable, "Search Index Not Available", ""},
+		{"bad query", "/api/v1/search?q=ubuntu", &search.ElasticError{Status: http.StatusBadRequest}, http.StatusBadRequest, "Invalid Search Query", ""},
+		{"elastic error", "/api/v1/search?q=ubuntu", &search.ElasticError{Status: http.StatusInternalServerError}, http.StatusBadGateway, "Search Service Error", ""},
+		{"timeout", "/api/v1/search?q=ubuntu", context.DeadlineExceeded, http.StatusGatewayTimeout, "Search Timeout", ""},
+		{"internal", "/api/v1/latest", errors.New("boom"), http.StatusInternalServerError, "Internal Server Error", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApp(t, &stubBackend{err: tt.err, torrents: apiTorrents})
+			w := serve(app, tt.target, nil)
+
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body %q is not JSON: %v", w.Body, err)
+			}
+			if w.Code != tt.status || body.Error != tt.msg {
+				t.Errorf("got %d %q, want %d %q", w.Code, body.Error, tt.status, tt.msg)
+			}
+			if got := w.Header().Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.retryAfter)
+			}
+		})
+	}
+}
+
+// 第一页只有 next_cursor，中间页两者都有，最后一页只有 prev_cursor
+func TestAPISearchCursors(t *testing.T) {
+	next := []interface{}{json.Number("80"), json.Number("19")}
+	prev := []interface{}{json.Number("90"), json.Number("10")}
+	b := &stubBackend{result: search.Result{
+		Torrents: apiTorrents[:2],
+		Total:    100, // 4 页
+		Next:     next,
+		Prev:     prev,
+		PIT:      "pit-1",
+	}}
+	app := newTestApp(t, b)
+
+	type page struct {
+		Page       int    `json:"page"`
+		TotalPages int    `json:"total_pages"`
+		PrevCursor string `json:"prev_cursor"`
+		NextCursor string `json:"next_cursor"`
+	}
+	get := func(target string) page {
+		t.Helper()
+		w := serve(app, target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %s", target, w.Code, w.Body)
+		}
+		var p page
+		if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	first := get("/api/v1/search?q=ubuntu&order=updated")
+	if first.Page != 1 || first.TotalPages != 4 || first.PrevCursor != "" || first.NextCursor == "" {
+		t.Fatalf("first page = %+v", first)
+	}
+	if q := b.lastQuery(); !q.Snapshot || q.After != nil {
+		t.Errorf("first page query = %+v, want a snapshot without a position", q)
+	}
+
+	second := get("/api/v1/search?cursor=" + first.NextCursor)
+	if second.Page != 2 || second.PrevCursor == "" || second.NextCursor == "" {
+		t.Errorf("second page = %+v", second)
+	}
+	q := b.lastQuery()
+	if q.Text != "ubuntu" || q.Order != search.OrderUpdated || !reflect.DeepEqual(q.After, next) || q.PIT != "pit-1" {
+		t.Errorf("second page query = %+v", q)
+	}
+
+	// 从第二页回到第一页不需要排序位置
+	get("/api/v1/search?cursor=" + second.PrevCursor)
+	if q := b.lastQuery(); q.After != nil || q.Before != nil || q.From != 0 {
+		t.Errorf("back to first page query = %+v", q)
+	}
+
+	last := get("/api/v1/search?page=4&q=ubuntu&order=updated")
+	if last.Page != 4 || last.PrevCursor == "" || last.NextCursor != "" {
+		t.Errorf("last page = %+v", last)
+	}
+
+	// 后端没有返回排序位置时没有下一页游标
+	b.result.Next = nil
+	if p := get("/api/v1/search?q=ubuntu"); p.NextCursor != "" {
+		t.Errorf("next_cursor without a sort position: %+v", p)
+	}
+}
+
+func TestAPIFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+		want string
+	}{
+		{"multi-file", multiHash, `[{"path":"01 - Intro.flac","size":31457280},{"path":"cover.jpg","size":1048576}]`},
+		// 单文件种子以名称为路径
+		{"single file", singleHash, `[{"path":"ubuntu.iso","size":6442450944}]`},
+		{"missing file list", oldHash, `[]`},
+	}
+
+	app := newTestApp(t, &stubBackend{torrents: apiTorrents})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// infohash 不区分大小写
+			w := serve(app, "/api/v1/torrents/"+strings.ToUpper(tt.hash)+"/files", nil)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d %s", w.Code, w.Body)
+			}
+			var body struct {
+				InfoHash string          `json:"infohash"`
+				Files    json.RawMessage `json:"files"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.InfoHash != tt.hash || string(body.Files) != tt.want {
+				t.Errorf("got %s %s, want %s %s", body.InfoHash, body.Files, tt.hash, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPITorrentAndLists(t *testing.T) {
+	b := &stubBackend{torrents: apiTorrents, result: search.Result{Torrents: apiTorrents}}
+	app := newTestApp(t, b)
+
+	w := serve(app, "/api/v1/torrents/"+singleHash, nil)
+	var torrent struct {
+		InfoHash string `json:"infohash"`
+		Magnet   string `json:"magnet"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &torrent); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET torrent = %d %s", w.Code, w.Body)
+	}
+	// magnet 中的 & 不转义为 \u0026
+	if torrent.InfoHash != singleHash || !strings.Contains(w.Body.String(), "&dn=ubuntu.iso") {
+		t.Errorf("torrent = %s", w.Body)
+	}
+
+	tests := []struct {
+		target string
+		n      int
+	}{
+		{"/api/v1/latest", apiListLimit},
+		{"/api/v1/popular?limit=10", 10},
+		{"/api/v1/latest?limit=1000", apiListMaxLimit},
+		{"/api/v1/latest?limit=-1", apiListLimit},
+	}
+	for _, tt := range tests {
+		w := serve(app, tt.target, nil)
+		var list struct {
+			Results []json.RawMessage `json:"results"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %s", tt.target, w.Code, w.Body)
+		}
+		if b.listN != tt.n || len(list.Results) != len(apiTorrents) {
+			t.Errorf("GET %s asked for %d torrents and returned %d, want %d", tt.target, b.listN, len(list.Results), tt.n)
+		}
+	}
+}
+
+var feedTorrents = []search.Torrent{
+	{ID: 1, InfoHash: multiHash, Name: "Album", Length: 31 << 20, FileCount: 2, Addeded: "2024-05-01 10:00:00", Updated: "2024-05-02 12:00:00"},
+	{ID: 2, InfoHash: singleHash, Name: "ubuntu.iso", Length: 6 << 30, FileCount: 1, Addeded: "2024-04-30 08:00:00", Updated: "2024-04-30 08:00:00"},
+}
+
+// 订阅程序带上次的 ETag 或 Last-Modified 轮询，内容未变化时返回 304
+func TestFeedConditional(t *testing.T) {
+	app := newTestApp(t, &stubBackend{result: search.Result{Torrents: feedTorrents, Total: len(feedTorrents)}})
+
+	for _, target := range []string{"/feed/latest.rss", "/feed/popular.rss", "/feed/search.atom?q=ubuntu"} {
+		t.Run(target, func(t *testing.T) {
+			first := serve(app, target, nil)
+			etag, modified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+			if first.Code != http.StatusOK || etag == "" || first.Body.Len() == 0 {
+				t.Fatalf("first poll = %d, ETag %q, %d bytes", first.Code, etag, first.Body.Len())
+			}
+			// 最新的更新时间 2024-05-02 12:00:00，按本地时区解析
+			if want := feedModified(feedTorrents).UTC().Format(http.TimeFormat); modified != want {
+				t.Errorf("Last-Modified = %q, want %q", modified, want)
+			}
+
+			tests := []struct {
+				name   string
+				header http.Header
+				status int
+			}{
+				{"same etag", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+				{"one of several etags", http.Header{"If-None-Match": {`"other", ` + etag}}, http.StatusNotModified},
+				{"changed etag", http.Header{"If-None-Match": {`"other"`}}, http.StatusOK},
+				{"not modified since", http.Header{"If-Modified-Since": {modified}}, http.StatusNotModified},
+				{"modified since", http.Header{"If-Modified-Since": {"Mon, 01 Jan 2024 00:00:00 GMT"}}, http.StatusOK},
+				// 两者都有时以 ETag 为准
+				{"etag wins", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {modified}}, http.StatusOK},
+			}
+			for _, tt := range tests {
+				w := serve(app, target, tt.header)
+				if w.Code != tt.status {
+					t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+				}
+				if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+					t.Errorf("%s: 304 with a %d byte body", tt.name, w.Body.Len())
+				}
+				if tt.status == http.StatusOK && w.Body.String() != first.Body.String() {
+					t.Errorf("%s: body differs from the first poll", tt.name)
+				}
+			}
+		})
+	}
+}
+
+// 种子没有时间时 Atom 的 updated 使用固定时间，ETag 在轮询之间不变
+func TestAtomUpdatedFallback(t *testing.T) {
+	b := &stubBackend{result: search.Result{Total: 1, Torrents: []search.Torrent{
+		{ID: 2, InfoHash: singleHash, Name: "ubuntu.iso", Length: 6 << 30, FileCount: 1},
+	}}}
+	app := newTestApp(t, b)
+
+	first := serve(app, "/feed/search.atom?q=ubuntu", nil)
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first poll = %d, ETag %q", first.Code, etag)
+	}
+	if got := first.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("Last-Modified = %q for torrents without times", got)
+	}
+	if n := strings.Count(first.Body.String(), "<updated>1970-01-01T00:00:00Z</updated>"); n != 2 {
+		t.Errorf("feed and entry updated not fixed (%d found):\n%s", n, first.Body)
+	}
+
+	second := serve(app, "/feed/search.atom?q=ubuntu", nil)
+	if got := second.Header().Get("ETag"); got != etag {
+		t.Errorf("ETag changed between polls: %q, then %q", etag, got)
+	}
+	if w := serve(app, "/feed/search.atom?q=ubuntu", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("repeated poll = %d, want 304", w.Code)
+	}
+	if q := b.lastQuery(); q.Order != search.OrderAdded || q.Snapshot {
+		t.Errorf("feed query = %+v, want newest first without a snapshot", q)
+	}
+}